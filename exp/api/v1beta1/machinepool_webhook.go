@@ -17,14 +17,24 @@ limitations under the License.
 package v1beta1
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/blang/semver"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/klog/v2"
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
@@ -33,20 +43,39 @@ import (
 	"sigs.k8s.io/cluster-api/util/version"
 )
 
-func (m *MachinePool) SetupWebhookWithManager(mgr ctrl.Manager) error {
+const defaultNodeDeletionTimeout = 10 * time.Second
+
+// maxNameLength is the max length of the MachinePool name; Machines for the MachinePool are named appending
+// a "-" and a 5 chars random suffix to the MachinePool name, and they must not exceed the 63 chars label limit.
+const maxNameLength = validation.DNS1123LabelMaxLength - 6
+
+// SetupWebhookWithManager sets up MachinePool webhooks.
+func (webhook *MachinePoolWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewWebhookManagedBy(mgr).
-		For(m).
+		For(&MachinePool{}).
+		WithDefaulter(webhook).
+		WithValidator(webhook).
 		Complete()
 }
 
 // +kubebuilder:webhook:verbs=create;update,path=/validate-cluster-x-k8s-io-v1beta1-machinepool,mutating=false,failurePolicy=fail,matchPolicy=Equivalent,groups=cluster.x-k8s.io,resources=machinepools,versions=v1beta1,name=validation.machinepool.cluster.x-k8s.io,sideEffects=None,admissionReviewVersions=v1;v1beta1
 // +kubebuilder:webhook:verbs=create;update,path=/mutate-cluster-x-k8s-io-v1beta1-machinepool,mutating=true,failurePolicy=fail,matchPolicy=Equivalent,groups=cluster.x-k8s.io,resources=machinepools,versions=v1beta1,name=default.machinepool.cluster.x-k8s.io,sideEffects=None,admissionReviewVersions=v1;v1beta1
 
-var _ webhook.Defaulter = &MachinePool{}
-var _ webhook.Validator = &MachinePool{}
+// MachinePoolWebhook implements a validating and defaulting webhook for MachinePool.
+type MachinePoolWebhook struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomDefaulter = &MachinePoolWebhook{}
+var _ webhook.CustomValidator = &MachinePoolWebhook{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type.
+func (webhook *MachinePoolWebhook) Default(_ context.Context, obj runtime.Object) error {
+	m, ok := obj.(*MachinePool)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a MachinePool but got a %T", obj))
+	}
 
-// Default implements webhook.Defaulter so a webhook will be registered for the type.
-func (m *MachinePool) Default() {
 	if m.Labels == nil {
 		m.Labels = make(map[string]string)
 	}
@@ -60,6 +89,10 @@ func (m *MachinePool) Default() {
 		m.Spec.MinReadySeconds = pointer.Int32(0)
 	}
 
+	if m.Spec.Template.Spec.NodeDeletionTimeout == nil {
+		m.Spec.Template.Spec.NodeDeletionTimeout = &metav1.Duration{Duration: defaultNodeDeletionTimeout}
+	}
+
 	if m.Spec.Template.Spec.Bootstrap.ConfigRef != nil && m.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace == "" {
 		m.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace = m.Namespace
 	}
@@ -69,41 +102,58 @@ func (m *MachinePool) Default() {
 	}
 
 	// tolerate version strings without a "v" prefix: prepend it if it's not there.
-	if m.Spec.Template.Spec.Version != nil && !strings.HasPrefix(*m.Spec.Template.Spec.Version, "v") {
+	// NOTE: pre-release and build metadata are preserved (e.g. 1.28.0+vmware.1 becomes v1.28.0+vmware.1), while invalid
+	// versions are not modified, so validation errors report the version as set by the user.
+	if m.Spec.Template.Spec.Version != nil && !strings.HasPrefix(*m.Spec.Template.Spec.Version, "v") &&
+		version.KubeSemverTolerant.MatchString(*m.Spec.Template.Spec.Version) {
 		normalizedVersion := "v" + *m.Spec.Template.Spec.Version
 		m.Spec.Template.Spec.Version = &normalizedVersion
 	}
+	return nil
 }
 
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
-func (m *MachinePool) ValidateCreate() (admission.Warnings, error) {
-	return nil, m.validate(nil)
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type.
+func (webhook *MachinePoolWebhook) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	m, ok := obj.(*MachinePool)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MachinePool but got a %T", obj))
+	}
+	return webhook.validate(ctx, nil, m)
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
-func (m *MachinePool) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
-	oldMP, ok := old.(*MachinePool)
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type.
+func (webhook *MachinePoolWebhook) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldMP, ok := oldObj.(*MachinePool)
 	if !ok {
-		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MachinePool but got a %T", old))
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MachinePool but got a %T", oldObj))
 	}
-	return nil, m.validate(oldMP)
+	newMP, ok := newObj.(*MachinePool)
+	if !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MachinePool but got a %T", newObj))
+	}
+	return webhook.validate(ctx, oldMP, newMP)
 }
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
-func (m *MachinePool) ValidateDelete() (admission.Warnings, error) {
-	return nil, m.validate(nil)
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type.
+// NOTE: MachinePools must always be deletable, e.g. after the Cluster has been deleted, so no validation is run.
+func (webhook *MachinePoolWebhook) ValidateDelete(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	if _, ok := obj.(*MachinePool); !ok {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("expected a MachinePool but got a %T", obj))
+	}
+	return nil, nil
 }
 
-func (m *MachinePool) validate(old *MachinePool) error {
+func (webhook *MachinePoolWebhook) validate(ctx context.Context, old, m *MachinePool) (admission.Warnings, error) {
 	// NOTE: MachinePool is behind MachinePool feature gate flag; the web hook
 	// must prevent creating new objects when the feature flag is disabled.
 	specPath := field.NewPath("spec")
 	if !feature.Gates.Enabled(feature.MachinePool) {
-		return field.Forbidden(
+		return nil, field.Forbidden(
 			specPath,
 			"can be set only if the MachinePool feature flag is enabled",
 		)
 	}
+	var allWarnings admission.Warnings
 	var allErrs field.ErrorList
 	if m.Spec.Template.Spec.Bootstrap.ConfigRef == nil && m.Spec.Template.Spec.Bootstrap.DataSecretName == nil {
 		allErrs = append(
@@ -115,6 +165,22 @@ func (m *MachinePool) validate(old *MachinePool) error {
 		)
 	}
 
+	// NOTE: when using a bootstrap configRef, the MachinePool controller sets dataSecretName to the secret generated
+	// by the bootstrap provider, so both fields are set on existing MachinePools and on MachinePools re-created
+	// e.g. by clusterctl move or by restoring a backup; as a consequence, it is only possible to prevent users
+	// from adding a configRef to a MachinePool using dataSecretName.
+	if m.Spec.Template.Spec.Bootstrap.ConfigRef != nil && m.Spec.Template.Spec.Bootstrap.DataSecretName != nil &&
+		old != nil && old.Spec.Template.Spec.Bootstrap.ConfigRef == nil {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				specPath.Child("template", "spec", "bootstrap"),
+				m.Spec.Template.Spec.Bootstrap,
+				"expected only one of spec.bootstrap.dataSecretName or spec.bootstrap.configRef to be populated",
+			),
+		)
+	}
+
 	if m.Spec.Template.Spec.Bootstrap.ConfigRef != nil && m.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace != m.Namespace {
 		allErrs = append(
 			allErrs,
@@ -126,6 +192,12 @@ func (m *MachinePool) validate(old *MachinePool) error {
 		)
 	}
 
+	if m.Spec.Template.Spec.Bootstrap.ConfigRef != nil {
+		allErrs = append(allErrs, validateObjectReference(m.Spec.Template.Spec.Bootstrap.ConfigRef, specPath.Child("template", "spec", "bootstrap", "configRef"))...)
+	}
+
+	allErrs = append(allErrs, validateObjectReference(&m.Spec.Template.Spec.InfrastructureRef, specPath.Child("template", "spec", "infrastructureRef"))...)
+
 	if m.Spec.Template.Spec.InfrastructureRef.Namespace != m.Namespace {
 		allErrs = append(
 			allErrs,
@@ -137,6 +209,52 @@ func (m *MachinePool) validate(old *MachinePool) error {
 		)
 	}
 
+	if m.Spec.Replicas != nil && *m.Spec.Replicas < 0 {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				specPath.Child("replicas"),
+				*m.Spec.Replicas,
+				"must be greater than or equal to 0",
+			),
+		)
+	}
+
+	if m.Spec.MinReadySeconds != nil && *m.Spec.MinReadySeconds < 0 {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				specPath.Child("minReadySeconds"),
+				*m.Spec.MinReadySeconds,
+				"must be greater than or equal to 0",
+			),
+		)
+	}
+
+	if m.Spec.Template.Spec.NodeDrainTimeout != nil && m.Spec.Template.Spec.NodeDrainTimeout.Duration < 0 {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				specPath.Child("template", "spec", "nodeDrainTimeout"),
+				m.Spec.Template.Spec.NodeDrainTimeout.Duration.String(),
+				"must be greater than or equal to 0",
+			),
+		)
+	}
+
+	if m.Spec.Template.Spec.NodeDeletionTimeout != nil && m.Spec.Template.Spec.NodeDeletionTimeout.Duration < 0 {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				specPath.Child("template", "spec", "nodeDeletionTimeout"),
+				m.Spec.Template.Spec.NodeDeletionTimeout.Duration.String(),
+				"must be greater than or equal to 0",
+			),
+		)
+	}
+
+	allErrs = append(allErrs, validateProviderIDList(m.Spec.ProviderIDList, specPath.Child("providerIDList"))...)
+
 	if old != nil && old.Spec.ClusterName != m.Spec.ClusterName {
 		allErrs = append(
 			allErrs,
@@ -146,17 +264,277 @@ func (m *MachinePool) validate(old *MachinePool) error {
 		)
 	}
 
+	// NOTE: the infrastructure template can be rotated by changing the infrastructureRef name, but changing its
+	// kind or group is not supported because it would orphan the existing infrastructure.
+	if old != nil {
+		oldInfraRef, newInfraRef := old.Spec.Template.Spec.InfrastructureRef, m.Spec.Template.Spec.InfrastructureRef
+		infraRefPath := specPath.Child("template", "spec", "infrastructureRef")
+		if oldInfraRef.Kind != newInfraRef.Kind {
+			allErrs = append(
+				allErrs,
+				field.Forbidden(
+					infraRefPath.Child("kind"),
+					"field is immutable"),
+			)
+		}
+		if oldInfraRef.GroupVersionKind().Group != newInfraRef.GroupVersionKind().Group {
+			allErrs = append(
+				allErrs,
+				field.Forbidden(
+					infraRefPath.Child("apiVersion"),
+					"field group is immutable"),
+			)
+		}
+	}
+
+	// NOTE: re-homing a MachinePool is not supported, so it is not possible to change the cluster name label
+	// to a value different from spec.clusterName.
+	if old != nil {
+		newClusterNameLabel, ok := m.Labels[clusterv1.ClusterNameLabel]
+		if ok && newClusterNameLabel != old.Labels[clusterv1.ClusterNameLabel] && newClusterNameLabel != m.Spec.ClusterName {
+			allErrs = append(
+				allErrs,
+				field.Invalid(
+					field.NewPath("metadata", "labels").Key(clusterv1.ClusterNameLabel),
+					newClusterNameLabel,
+					fmt.Sprintf("must match spec.clusterName %q", m.Spec.ClusterName),
+				),
+			)
+		}
+	}
+
+	// NOTE: it is not possible to set the cluster name label of the template to a value different from spec.clusterName.
+	allErrs = append(allErrs, validateClusterNameLabel(m.Spec.Template.Labels, m.Spec.ClusterName, specPath.Child("template", "metadata", "labels"))...)
+
 	if m.Spec.Template.Spec.Version != nil {
 		if !version.KubeSemver.MatchString(*m.Spec.Template.Spec.Version) {
 			allErrs = append(allErrs, field.Invalid(specPath.Child("template", "spec", "version"), *m.Spec.Template.Spec.Version, "must be a valid semantic version"))
 		}
 	}
 
+	if old != nil {
+		allErrs = append(allErrs, m.validateVersionUpdate(old)...)
+	}
+
+	if old == nil && len(m.Name) > maxNameLength {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				field.NewPath("metadata", "name"),
+				m.Name,
+				fmt.Sprintf("must be no more than %d characters, so the names of the Machines of the MachinePool do not exceed %d characters", maxNameLength, validation.DNS1123LabelMaxLength),
+			),
+		)
+	}
+
+	if old == nil {
+		allErrs = append(allErrs, webhook.validateClusterExists(ctx, m)...)
+	}
+
+	allErrs = append(allErrs, webhook.validateFailureDomains(ctx, old, m)...)
+
 	// Validate the metadata of the MachinePool template.
 	allErrs = append(allErrs, m.Spec.Template.ObjectMeta.Validate(specPath.Child("template", "metadata"))...)
 
+	// Warn about configurations that are likely to stall rollouts; with a single replica there is
+	// no surge capacity, so a new machine instance must become available before the old one goes away.
+	if m.Spec.Replicas != nil && *m.Spec.Replicas == 1 &&
+		m.Spec.MinReadySeconds != nil && *m.Spec.MinReadySeconds > 0 {
+		allWarnings = append(allWarnings,
+			fmt.Sprintf("spec.minReadySeconds is set to %d with a single replica: "+
+				"rollouts may stall because there is no surge capacity while the new machine instance becomes available", *m.Spec.MinReadySeconds),
+		)
+	}
+
+	allWarnings = append(allWarnings, m.deprecationWarnings()...)
+
 	if len(allErrs) == 0 {
+		return allWarnings, nil
+	}
+	return allWarnings, apierrors.NewInvalid(GroupVersion.WithKind("MachinePool").GroupKind(), m.Name, allErrs)
+}
+
+// validateClusterExists validates that the Cluster referenced by the MachinePool exists.
+func (webhook *MachinePoolWebhook) validateClusterExists(ctx context.Context, m *MachinePool) field.ErrorList {
+	// NOTE: an empty clusterName is already rejected by the OpenAPI schema validation.
+	if m.Spec.ClusterName == "" {
+		return nil
+	}
+
+	fldPath := field.NewPath("spec", "clusterName")
+	cluster := &clusterv1.Cluster{}
+	if err := webhook.Client.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.Spec.ClusterName}, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return field.ErrorList{
+				field.Invalid(
+					fldPath,
+					m.Spec.ClusterName,
+					fmt.Sprintf("Cluster %s does not exist", klog.KRef(m.Namespace, m.Spec.ClusterName)),
+				),
+			}
+		}
+		return field.ErrorList{field.InternalError(fldPath, errors.Wrapf(err, "failed to get Cluster %s", klog.KRef(m.Namespace, m.Spec.ClusterName)))}
+	}
+	return nil
+}
+
+// validateFailureDomains validates that the failure domains of the MachinePool are defined in the Cluster.
+func (webhook *MachinePoolWebhook) validateFailureDomains(ctx context.Context, old, m *MachinePool) field.ErrorList {
+	fldPath := field.NewPath("spec", "failureDomains")
+
+	// NOTE: only failure domains added to the MachinePool are validated, so changes to the failure domains
+	// of the Cluster do not block unrelated updates to the MachinePool.
+	failureDomains := sets.New[string](m.Spec.FailureDomains...)
+	if old != nil {
+		failureDomains = failureDomains.Difference(sets.New[string](old.Spec.FailureDomains...))
+	}
+	if failureDomains.Len() == 0 || m.Spec.ClusterName == "" {
 		return nil
 	}
-	return apierrors.NewInvalid(GroupVersion.WithKind("MachinePool").GroupKind(), m.Name, allErrs)
+
+	cluster := &clusterv1.Cluster{}
+	if err := webhook.Client.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.Spec.ClusterName}, cluster); err != nil {
+		// If the Cluster does not exist it is not possible to validate failure domains.
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return field.ErrorList{field.InternalError(fldPath, errors.Wrapf(err, "failed to get Cluster %s", klog.KRef(m.Namespace, m.Spec.ClusterName)))}
+	}
+
+	// If the Cluster did not report failure domains yet, e.g. because the infrastructure is still being
+	// provisioned, it is not possible to validate failure domains.
+	if len(cluster.Status.FailureDomains) == 0 {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	for i, failureDomain := range m.Spec.FailureDomains {
+		if !failureDomains.Has(failureDomain) {
+			continue
+		}
+		if _, ok := cluster.Status.FailureDomains[failureDomain]; !ok {
+			allErrs = append(allErrs,
+				field.Invalid(
+					fldPath.Index(i),
+					failureDomain,
+					fmt.Sprintf("must be one of the failure domains of Cluster %s", cluster.Name),
+				),
+			)
+		}
+	}
+	return allErrs
+}
+
+// validateClusterNameLabel validates that the cluster name label, if set, matches the cluster name.
+func validateClusterNameLabel(labels map[string]string, clusterName string, fldPath *field.Path) field.ErrorList {
+	clusterNameLabel, ok := labels[clusterv1.ClusterNameLabel]
+	if !ok || clusterNameLabel == clusterName {
+		return nil
+	}
+	return field.ErrorList{
+		field.Invalid(
+			fldPath.Key(clusterv1.ClusterNameLabel),
+			clusterNameLabel,
+			fmt.Sprintf("must match spec.clusterName %q", clusterName),
+		),
+	}
+}
+
+// validateObjectReference validates that the name, kind and apiVersion of a reference are populated.
+func validateObjectReference(ref *corev1.ObjectReference, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), "name must be set"))
+	}
+	if ref.Kind == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("kind"), "kind must be set"))
+	}
+	if ref.APIVersion == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("apiVersion"), "apiVersion must be set"))
+	}
+	return allErrs
+}
+
+// validateProviderIDList validates that each provider ID is in the <provider>://<id> format, so malformed
+// provider IDs are caught at admission instead of silently failing to match Nodes.
+func validateProviderIDList(providerIDs []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, providerID := range providerIDs {
+		provider, id, ok := strings.Cut(providerID, "://")
+		if !ok || provider == "" || strings.Trim(id, "/") == "" {
+			allErrs = append(
+				allErrs,
+				field.Invalid(
+					fldPath.Index(i),
+					providerID,
+					"must be a provider ID in the <provider>://<id> format",
+				),
+			)
+		}
+	}
+	return allErrs
+}
+
+// deprecationWarnings returns a warning for each deprecated field set on the MachinePool.
+func (m *MachinePool) deprecationWarnings() admission.Warnings {
+	var warnings admission.Warnings
+
+	// NOTE: MachinePools are spread across failure domains using spec.failureDomains; the failure domain
+	// of the machine template is ignored.
+	if m.Spec.Template.Spec.FailureDomain != nil {
+		warnings = append(warnings,
+			"spec.template.spec.failureDomain is deprecated and it is ignored for MachinePools, use spec.failureDomains instead",
+		)
+	}
+
+	return warnings
+}
+
+// validateVersionUpdate validates a change of the Kubernetes version of the MachinePool.
+func (m *MachinePool) validateVersionUpdate(old *MachinePool) field.ErrorList {
+	var allErrs field.ErrorList
+	if old.Spec.Template.Spec.Version == nil || m.Spec.Template.Spec.Version == nil {
+		return allErrs
+	}
+
+	// NOTE: Versions which can't be parsed are already reported by the semantic version validation.
+	// Parsing is tolerant, so versions with or without the "v" prefix can be compared.
+	fromVersion, err := version.ParseMajorMinorPatchTolerant(*old.Spec.Template.Spec.Version)
+	if err != nil {
+		return allErrs
+	}
+	toVersion, err := version.ParseMajorMinorPatchTolerant(*m.Spec.Template.Spec.Version)
+	if err != nil {
+		return allErrs
+	}
+
+	// Downgrading Kubernetes nodes is not supported.
+	if toVersion.LT(fromVersion) {
+		allErrs = append(allErrs,
+			field.Forbidden(
+				field.NewPath("spec", "template", "spec", "version"),
+				fmt.Sprintf("cannot downgrade Kubernetes version from %s to %s", *old.Spec.Template.Spec.Version, *m.Spec.Template.Spec.Version),
+			),
+		)
+	}
+
+	// Validate that the update is upgrading at most one minor version.
+	// Note: Checking against this ceilVersion allows upgrading to the next minor
+	// version irrespective of the patch version.
+	ceilVersion := semver.Version{
+		Major: fromVersion.Major,
+		Minor: fromVersion.Minor + 2,
+		Patch: 0,
+	}
+	if toVersion.GTE(ceilVersion) {
+		allErrs = append(allErrs,
+			field.Invalid(
+				field.NewPath("spec", "template", "spec", "version"),
+				*m.Spec.Template.Spec.Version,
+				fmt.Sprintf("cannot upgrade Kubernetes version from %s to %s: upgrading more than one minor version at a time is not supported", *old.Spec.Template.Spec.Version, *m.Spec.Template.Spec.Version),
+			),
+		)
+	}
+
+	return allErrs
 }
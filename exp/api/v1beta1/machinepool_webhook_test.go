@@ -17,20 +17,36 @@ limitations under the License.
 package v1beta1
 
 import (
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilfeature "k8s.io/component-base/featuregate/testing"
 	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/feature"
-	utildefaulting "sigs.k8s.io/cluster-api/util/defaulting"
+	"sigs.k8s.io/cluster-api/internal/webhooks/util"
 )
 
+var (
+	ctx        = ctrl.SetupSignalHandler()
+	fakeScheme = runtime.NewScheme()
+)
+
+func init() {
+	_ = clusterv1.AddToScheme(fakeScheme)
+	_ = AddToScheme(fakeScheme)
+}
+
 func TestMachinePoolDefault(t *testing.T) {
 	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
 	// Enabling the feature flag temporarily for this test.
@@ -45,27 +61,47 @@ func TestMachinePoolDefault(t *testing.T) {
 		Spec: MachinePoolSpec{
 			Template: clusterv1.MachineTemplateSpec{
 				Spec: clusterv1.MachineSpec{
-					Bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{}},
-					Version:   pointer.String("1.20.0"),
+					Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+					InfrastructureRef: validInfrastructureRef(),
+					Version:           pointer.String("1.20.0"),
 				},
 			},
 		},
 	}
-	t.Run("for MachinePool", utildefaulting.DefaultValidateTest(m))
-	m.Default()
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	t.Run("for MachinePool", util.CustomDefaultValidateTest(ctx, m, webhook))
+	g.Expect(webhook.Default(ctx, m)).To(Succeed())
 
 	g.Expect(m.Labels[clusterv1.ClusterNameLabel]).To(Equal(m.Spec.ClusterName))
 	g.Expect(m.Spec.Replicas).To(Equal(pointer.Int32(1)))
 	g.Expect(m.Spec.MinReadySeconds).To(Equal(pointer.Int32(0)))
+	g.Expect(m.Spec.Template.Spec.NodeDeletionTimeout.Duration).To(Equal(defaultNodeDeletionTimeout))
 	g.Expect(m.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace).To(Equal(m.Namespace))
 	g.Expect(m.Spec.Template.Spec.InfrastructureRef.Namespace).To(Equal(m.Namespace))
 	g.Expect(m.Spec.Template.Spec.Version).To(Equal(pointer.String("v1.20.0")))
 }
 
+func TestMachinePoolWebhookUnexpectedObject(t *testing.T) {
+	g := NewWithT(t)
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	obj := &clusterv1.Cluster{}
+
+	g.Expect(apierrors.IsBadRequest(webhook.Default(ctx, obj))).To(BeTrue())
+	_, err := webhook.ValidateCreate(ctx, obj)
+	g.Expect(apierrors.IsBadRequest(err)).To(BeTrue())
+	_, err = webhook.ValidateUpdate(ctx, obj, obj)
+	g.Expect(apierrors.IsBadRequest(err)).To(BeTrue())
+	_, err = webhook.ValidateDelete(ctx, obj)
+	g.Expect(apierrors.IsBadRequest(err)).To(BeTrue())
+}
+
 func TestMachinePoolBootstrapValidation(t *testing.T) {
 	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
 	// Enabling the feature flag temporarily for this test.
 	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
 	tests := []struct {
 		name      string
 		bootstrap clusterv1.Bootstrap
@@ -83,7 +119,7 @@ func TestMachinePoolBootstrapValidation(t *testing.T) {
 		},
 		{
 			name:      "should not return error if config ref is set",
-			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{}, DataSecretName: nil},
+			bootstrap: clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef(), DataSecretName: nil},
 			expectErr: false,
 		},
 	}
@@ -95,24 +131,25 @@ func TestMachinePoolBootstrapValidation(t *testing.T) {
 				Spec: MachinePoolSpec{
 					Template: clusterv1.MachineTemplateSpec{
 						Spec: clusterv1.MachineSpec{
-							Bootstrap: tt.bootstrap,
+							Bootstrap:         tt.bootstrap,
+							InfrastructureRef: validInfrastructureRef(),
 						},
 					},
 				},
 			}
 
 			if tt.expectErr {
-				warnings, err := m.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = m.ValidateUpdate(m)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			} else {
-				warnings, err := m.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = m.ValidateUpdate(m)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			}
@@ -120,10 +157,136 @@ func TestMachinePoolBootstrapValidation(t *testing.T) {
 	}
 }
 
+func TestMachinePoolBootstrapMutuallyExclusive(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	g := NewWithT(t)
+
+	m := &MachinePool{
+		Spec: MachinePoolSpec{
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef(), DataSecretName: pointer.String("test")},
+					InfrastructureRef: validInfrastructureRef(),
+				},
+			},
+		},
+	}
+
+	// Creating a MachinePool with a configRef and the dataSecretName set by the MachinePool controller is allowed,
+	// e.g. when moving MachinePools with clusterctl move or restoring them from a backup.
+	warnings, err := webhook.ValidateCreate(ctx, m)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(warnings).To(BeEmpty())
+
+	// Adding a configRef to a MachinePool using dataSecretName is not allowed.
+	oldM := m.DeepCopy()
+	oldM.Spec.Template.Spec.Bootstrap.ConfigRef = nil
+	warnings, err = webhook.ValidateUpdate(ctx, oldM, m)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(warnings).To(BeEmpty())
+
+	// Setting dataSecretName on a MachinePool using a configRef is allowed, because this is done by the MachinePool controller.
+	oldM = m.DeepCopy()
+	oldM.Spec.Template.Spec.Bootstrap.DataSecretName = nil
+	warnings, err = webhook.ValidateUpdate(ctx, oldM, m)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(warnings).To(BeEmpty())
+	warnings, err = webhook.ValidateUpdate(ctx, m, m)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(warnings).To(BeEmpty())
+}
+
+func TestMachinePoolReferencesValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name       string
+		configRef  *corev1.ObjectReference
+		infraRef   corev1.ObjectReference
+		expectErrs []string
+	}{
+		{
+			name:      "should succeed if references are populated",
+			configRef: validBootstrapConfigRef(),
+			infraRef:  validInfrastructureRef(),
+		},
+		{
+			name:      "should return error if infrastructureRef is empty",
+			configRef: validBootstrapConfigRef(),
+			infraRef:  corev1.ObjectReference{},
+			expectErrs: []string{
+				"spec.template.spec.infrastructureRef.name",
+				"spec.template.spec.infrastructureRef.kind",
+				"spec.template.spec.infrastructureRef.apiVersion",
+			},
+		},
+		{
+			name:      "should return error if bootstrap configRef is set but empty",
+			configRef: &corev1.ObjectReference{},
+			infraRef:  validInfrastructureRef(),
+			expectErrs: []string{
+				"spec.template.spec.bootstrap.configRef.name",
+				"spec.template.spec.bootstrap.configRef.kind",
+				"spec.template.spec.bootstrap.configRef.apiVersion",
+			},
+		},
+		{
+			name:      "should return error if bootstrap configRef name is missing",
+			configRef: &corev1.ObjectReference{APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1", Kind: "KubeadmConfig"},
+			infraRef:  validInfrastructureRef(),
+			expectErrs: []string{
+				"spec.template.spec.bootstrap.configRef.name",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: tt.configRef},
+							InfrastructureRef: tt.infraRef,
+						},
+					},
+				},
+			}
+
+			warnings, err := webhook.ValidateCreate(ctx, m)
+			g.Expect(warnings).To(BeEmpty())
+			if len(tt.expectErrs) == 0 {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			g.Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			statusErr, ok := err.(*apierrors.StatusError)
+			g.Expect(ok).To(BeTrue())
+			fields := []string{}
+			for _, cause := range statusErr.ErrStatus.Details.Causes {
+				g.Expect(cause.Type).To(Equal(metav1.CauseTypeFieldValueRequired))
+				fields = append(fields, cause.Field)
+			}
+			g.Expect(fields).To(ConsistOf(tt.expectErrs))
+		})
+	}
+}
+
 func TestMachinePoolNamespaceValidation(t *testing.T) {
 	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
 	// Enabling the feature flag temporarily for this test.
 	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
 	tests := []struct {
 		name      string
 		expectErr bool
@@ -135,29 +298,29 @@ func TestMachinePoolNamespaceValidation(t *testing.T) {
 			name:      "should succeed if all namespaces match",
 			expectErr: false,
 			namespace: "foobar",
-			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{Namespace: "foobar"}},
-			infraRef:  corev1.ObjectReference{Namespace: "foobar"},
+			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1", Kind: "KubeadmConfig", Name: "foo", Namespace: "foobar"}},
+			infraRef:  corev1.ObjectReference{APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1", Kind: "DockerMachinePool", Name: "foo", Namespace: "foobar"},
 		},
 		{
 			name:      "should return error if namespace and bootstrap namespace don't match",
 			expectErr: true,
 			namespace: "foobar",
-			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{Namespace: "foobar123"}},
-			infraRef:  corev1.ObjectReference{Namespace: "foobar"},
+			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1", Kind: "KubeadmConfig", Name: "foo", Namespace: "foobar123"}},
+			infraRef:  corev1.ObjectReference{APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1", Kind: "DockerMachinePool", Name: "foo", Namespace: "foobar"},
 		},
 		{
 			name:      "should return error if namespace and infrastructure ref namespace don't match",
 			expectErr: true,
 			namespace: "foobar",
-			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{Namespace: "foobar"}},
-			infraRef:  corev1.ObjectReference{Namespace: "foobar123"},
+			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1", Kind: "KubeadmConfig", Name: "foo", Namespace: "foobar"}},
+			infraRef:  corev1.ObjectReference{APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1", Kind: "DockerMachinePool", Name: "foo", Namespace: "foobar123"},
 		},
 		{
 			name:      "should return error if no namespaces match",
 			expectErr: true,
 			namespace: "foobar1",
-			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{Namespace: "foobar2"}},
-			infraRef:  corev1.ObjectReference{Namespace: "foobar3"},
+			bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1", Kind: "KubeadmConfig", Name: "foo", Namespace: "foobar2"}},
+			infraRef:  corev1.ObjectReference{APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1", Kind: "DockerMachinePool", Name: "foo", Namespace: "foobar3"},
 		},
 	}
 
@@ -178,17 +341,17 @@ func TestMachinePoolNamespaceValidation(t *testing.T) {
 			}
 
 			if tt.expectErr {
-				warnings, err := m.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = m.ValidateUpdate(m)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			} else {
-				warnings, err := m.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = m.ValidateUpdate(m)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			}
@@ -196,27 +359,36 @@ func TestMachinePoolNamespaceValidation(t *testing.T) {
 	}
 }
 
-func TestMachinePoolClusterNameImmutable(t *testing.T) {
+func TestMachinePoolReplicasValidation(t *testing.T) {
 	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
 	// Enabling the feature flag temporarily for this test.
 	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
 	tests := []struct {
-		name           string
-		oldClusterName string
-		newClusterName string
-		expectErr      bool
+		name      string
+		replicas  *int32
+		expectErr bool
 	}{
 		{
-			name:           "when the cluster name has not changed",
-			oldClusterName: "foo",
-			newClusterName: "foo",
-			expectErr:      false,
+			name:      "should succeed if replicas is not set",
+			replicas:  nil,
+			expectErr: false,
 		},
 		{
-			name:           "when the cluster name has changed",
-			oldClusterName: "foo",
-			newClusterName: "bar",
-			expectErr:      true,
+			name:      "should succeed if replicas is 0",
+			replicas:  pointer.Int32(0),
+			expectErr: false,
+		},
+		{
+			name:      "should succeed if replicas is positive",
+			replicas:  pointer.Int32(3),
+			expectErr: false,
+		},
+		{
+			name:      "should fail if replicas is negative",
+			replicas:  pointer.Int32(-1),
+			expectErr: true,
 		},
 	}
 
@@ -224,67 +396,141 @@ func TestMachinePoolClusterNameImmutable(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			g := NewWithT(t)
 
-			newMP := &MachinePool{
+			m := &MachinePool{
 				Spec: MachinePoolSpec{
-					ClusterName: tt.newClusterName,
+					Replicas: tt.replicas,
 					Template: clusterv1.MachineTemplateSpec{
 						Spec: clusterv1.MachineSpec{
-							Bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{}},
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
 						},
 					},
 				},
 			}
 
-			oldMP := &MachinePool{
+			if tt.expectErr {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			} else {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestMachinePoolMinReadySecondsValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name            string
+		minReadySeconds *int32
+		expectErr       bool
+	}{
+		{
+			name:            "should succeed if minReadySeconds is 0",
+			minReadySeconds: pointer.Int32(0),
+			expectErr:       false,
+		},
+		{
+			name:            "should succeed if minReadySeconds is positive",
+			minReadySeconds: pointer.Int32(10),
+			expectErr:       false,
+		},
+		{
+			name:            "should fail if minReadySeconds is negative",
+			minReadySeconds: pointer.Int32(-5),
+			expectErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
 				Spec: MachinePoolSpec{
-					ClusterName: tt.oldClusterName,
+					Replicas:        pointer.Int32(3),
+					MinReadySeconds: tt.minReadySeconds,
 					Template: clusterv1.MachineTemplateSpec{
 						Spec: clusterv1.MachineSpec{
-							Bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{}},
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
 						},
 					},
 				},
 			}
 
-			warnings, err := newMP.ValidateUpdate(oldMP)
 			if tt.expectErr {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
 			} else {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
 			}
-			g.Expect(warnings).To(BeEmpty())
 		})
 	}
 }
 
-func TestMachinePoolVersionValidation(t *testing.T) {
+func TestMachinePoolProviderIDListValidation(t *testing.T) {
 	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
 	// Enabling the feature flag temporarily for this test.
 	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
 	tests := []struct {
-		name      string
-		expectErr bool
-		version   string
+		name           string
+		providerIDList []string
+		expectErr      bool
 	}{
 		{
-			name:      "should succeed version is a valid kube semver",
-			expectErr: false,
-			version:   "v1.23.3",
+			name:           "should succeed if providerIDList is empty",
+			providerIDList: nil,
+			expectErr:      false,
 		},
 		{
-			name:      "should succeed version is a valid pre-release",
-			expectErr: false,
-			version:   "v1.19.0-alpha.1",
+			name:           "should succeed if all the provider IDs are valid",
+			providerIDList: []string{"aws:///us-east-1a/i-1234567890abcdef0", "docker:////machine-pool-0"},
+			expectErr:      false,
 		},
 		{
-			name:      "should fail if version is not a valid semver",
-			expectErr: true,
-			version:   "v1.1",
+			name:           "should fail if a provider ID has no provider",
+			providerIDList: []string{"aws:///us-east-1a/i-1234567890abcdef0", ":///i-1234567890abcdef0"},
+			expectErr:      true,
 		},
 		{
-			name:      "should fail if version is missing a v prefix",
-			expectErr: true,
-			version:   "1.20.0",
+			name:           "should fail if a provider ID has no ID",
+			providerIDList: []string{"aws:///"},
+			expectErr:      true,
+		},
+		{
+			name:           "should fail if a provider ID is not in the <provider>://<id> format",
+			providerIDList: []string{"i-1234567890abcdef0"},
+			expectErr:      true,
+		},
+		{
+			name:           "should fail if a provider ID is empty",
+			providerIDList: []string{""},
+			expectErr:      true,
 		},
 	}
 
@@ -294,27 +540,29 @@ func TestMachinePoolVersionValidation(t *testing.T) {
 
 			m := &MachinePool{
 				Spec: MachinePoolSpec{
+					ProviderIDList: tt.providerIDList,
 					Template: clusterv1.MachineTemplateSpec{
 						Spec: clusterv1.MachineSpec{
-							Bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{}},
-							Version:   &tt.version,
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
 						},
 					},
 				},
 			}
 
 			if tt.expectErr {
-				warnings, err := m.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("spec.providerIDList[%d]", len(tt.providerIDList)-1)))
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = m.ValidateUpdate(m)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			} else {
-				warnings, err := m.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = m.ValidateUpdate(m)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			}
@@ -322,55 +570,1110 @@ func TestMachinePoolVersionValidation(t *testing.T) {
 	}
 }
 
-func TestMachinePoolMetadataValidation(t *testing.T) {
+func TestMachinePoolNodeDeletionTimeoutDefault(t *testing.T) {
+	g := NewWithT(t)
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	m := &MachinePool{
+		Spec: MachinePoolSpec{
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					NodeDeletionTimeout: &metav1.Duration{Duration: 0},
+				},
+			},
+		},
+	}
+
+	// An explicit value must be preserved.
+	g.Expect(webhook.Default(ctx, m)).To(Succeed())
+	g.Expect(m.Spec.Template.Spec.NodeDeletionTimeout.Duration).To(Equal(time.Duration(0)))
+}
+
+func TestMachinePoolNodeTimeoutsValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+
 	tests := []struct {
-		name        string
-		labels      map[string]string
-		annotations map[string]string
-		expectErr   bool
+		name                string
+		nodeDrainTimeout    *metav1.Duration
+		nodeDeletionTimeout *metav1.Duration
+		expectErr           bool
 	}{
 		{
-			name: "should return error for invalid labels and annotations",
-			labels: map[string]string{
-				"foo":          "$invalid-key",
-				"bar":          strings.Repeat("a", 64) + "too-long-value",
-				"/invalid-key": "foo",
-			},
-			annotations: map[string]string{
-				"/invalid-key": "foo",
-			},
-			expectErr: true,
+			name:      "should succeed if timeouts are not set",
+			expectErr: false,
+		},
+		{
+			name:                "should succeed if timeouts are zero",
+			nodeDrainTimeout:    &metav1.Duration{Duration: 0},
+			nodeDeletionTimeout: &metav1.Duration{Duration: 0},
+			expectErr:           false,
+		},
+		{
+			name:                "should succeed if timeouts are positive",
+			nodeDrainTimeout:    &metav1.Duration{Duration: time.Minute},
+			nodeDeletionTimeout: &metav1.Duration{Duration: time.Minute},
+			expectErr:           false,
+		},
+		{
+			name:             "should return error if nodeDrainTimeout is negative",
+			nodeDrainTimeout: &metav1.Duration{Duration: -time.Minute},
+			expectErr:        true,
+		},
+		{
+			name:                "should return error if nodeDeletionTimeout is negative",
+			nodeDeletionTimeout: &metav1.Duration{Duration: -time.Minute},
+			expectErr:           true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := NewWithT(t)
-			mp := &MachinePool{
+
+			m := &MachinePool{
 				Spec: MachinePoolSpec{
 					Template: clusterv1.MachineTemplateSpec{
-						ObjectMeta: clusterv1.ObjectMeta{
-							Labels:      tt.labels,
-							Annotations: tt.annotations,
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:           clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef:   validInfrastructureRef(),
+							NodeDrainTimeout:    tt.nodeDrainTimeout,
+							NodeDeletionTimeout: tt.nodeDeletionTimeout,
 						},
 					},
 				},
 			}
+
 			if tt.expectErr {
-				warnings, err := mp.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = mp.ValidateUpdate(mp)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).To(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			} else {
-				warnings, err := mp.ValidateCreate()
+				warnings, err := webhook.ValidateCreate(ctx, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
-				warnings, err = mp.ValidateUpdate(mp)
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
 				g.Expect(err).ToNot(HaveOccurred())
 				g.Expect(warnings).To(BeEmpty())
 			}
 		})
 	}
 }
+
+func TestMachinePoolClusterNameImmutable(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name           string
+		oldClusterName string
+		newClusterName string
+		expectErr      bool
+	}{
+		{
+			name:           "when the cluster name has not changed",
+			oldClusterName: "foo",
+			newClusterName: "foo",
+			expectErr:      false,
+		},
+		{
+			name:           "when the cluster name has changed",
+			oldClusterName: "foo",
+			newClusterName: "bar",
+			expectErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			newMP := &MachinePool{
+				Spec: MachinePoolSpec{
+					ClusterName: tt.newClusterName,
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+
+			oldMP := &MachinePool{
+				Spec: MachinePoolSpec{
+					ClusterName: tt.oldClusterName,
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+
+			warnings, err := webhook.ValidateUpdate(ctx, oldMP, newMP)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(warnings).To(BeEmpty())
+		})
+	}
+}
+
+func TestMachinePoolInfrastructureRefImmutable(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	oldInfraRef := corev1.ObjectReference{
+		APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+		Kind:       "DockerMachinePool",
+		Name:       "foo",
+	}
+	tests := []struct {
+		name      string
+		infraRef  corev1.ObjectReference
+		expectErr bool
+	}{
+		{
+			name:      "should succeed when the infrastructureRef has not changed",
+			infraRef:  oldInfraRef,
+			expectErr: false,
+		},
+		{
+			name: "should succeed when the infrastructureRef name has changed",
+			infraRef: corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "DockerMachinePool",
+				Name:       "bar",
+			},
+			expectErr: false,
+		},
+		{
+			name: "should succeed when the infrastructureRef version has changed",
+			infraRef: corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta2",
+				Kind:       "DockerMachinePool",
+				Name:       "foo",
+			},
+			expectErr: false,
+		},
+		{
+			name: "should fail when the infrastructureRef kind has changed",
+			infraRef: corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "AWSMachinePool",
+				Name:       "foo",
+			},
+			expectErr: true,
+		},
+		{
+			name: "should fail when the infrastructureRef group has changed",
+			infraRef: corev1.ObjectReference{
+				APIVersion: "infrastructure.foo.io/v1beta1",
+				Kind:       "DockerMachinePool",
+				Name:       "foo",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			newMP := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: tt.infraRef,
+						},
+					},
+				},
+			}
+
+			oldMP := newMP.DeepCopy()
+			oldMP.Spec.Template.Spec.InfrastructureRef = oldInfraRef
+
+			warnings, err := webhook.ValidateUpdate(ctx, oldMP, newMP)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(warnings).To(BeEmpty())
+		})
+	}
+}
+
+func TestMachinePoolVersionDefaulting(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name            string
+		version         string
+		expectedVersion string
+		expectErr       bool
+	}{
+		{
+			name:            "should prepend the v prefix",
+			version:         "1.28.0",
+			expectedVersion: "v1.28.0",
+			expectErr:       false,
+		},
+		{
+			name:            "should prepend the v prefix to a pre-release",
+			version:         "1.2.3-beta",
+			expectedVersion: "v1.2.3-beta",
+			expectErr:       false,
+		},
+		{
+			name:            "should prepend the v prefix to a version with build metadata",
+			version:         "1.28.0+vmware.1",
+			expectedVersion: "v1.28.0+vmware.1",
+			expectErr:       false,
+		},
+		{
+			name:            "should preserve a version with the v prefix",
+			version:         "v1.28.0-rc.1+vmware.1",
+			expectedVersion: "v1.28.0-rc.1+vmware.1",
+			expectErr:       false,
+		},
+		{
+			name:            "should not modify an invalid version",
+			version:         "1.28",
+			expectedVersion: "1.28",
+			expectErr:       true,
+		},
+		{
+			name:            "should not modify an empty version",
+			version:         "",
+			expectedVersion: "",
+			expectErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+							Version:           pointer.String(tt.version),
+						},
+					},
+				},
+			}
+
+			g.Expect(webhook.Default(ctx, m)).To(Succeed())
+			g.Expect(*m.Spec.Template.Spec.Version).To(Equal(tt.expectedVersion))
+
+			_, err := webhook.ValidateCreate(ctx, m)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring(fmt.Sprintf("Invalid value: %q", tt.version)))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestMachinePoolVersionValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name      string
+		expectErr bool
+		version   string
+	}{
+		{
+			name:      "should succeed version is a valid kube semver",
+			expectErr: false,
+			version:   "v1.23.3",
+		},
+		{
+			name:      "should succeed version is a valid pre-release",
+			expectErr: false,
+			version:   "v1.19.0-alpha.1",
+		},
+		{
+			name:      "should succeed version has build metadata",
+			expectErr: false,
+			version:   "v1.28.0+vmware.1",
+		},
+		{
+			name:      "should fail if version is not a valid semver",
+			expectErr: true,
+			version:   "v1.1",
+		},
+		{
+			name:      "should fail if version is missing a v prefix",
+			expectErr: true,
+			version:   "1.20.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+							Version:           &tt.version,
+						},
+					},
+				},
+			}
+
+			if tt.expectErr {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			} else {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestMachinePoolVersionUpdateValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name       string
+		oldVersion string
+		newVersion string
+		expectErr  bool
+	}{
+		{
+			name:       "should succeed if the version has not changed",
+			oldVersion: "v1.28.0",
+			newVersion: "v1.28.0",
+			expectErr:  false,
+		},
+		{
+			name:       "should succeed if the version is upgraded",
+			oldVersion: "v1.27.3",
+			newVersion: "v1.28.0",
+			expectErr:  false,
+		},
+		{
+			name:       "should succeed if the old version is missing the v prefix",
+			oldVersion: "1.28.0",
+			newVersion: "v1.28.0",
+			expectErr:  false,
+		},
+		{
+			name:       "should succeed if the version is upgraded by one minor to any patch",
+			oldVersion: "v1.27.0",
+			newVersion: "v1.28.5",
+			expectErr:  false,
+		},
+		{
+			name:       "should fail if the version is upgraded by more than one minor",
+			oldVersion: "v1.26.0",
+			newVersion: "v1.29.0",
+			expectErr:  true,
+		},
+		{
+			name:       "should fail if the version is downgraded",
+			oldVersion: "v1.28.0",
+			newVersion: "v1.27.0",
+			expectErr:  true,
+		},
+		{
+			name:       "should fail if the patch version is downgraded",
+			oldVersion: "v1.28.2",
+			newVersion: "v1.28.1",
+			expectErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			newMP := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+							Version:           pointer.String(tt.newVersion),
+						},
+					},
+				},
+			}
+
+			oldMP := newMP.DeepCopy()
+			oldMP.Spec.Template.Spec.Version = pointer.String(tt.oldVersion)
+
+			warnings, err := webhook.ValidateUpdate(ctx, oldMP, newMP)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(warnings).To(BeEmpty())
+		})
+	}
+}
+
+func TestMachinePoolNameValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name      string
+		mpName    string
+		expectErr bool
+	}{
+		{
+			name:      "should succeed if the name is short",
+			mpName:    "machinepool",
+			expectErr: false,
+		},
+		{
+			name:      "should succeed if the name has the max length",
+			mpName:    strings.Repeat("a", 57),
+			expectErr: false,
+		},
+		{
+			name:      "should return error if the name is too long",
+			mpName:    strings.Repeat("a", 58),
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: tt.mpName,
+				},
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+
+			_, err := webhook.ValidateCreate(ctx, m)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring("metadata.name"))
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+
+			// The name length is only validated on create, so existing MachinePools can still be updated.
+			_, err = webhook.ValidateUpdate(ctx, m, m)
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestMachinePoolClusterExistsValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cluster",
+			Namespace: "default",
+		},
+	}
+	webhook := &MachinePoolWebhook{
+		Client: fake.NewClientBuilder().
+			WithScheme(fakeScheme).
+			WithObjects(cluster).
+			Build(),
+	}
+
+	tests := []struct {
+		name        string
+		namespace   string
+		clusterName string
+		expectErr   bool
+	}{
+		{
+			name:        "should succeed if the Cluster exists",
+			namespace:   "default",
+			clusterName: "test-cluster",
+			expectErr:   false,
+		},
+		{
+			name:        "should return error if the Cluster does not exist",
+			namespace:   "default",
+			clusterName: "does-not-exist",
+			expectErr:   true,
+		},
+		{
+			name:        "should return error if the Cluster exists in another namespace",
+			namespace:   "foo",
+			clusterName: "test-cluster",
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-machinepool",
+					Namespace: tt.namespace,
+				},
+				Spec: MachinePoolSpec{
+					ClusterName: tt.clusterName,
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+			m.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace = m.Namespace
+			m.Spec.Template.Spec.InfrastructureRef.Namespace = m.Namespace
+
+			_, err := webhook.ValidateCreate(ctx, m)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+
+			// The Cluster is only required to exist on create.
+			_, err = webhook.ValidateUpdate(ctx, m, m)
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestMachinePoolValidateDelete(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	g := NewWithT(t)
+
+	// The Cluster of the MachinePool does not exist anymore, and the MachinePool predates the name length validation.
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	m := &MachinePool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      strings.Repeat("a", maxNameLength+1),
+			Namespace: "default",
+		},
+		Spec: MachinePoolSpec{
+			ClusterName: "deleted-cluster",
+			Template: clusterv1.MachineTemplateSpec{
+				Spec: clusterv1.MachineSpec{
+					Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+					InfrastructureRef: validInfrastructureRef(),
+				},
+			},
+		},
+	}
+	m.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace = m.Namespace
+	m.Spec.Template.Spec.InfrastructureRef.Namespace = m.Namespace
+
+	_, err := webhook.ValidateCreate(ctx, m)
+	g.Expect(err).To(HaveOccurred())
+
+	// Deleting the MachinePool is allowed anyway.
+	warnings, err := webhook.ValidateDelete(ctx, m)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(warnings).To(BeEmpty())
+}
+
+func TestMachinePoolFailureDomainsValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cluster",
+			Namespace: "default",
+		},
+		Status: clusterv1.ClusterStatus{
+			FailureDomains: clusterv1.FailureDomains{
+				"fd1": clusterv1.FailureDomainSpec{},
+				"fd2": clusterv1.FailureDomainSpec{},
+			},
+		},
+	}
+	clusterWithoutFailureDomains := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cluster-without-failure-domains",
+			Namespace: "default",
+		},
+	}
+
+	tests := []struct {
+		name              string
+		clusterName       string
+		oldFailureDomains []string
+		failureDomains    []string
+		expectErr         bool
+	}{
+		{
+			name:           "should succeed if failure domains are defined in the Cluster",
+			clusterName:    cluster.Name,
+			failureDomains: []string{"fd1", "fd2"},
+			expectErr:      false,
+		},
+		{
+			name:           "should return error if a failure domain is not defined in the Cluster",
+			clusterName:    cluster.Name,
+			failureDomains: []string{"fd1", "fd3"},
+			expectErr:      true,
+		},
+		{
+			name:              "should return error if a failure domain not defined in the Cluster is added",
+			clusterName:       cluster.Name,
+			oldFailureDomains: []string{"fd1"},
+			failureDomains:    []string{"fd1", "fd3"},
+			expectErr:         true,
+		},
+		{
+			name:              "should succeed if a failure domain not defined in the Cluster anymore is preserved",
+			clusterName:       cluster.Name,
+			oldFailureDomains: []string{"fd3"},
+			failureDomains:    []string{"fd1", "fd3"},
+			expectErr:         false,
+		},
+		{
+			name:           "should succeed if the Cluster did not report failure domains yet",
+			clusterName:    clusterWithoutFailureDomains.Name,
+			failureDomains: []string{"fd3"},
+			expectErr:      false,
+		},
+		{
+			name:              "should succeed on update if the Cluster does not exist",
+			clusterName:       "does-not-exist",
+			oldFailureDomains: []string{},
+			failureDomains:    []string{"fd3"},
+			expectErr:         false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			webhook := &MachinePoolWebhook{
+				Client: fake.NewClientBuilder().
+					WithScheme(fakeScheme).
+					WithObjects(cluster, clusterWithoutFailureDomains).
+					Build(),
+			}
+
+			newMP := &MachinePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-machinepool",
+					Namespace: "default",
+				},
+				Spec: MachinePoolSpec{
+					ClusterName:    tt.clusterName,
+					FailureDomains: tt.failureDomains,
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+			newMP.Spec.Template.Spec.Bootstrap.ConfigRef.Namespace = newMP.Namespace
+			newMP.Spec.Template.Spec.InfrastructureRef.Namespace = newMP.Namespace
+
+			var err error
+			if tt.oldFailureDomains == nil {
+				_, err = webhook.ValidateCreate(ctx, newMP)
+			} else {
+				oldMP := newMP.DeepCopy()
+				oldMP.Spec.FailureDomains = tt.oldFailureDomains
+				_, err = webhook.ValidateUpdate(ctx, oldMP, newMP)
+			}
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestMachinePoolMetadataValidation(t *testing.T) {
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		expectErr   bool
+	}{
+		{
+			name: "should return error for invalid labels and annotations",
+			labels: map[string]string{
+				"foo":          "$invalid-key",
+				"bar":          strings.Repeat("a", 64) + "too-long-value",
+				"/invalid-key": "foo",
+			},
+			annotations: map[string]string{
+				"/invalid-key": "foo",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			mp := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						ObjectMeta: clusterv1.ObjectMeta{
+							Labels:      tt.labels,
+							Annotations: tt.annotations,
+						},
+					},
+				},
+			}
+			if tt.expectErr {
+				warnings, err := webhook.ValidateCreate(ctx, mp)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, mp, mp)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			} else {
+				warnings, err := webhook.ValidateCreate(ctx, mp)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, mp, mp)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestMachinePoolRolloutWarnings(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name            string
+		replicas        *int32
+		minReadySeconds *int32
+		expectWarning   bool
+	}{
+		{
+			name:            "should warn with a single replica and non-zero minReadySeconds",
+			replicas:        pointer.Int32(1),
+			minReadySeconds: pointer.Int32(30),
+			expectWarning:   true,
+		},
+		{
+			name:            "should not warn with a single replica and zero minReadySeconds",
+			replicas:        pointer.Int32(1),
+			minReadySeconds: pointer.Int32(0),
+			expectWarning:   false,
+		},
+		{
+			name:            "should not warn with multiple replicas and non-zero minReadySeconds",
+			replicas:        pointer.Int32(3),
+			minReadySeconds: pointer.Int32(30),
+			expectWarning:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				Spec: MachinePoolSpec{
+					Replicas:        tt.replicas,
+					MinReadySeconds: tt.minReadySeconds,
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+
+			warnings, err := webhook.ValidateCreate(ctx, m)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectWarning {
+				g.Expect(warnings).To(HaveLen(1))
+			} else {
+				g.Expect(warnings).To(BeEmpty())
+			}
+			warnings, err = webhook.ValidateUpdate(ctx, m, m)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectWarning {
+				g.Expect(warnings).To(HaveLen(1))
+			} else {
+				g.Expect(warnings).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestMachinePoolDeprecationWarnings(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).Build()}
+	tests := []struct {
+		name          string
+		failureDomain *string
+		expectWarning bool
+	}{
+		{
+			name:          "should warn when the template failureDomain is set",
+			failureDomain: pointer.String("fd1"),
+			expectWarning: true,
+		},
+		{
+			name:          "should not warn when the template failureDomain is not set",
+			failureDomain: nil,
+			expectWarning: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				Spec: MachinePoolSpec{
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+							FailureDomain:     tt.failureDomain,
+						},
+					},
+				},
+			}
+
+			warnings, err := webhook.ValidateCreate(ctx, m)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectWarning {
+				g.Expect(warnings).To(ConsistOf(ContainSubstring("spec.template.spec.failureDomain is deprecated")))
+			} else {
+				g.Expect(warnings).To(BeEmpty())
+			}
+			warnings, err = webhook.ValidateUpdate(ctx, m, m)
+			g.Expect(err).ToNot(HaveOccurred())
+			if tt.expectWarning {
+				g.Expect(warnings).To(ConsistOf(ContainSubstring("spec.template.spec.failureDomain is deprecated")))
+			} else {
+				g.Expect(warnings).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestMachinePoolClusterNameLabelValidation(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+	tests := []struct {
+		name      string
+		oldLabel  string
+		newLabel  string
+		expectErr bool
+	}{
+		{
+			name:      "should succeed when the cluster name label has not changed",
+			oldLabel:  "foo",
+			newLabel:  "foo",
+			expectErr: false,
+		},
+		{
+			name:      "should fail when the cluster name label is changed away from spec.clusterName",
+			oldLabel:  "foo",
+			newLabel:  "bar",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			newMP := &MachinePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{clusterv1.ClusterNameLabel: tt.newLabel},
+				},
+				Spec: MachinePoolSpec{
+					ClusterName: "foo",
+					Template: clusterv1.MachineTemplateSpec{
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+
+			oldMP := newMP.DeepCopy()
+			oldMP.Labels[clusterv1.ClusterNameLabel] = tt.oldLabel
+
+			webhook := &MachinePoolWebhook{}
+			warnings, err := webhook.ValidateUpdate(ctx, oldMP, newMP)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+			g.Expect(warnings).To(BeEmpty())
+		})
+	}
+}
+
+func TestMachinePoolClusterNameLabelConsistency(t *testing.T) {
+	// NOTE: MachinePool feature flag is disabled by default, thus preventing to create or update MachinePool.
+	// Enabling the feature flag temporarily for this test.
+	defer utilfeature.SetFeatureGateDuringTest(t, feature.Gates, feature.MachinePool, true)()
+
+	cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}
+	webhook := &MachinePoolWebhook{Client: fake.NewClientBuilder().WithScheme(fakeScheme).WithObjects(cluster).Build()}
+	tests := []struct {
+		name           string
+		labels         map[string]string
+		templateLabels map[string]string
+		expectErr      bool
+	}{
+		{
+			name:      "should succeed when the cluster name label is not set",
+			expectErr: false,
+		},
+		{
+			name:           "should succeed when the cluster name labels match spec.clusterName",
+			labels:         map[string]string{clusterv1.ClusterNameLabel: "foo"},
+			templateLabels: map[string]string{clusterv1.ClusterNameLabel: "foo"},
+			expectErr:      false,
+		},
+		{
+			name:           "should fail when the template cluster name label does not match spec.clusterName",
+			templateLabels: map[string]string{clusterv1.ClusterNameLabel: "bar"},
+			expectErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			m := &MachinePool{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: tt.labels,
+				},
+				Spec: MachinePoolSpec{
+					ClusterName: "foo",
+					Template: clusterv1.MachineTemplateSpec{
+						ObjectMeta: clusterv1.ObjectMeta{
+							Labels: tt.templateLabels,
+						},
+						Spec: clusterv1.MachineSpec{
+							Bootstrap:         clusterv1.Bootstrap{ConfigRef: validBootstrapConfigRef()},
+							InfrastructureRef: validInfrastructureRef(),
+						},
+					},
+				},
+			}
+
+			if tt.expectErr {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			} else {
+				warnings, err := webhook.ValidateCreate(ctx, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+				warnings, err = webhook.ValidateUpdate(ctx, m, m)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(warnings).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func validBootstrapConfigRef() *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "bootstrap.cluster.x-k8s.io/v1beta1",
+		Kind:       "KubeadmConfig",
+		Name:       "foo",
+	}
+}
+
+func validInfrastructureRef() corev1.ObjectReference {
+	return corev1.ObjectReference{
+		APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+		Kind:       "DockerMachinePool",
+		Name:       "foo",
+	}
+}
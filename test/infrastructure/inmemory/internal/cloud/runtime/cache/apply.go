@@ -0,0 +1,432 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// NOTE: This file implements a minimal version of server-side apply; it is an acceptable proxy for this use case,
+// but it has some known limitations:
+//   - Lists of objects are merged using the name field as a key (this is true for most of the Kubernetes types,
+//     e.g. containers, volumes or conditions are keyed by type so they are considered atomic); all the other lists are atomic.
+//   - Field ownership is tracked only for apply operations; fields set by other operations are not owned by anyone.
+
+// fieldSet is a set of fields, using the same format of metav1.FieldsV1; a fieldSet without children is a leaf.
+type fieldSet map[string]fieldSet
+
+// fieldSetSelf is the key used to track ownership of an item in a list.
+const fieldSetSelf = "."
+
+// ignoredMetadataFields are metadata fields which are never owned by a field manager.
+var ignoredMetadataFields = []string{"name", "namespace", "uid", "resourceVersion", "generation", "creationTimestamp", "deletionTimestamp", "managedFields"}
+
+// applyPatch computes the result of applying patchData on top of originalObjJS on behalf of the field manager in the patch options.
+// The returned object includes the managed fields updated accordingly.
+func applyPatch(obj client.Object, originalObjJS, patchData []byte, patchOptions *client.PatchOptions) ([]byte, error) {
+	if patchOptions.FieldManager == "" {
+		return nil, apierrors.NewBadRequest("fieldManager must be set for apply patches")
+	}
+	force := patchOptions.Force != nil && *patchOptions.Force
+
+	patchJS, err := yaml.YAMLToJSON(patchData)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to parse apply patch: %v", err))
+	}
+	applied := map[string]interface{}{}
+	if err := json.Unmarshal(patchJS, &applied); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to parse apply patch: %v", err))
+	}
+	live := map[string]interface{}{}
+	if err := json.Unmarshal(originalObjJS, &live); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	// Managed fields are computed by the server, ignore whatever is in the objects.
+	unstructured.RemoveNestedField(applied, "metadata", "managedFields")
+	unstructured.RemoveNestedField(live, "metadata", "managedFields")
+
+	owned := appliedFieldSet(applied)
+
+	// Gets the fields previously owned by this field manager and the fields owned by the other field managers.
+	type managerFields struct {
+		entry metav1.ManagedFieldsEntry
+		set   fieldSet
+	}
+	previous := fieldSet{}
+	others := []*managerFields{}
+	for _, entry := range obj.GetManagedFields() {
+		set := fieldSet{}
+		if entry.FieldsV1 != nil {
+			if err := json.Unmarshal(entry.FieldsV1.Raw, &set); err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+		}
+		if entry.Manager == patchOptions.FieldManager && entry.Operation == metav1.ManagedFieldsOperationApply {
+			previous = set
+			continue
+		}
+		others = append(others, &managerFields{entry: entry, set: set})
+	}
+
+	// Detects conflicts with other field managers, or take ownership of the conflicting fields if force is set.
+	conflicts := []string{}
+	for _, path := range owned.leaves() {
+		appliedValue, _ := fieldValue(applied, path)
+		liveValue, ok := fieldValue(live, path)
+		if !ok || reflect.DeepEqual(appliedValue, liveValue) {
+			continue
+		}
+		for _, other := range others {
+			if !other.set.has(path) {
+				continue
+			}
+			if force {
+				other.set.remove(path)
+				continue
+			}
+			conflicts = append(conflicts, fmt.Sprintf("conflict with %q: %s", other.entry.Manager, strings.Join(path, ".")))
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		return nil, apierrors.NewConflict(unsafeGuessGroupVersionResource(gvk).GroupResource(), obj.GetName(), fmt.Errorf("Apply failed with %d conflicts: %s", len(conflicts), strings.Join(conflicts, ", "))) //nolint:stylecheck // Same error message as in the API server.
+	}
+
+	// Removes fields previously owned by this field manager and not included anymore in the apply patch,
+	// unless they are owned by other field managers, then merge the apply patch.
+	removed := previous.difference(owned)
+	for _, other := range others {
+		removed = removed.difference(other.set)
+	}
+	removeFields(live, removed)
+	merged := mergeValues(live, applied).(map[string]interface{})
+
+	// Updates managed fields.
+	managedFields := []metav1.ManagedFieldsEntry{}
+	for _, other := range others {
+		if len(other.set) == 0 {
+			continue
+		}
+		raw, err := json.Marshal(other.set)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		other.entry.FieldsV1 = &metav1.FieldsV1{Raw: raw}
+		managedFields = append(managedFields, other.entry)
+	}
+	if len(owned) > 0 {
+		raw, err := json.Marshal(owned)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		now := metav1.Now()
+		apiVersion, _, _ := unstructured.NestedString(applied, "apiVersion")
+		managedFields = append(managedFields, metav1.ManagedFieldsEntry{
+			Manager:    patchOptions.FieldManager,
+			Operation:  metav1.ManagedFieldsOperationApply,
+			APIVersion: apiVersion,
+			Time:       &now,
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: raw},
+		})
+	}
+	if len(managedFields) > 0 {
+		managedFieldsU := []interface{}{}
+		for _, entry := range managedFields {
+			u, err := toUnstructuredMap(entry)
+			if err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+			managedFieldsU = append(managedFieldsU, u)
+		}
+		if err := unstructured.SetNestedSlice(merged, managedFieldsU, "metadata", "managedFields"); err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+	}
+
+	changedJS, err := json.Marshal(merged)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	return changedJS, nil
+}
+
+// appliedFieldSet returns the fieldSet for an object in an apply patch.
+func appliedFieldSet(applied map[string]interface{}) fieldSet {
+	set := newFieldSet(applied)
+	delete(set, "f:apiVersion")
+	delete(set, "f:kind")
+	if metadata, ok := set["f:metadata"]; ok {
+		for _, f := range ignoredMetadataFields {
+			delete(metadata, "f:"+f)
+		}
+		if len(metadata) == 0 {
+			delete(set, "f:metadata")
+		}
+	}
+	return set
+}
+
+func newFieldSet(obj map[string]interface{}) fieldSet {
+	set := fieldSet{}
+	for k, v := range obj {
+		set["f:"+k] = newFieldSetForValue(v)
+	}
+	return set
+}
+
+func newFieldSetForValue(v interface{}) fieldSet {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return newFieldSet(v)
+	case []interface{}:
+		if !isKeyedList(v) {
+			return fieldSet{}
+		}
+		set := fieldSet{}
+		for _, item := range v {
+			itemMap := item.(map[string]interface{})
+			itemSet := newFieldSet(itemMap)
+			itemSet[fieldSetSelf] = fieldSet{}
+			set[listItemKey(itemMap)] = itemSet
+		}
+		return set
+	}
+	return fieldSet{}
+}
+
+// has returns true if the fieldSet includes path, or a parent of it.
+func (s fieldSet) has(path []string) bool {
+	child, ok := s[path[0]]
+	if !ok {
+		return false
+	}
+	if len(child) == 0 || len(path) == 1 {
+		return true
+	}
+	return child.has(path[1:])
+}
+
+// remove removes path from the fieldSet.
+func (s fieldSet) remove(path []string) {
+	child, ok := s[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 || len(child) == 0 {
+		delete(s, path[0])
+		return
+	}
+	child.remove(path[1:])
+	if len(child) == 0 {
+		delete(s, path[0])
+	}
+}
+
+// difference returns the fields in the fieldSet which are not in other.
+func (s fieldSet) difference(other fieldSet) fieldSet {
+	result := fieldSet{}
+	for k, child := range s {
+		otherChild, ok := other[k]
+		if !ok {
+			result[k] = child
+			continue
+		}
+		if len(child) == 0 || len(otherChild) == 0 {
+			continue
+		}
+		if d := child.difference(otherChild); len(d) > 0 {
+			result[k] = d
+		}
+	}
+	return result
+}
+
+// leaves returns the path of all the leaves in the fieldSet.
+func (s fieldSet) leaves() [][]string {
+	leaves := [][]string{}
+	for k, child := range s {
+		if len(child) == 0 {
+			leaves = append(leaves, []string{k})
+			continue
+		}
+		for _, l := range child.leaves() {
+			leaves = append(leaves, append([]string{k}, l...))
+		}
+	}
+	return leaves
+}
+
+// fieldValue returns the value of the field at path in obj.
+func fieldValue(obj interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return obj, true
+	}
+	switch {
+	case path[0] == fieldSetSelf:
+		return obj, true
+	case strings.HasPrefix(path[0], "f:"):
+		m, ok := obj.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok := m[strings.TrimPrefix(path[0], "f:")]
+		if !ok {
+			return nil, false
+		}
+		return fieldValue(v, path[1:])
+	case strings.HasPrefix(path[0], "k:"):
+		l, ok := obj.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		for _, item := range l {
+			if itemMap, ok := item.(map[string]interface{}); ok && listItemKey(itemMap) == path[0] {
+				return fieldValue(item, path[1:])
+			}
+		}
+	}
+	return nil, false
+}
+
+// removeFields removes the fields in set from obj.
+func removeFields(obj map[string]interface{}, set fieldSet) {
+	for k, child := range set {
+		if !strings.HasPrefix(k, "f:") {
+			continue
+		}
+		name := strings.TrimPrefix(k, "f:")
+		v, ok := obj[name]
+		if !ok {
+			continue
+		}
+		if len(child) == 0 {
+			delete(obj, name)
+			continue
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			removeFields(v, child)
+		case []interface{}:
+			obj[name] = removeListItems(v, child)
+		}
+	}
+}
+
+func removeListItems(list []interface{}, set fieldSet) []interface{} {
+	result := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok || !isKeyedList([]interface{}{item}) {
+			result = append(result, item)
+			continue
+		}
+		child, ok := set[listItemKey(itemMap)]
+		if !ok {
+			result = append(result, item)
+			continue
+		}
+		if _, ok := child[fieldSetSelf]; ok || len(child) == 0 {
+			continue
+		}
+		removeFields(itemMap, child)
+		result = append(result, itemMap)
+	}
+	return result
+}
+
+// mergeValues merges an applied value into a live value.
+func mergeValues(live, applied interface{}) interface{} {
+	switch applied := applied.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			return applied
+		}
+		for k, v := range applied {
+			liveMap[k] = mergeValues(liveMap[k], v)
+		}
+		return liveMap
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok || !isKeyedList(applied) || !isKeyedList(liveList) {
+			return applied
+		}
+		for _, item := range applied {
+			itemMap := item.(map[string]interface{})
+			found := false
+			for i, liveItem := range liveList {
+				if listItemKey(liveItem.(map[string]interface{})) == listItemKey(itemMap) {
+					liveList[i] = mergeValues(liveItem, itemMap)
+					found = true
+					break
+				}
+			}
+			if !found {
+				liveList = append(liveList, itemMap)
+			}
+		}
+		return liveList
+	}
+	return applied
+}
+
+// isKeyedList returns true if all the items in a list are objects with a name.
+func isKeyedList(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := itemMap["name"].(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func listItemKey(item map[string]interface{}) string {
+	key, _ := json.Marshal(map[string]interface{}{"name": item["name"]})
+	return "k:" + string(key)
+}
+
+func toUnstructuredMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	u := map[string]interface{}{}
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudv1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/api/v1alpha1"
+)
+
+func Test_cache_apply(t *testing.T) {
+	applyPatch := func(labels string) client.Patch {
+		return client.RawPatch(types.ApplyPatchType, []byte(`
+apiVersion: virtual.cluster.x-k8s.io/v1alpha1
+kind: CloudMachine
+metadata:
+  name: foo
+  labels:`+labels))
+	}
+
+	t.Run("fails without a field manager", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+
+		obj := &cloudv1.CloudMachine{}
+		obj.SetName("foo")
+		err := c.Patch("foo", obj, applyPatch(`
+    a: a`))
+		g.Expect(apierrors.IsBadRequest(err)).To(BeTrue())
+	})
+
+	t.Run("apply creates, updates and removes owned fields", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+
+		// Apply creates the object.
+		obj := &cloudv1.CloudMachine{}
+		obj.SetName("foo")
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    a: a
+    b: b`), client.FieldOwner("manager-1"))).To(Succeed())
+		g.Expect(obj.GetResourceVersion()).ToNot(BeEmpty())
+		g.Expect(obj.GetLabels()).To(Equal(map[string]string{"a": "a", "b": "b"}))
+		g.Expect(obj.GetManagedFields()).To(HaveLen(1))
+		g.Expect(obj.GetManagedFields()[0].Manager).To(Equal("manager-1"))
+
+		// Another field manager adds a field.
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    c: c`), client.FieldOwner("manager-2"))).To(Succeed())
+		g.Expect(obj.GetLabels()).To(Equal(map[string]string{"a": "a", "b": "b", "c": "c"}))
+		g.Expect(obj.GetManagedFields()).To(HaveLen(2))
+
+		// Fields not included anymore in the apply patch are removed, fields owned by other managers are preserved.
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    a: a`), client.FieldOwner("manager-1"))).To(Succeed())
+		g.Expect(obj.GetLabels()).To(Equal(map[string]string{"a": "a", "c": "c"}))
+
+		// Check the object stored in the cache.
+		stored := &cloudv1.CloudMachine{}
+		g.Expect(c.Get("foo", client.ObjectKeyFromObject(obj), stored)).To(Succeed())
+		g.Expect(stored.GetLabels()).To(Equal(map[string]string{"a": "a", "c": "c"}))
+		g.Expect(stored.GetManagedFields()).To(HaveLen(2))
+	})
+
+	t.Run("apply detects conflicts with other field managers", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+
+		obj := &cloudv1.CloudMachine{}
+		obj.SetName("foo")
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    a: a`), client.FieldOwner("manager-1"))).To(Succeed())
+
+		// Applying the same value does not conflict.
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    a: a`), client.FieldOwner("manager-2"))).To(Succeed())
+
+		// Applying a different value conflicts.
+		err := c.Patch("foo", obj.DeepCopy(), applyPatch(`
+    a: b`), client.FieldOwner("manager-2"))
+		g.Expect(apierrors.IsConflict(err)).To(BeTrue())
+
+		// Unless force is used; in this case ownership is transferred.
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    a: b`), client.FieldOwner("manager-2"), client.ForceOwnership)).To(Succeed())
+		g.Expect(obj.GetLabels()).To(Equal(map[string]string{"a": "b"}))
+		g.Expect(obj.GetManagedFields()).To(HaveLen(1))
+		g.Expect(obj.GetManagedFields()[0].Manager).To(Equal("manager-2"))
+	})
+
+	t.Run("dry-run apply returns the patched object without storing it", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+
+		obj := &cloudv1.CloudMachine{}
+		obj.SetName("foo")
+		g.Expect(c.Patch("foo", obj, applyPatch(`
+    a: a`), client.FieldOwner("manager-1"), client.DryRunAll)).To(Succeed())
+		g.Expect(obj.GetLabels()).To(Equal(map[string]string{"a": "a"}))
+		g.Expect(obj.GetManagedFields()).To(HaveLen(1))
+
+		err := c.Get("foo", client.ObjectKeyFromObject(obj), &cloudv1.CloudMachine{})
+		g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+}
+
+func Test_mergeValues(t *testing.T) {
+	g := NewWithT(t)
+
+	live := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "a", "image": "a:v1", "args": []interface{}{"--foo"}},
+			map[string]interface{}{"name": "b", "image": "b:v1"},
+		},
+		"replicas": int64(1),
+	}
+	applied := map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "a", "args": []interface{}{"--bar"}},
+			map[string]interface{}{"name": "c", "image": "c:v1"},
+		},
+	}
+
+	g.Expect(mergeValues(live, applied)).To(Equal(map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "a", "image": "a:v1", "args": []interface{}{"--bar"}},
+			map[string]interface{}{"name": "b", "image": "b:v1"},
+			map[string]interface{}{"name": "c", "image": "c:v1"},
+		},
+		"replicas": int64(1),
+	}))
+}
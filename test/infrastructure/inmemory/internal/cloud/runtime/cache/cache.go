@@ -41,13 +41,16 @@ type Cache interface {
 
 	AddResourceGroup(name string)
 	DeleteResourceGroup(name string)
+	HasResourceGroup(name string) bool
+	EventsSince(resourceGroup string, gvk schema.GroupVersionKind, resourceVersion string) ([]WatchEvent, error)
+	ResourceVersion(resourceGroup string) (string, error)
 
 	Get(resourceGroup string, key client.ObjectKey, obj client.Object) error
 	List(resourceGroup string, list client.ObjectList, opts ...client.ListOption) error
 	Create(resourceGroup string, obj client.Object) error
 	Delete(resourceGroup string, obj client.Object) error
 	Update(resourceGroup string, obj client.Object) error
-	Patch(resourceGroup string, obj client.Object, patch client.Patch) error
+	Patch(resourceGroup string, obj client.Object, patch client.Patch, opts ...client.PatchOption) error
 
 	GetInformer(ctx context.Context, obj client.Object) (Informer, error)
 	GetInformerForKind(ctx context.Context, gvk schema.GroupVersionKind) (Informer, error)
@@ -91,6 +94,13 @@ type resourceGroupTracker struct {
 	objects map[schema.GroupVersionKind]map[types.NamespacedName]client.Object
 	// ownedObjects tracks ownership. Key is the owner, values are the owned objects.
 	ownedObjects map[ownReference]map[ownReference]struct{}
+
+	// resourceVersion is the last resource version assigned to an object in the resource group.
+	resourceVersion uint64
+	// history keeps the latest events in the resource group, and compactedResourceVersion is the resource version
+	// of the last event dropped from it.
+	history                  []historyEvent
+	compactedResourceVersion uint64
 }
 
 type ownReference struct {
@@ -171,6 +181,13 @@ func (c *cache) DeleteResourceGroup(name string) {
 	delete(c.resourceGroups, name)
 }
 
+func (c *cache) HasResourceGroup(name string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	_, ok := c.resourceGroups[name]
+	return ok
+}
+
 func (c *cache) resourceGroupTracker(resourceGroup string) *resourceGroupTracker {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -18,6 +18,8 @@ package cache
 
 import (
 	"fmt"
+	"reflect"
+	"strconv"
 	"time"
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
@@ -101,11 +103,24 @@ func (c *cache) List(resourceGroup string, list client.ObjectList, opts ...clien
 	tracker.lock.RLock()
 	defer tracker.lock.RUnlock()
 
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	// NOTE: Lists are always served from the current state; the requested resource version is only used
+	// to detect clients lagging too much behind.
+	if listOpts.Raw != nil {
+		rv, err := ParseResourceVersion(listOpts.Raw.ResourceVersion)
+		if err != nil {
+			return err
+		}
+		if err := tracker.checkResourceVersionLocked(rv); err != nil {
+			return err
+		}
+	}
+
 	items := make([]runtime.Object, 0)
 	objects, ok := tracker.objects[unsafeGuessObjectKindFromList(gvk)]
 	if ok {
-		listOpts := client.ListOptions{}
-		listOpts.ApplyOptions(opts)
 
 		for _, obj := range objects {
 			if listOpts.Namespace != "" && obj.GetNamespace() != listOpts.Namespace {
@@ -119,12 +134,9 @@ func (c *cache) List(resourceGroup string, list client.ObjectList, opts ...clien
 				}
 			}
 
-			// TODO(killianmuldoon): This only matches the nodeName field for pods. No other fieldSelectors are implemented. This should return an error if another fieldselector is used.
-			if pod, ok := obj.(*corev1.Pod); ok {
-				if listOpts.FieldSelector != nil && !listOpts.FieldSelector.Empty() {
-					if !listOpts.FieldSelector.Matches(fields.Set{"spec.nodeName": pod.Spec.NodeName}) {
-						continue
-					}
+			if listOpts.FieldSelector != nil && !listOpts.FieldSelector.Empty() {
+				if !listOpts.FieldSelector.Matches(ObjectFields(obj)) {
+					continue
 				}
 			}
 
@@ -145,6 +157,11 @@ func (c *cache) List(resourceGroup string, list client.ObjectList, opts ...clien
 	if err := meta.SetList(list, items); err != nil {
 		return apierrors.NewInternalError(err)
 	}
+	listAccessor, err := meta.ListAccessor(list)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	listAccessor.SetResourceVersion(strconv.FormatUint(tracker.resourceVersion, 10))
 	return nil
 }
 
@@ -282,7 +299,14 @@ func updateTrackerOwnerReferences(tracker *resourceGroupTracker, oldObj, newObj
 	}
 }
 
-func (c *cache) Patch(resourceGroup string, obj client.Object, patch client.Patch) error {
+func (c *cache) Patch(resourceGroup string, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	patchOptions := &client.PatchOptions{}
+	patchOptions.ApplyOptions(opts)
+
+	if _, err := c.gvkGetAndSet(obj); err != nil {
+		return err
+	}
+
 	patchData, err := patch.Data(obj)
 	if err != nil {
 		return apierrors.NewInternalError(err)
@@ -311,17 +335,34 @@ func (c *cache) Patch(resourceGroup string, obj client.Object, patch client.Patc
 		if err != nil {
 			return apierrors.NewInternalError(err)
 		}
+	case types.ApplyPatchType:
+		changedJS, err = applyPatch(obj, originalObjJS, patchData, patchOptions)
+		if err != nil {
+			return err
+		}
 	default:
 		return apierrors.NewBadRequest(fmt.Sprintf("patch of type %s is not supported", patch.Type()))
 	}
 
+	// NOTE: Apply patches on objects which do not exist yet (without a resourceVersion) create them, like in a real API server.
+	replaceExisting := patch.Type() != types.ApplyPatchType || obj.GetResourceVersion() != ""
+
+	// NOTE: Reset obj before decoding the changed object, so fields removed by the patch are dropped also from typed objects.
+	objValue := reflect.ValueOf(obj).Elem()
+	objValue.Set(reflect.Zero(objValue.Type()))
+
 	codecFactory := serializer.NewCodecFactory(c.scheme)
 	err = runtime.DecodeInto(codecFactory.UniversalDecoder(), changedJS, obj)
 	if err != nil {
 		return apierrors.NewInternalError(err)
 	}
 
-	return c.store(resourceGroup, obj, true)
+	// NOTE: On dry-run the patched object is returned without storing it.
+	if len(patchOptions.DryRun) > 0 && patchOptions.DryRun[0] == metav1.DryRunAll {
+		return nil
+	}
+
+	return c.store(resourceGroup, obj, replaceExisting)
 }
 
 func (c *cache) getEncoder(obj runtime.Object, gv runtime.GroupVersioner) (runtime.Encoder, error) {
@@ -444,3 +485,16 @@ func (c *cache) doTryDeleteLocked(resourceGroup string, tracker *resourceGroupTr
 	c.afterDelete(resourceGroup, obj)
 	return true, nil
 }
+
+// ObjectFields returns the fields of an object which can be used in field selectors.
+// NOTE: Only metadata.name and metadata.namespace are supported for all the objects, plus spec.nodeName for pods.
+func ObjectFields(obj client.Object) fields.Set {
+	ret := fields.Set{
+		"metadata.name":      obj.GetName(),
+		"metadata.namespace": obj.GetNamespace(),
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		ret["spec.nodeName"] = pod.Spec.NodeName
+	}
+	return ret
+}
@@ -104,7 +104,7 @@ func Test_cache_client(t *testing.T) {
 			r := c.resourceGroups["foo"].objects[cloudv1.GroupVersion.WithKind(cloudv1.CloudMachineKind)][key]
 			g.Expect(r.GetObjectKind().GroupVersionKind()).To(Equal(cloudv1.GroupVersion.WithKind(cloudv1.CloudMachineKind)), "gvk must be set")
 			g.Expect(r.GetName()).To(Equal("bar"), "name must be equal to object tracker key")
-			g.Expect(r.GetResourceVersion()).To(Equal("v1"), "resourceVersion must be set")
+			g.Expect(r.GetResourceVersion()).To(Equal("1"), "resourceVersion must be set")
 			g.Expect(r.GetCreationTimestamp()).ToNot(BeZero(), "creation timestamp must be set")
 			g.Expect(r.GetAnnotations()).To(HaveKey(lastSyncTimeAnnotation), "last sync annotation must exists")
 
@@ -262,7 +262,7 @@ func Test_cache_client(t *testing.T) {
 		t.Run("get", func(t *testing.T) {
 			g := NewWithT(t)
 
-			createMachine(t, c, "foo", "bar")
+			createdObj := createMachine(t, c, "foo", "bar")
 
 			obj := &cloudv1.CloudMachine{}
 			err := c.Get("foo", types.NamespacedName{Name: "bar"}, obj)
@@ -271,7 +271,7 @@ func Test_cache_client(t *testing.T) {
 			// Check all the computed fields are as expected.
 			g.Expect(obj.GetObjectKind().GroupVersionKind()).To(Equal(cloudv1.GroupVersion.WithKind(cloudv1.CloudMachineKind)), "gvk must be set")
 			g.Expect(obj.GetName()).To(Equal("bar"), "name must be equal to object tracker key")
-			g.Expect(obj.GetResourceVersion()).To(Equal("v1"), "resourceVersion must be set")
+			g.Expect(obj.GetResourceVersion()).To(Equal(createdObj.GetResourceVersion()), "resourceVersion must be set")
 			g.Expect(obj.GetCreationTimestamp()).ToNot(BeZero(), "creation timestamp must be set")
 			g.Expect(obj.GetAnnotations()).To(HaveKey(lastSyncTimeAnnotation), "last sync annotation must be set")
 		})
@@ -332,6 +332,17 @@ func Test_cache_client(t *testing.T) {
 			g.Expect(i2.GetAnnotations()).To(HaveKey(lastSyncTimeAnnotation), "last sync annotation must be present")
 		})
 
+		t.Run("list with field selector", func(t *testing.T) {
+			g := NewWithT(t)
+
+			obj := &cloudv1.CloudMachineList{}
+			err := c.List("foo", obj, client.MatchingFields{"metadata.name": "bar"})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			g.Expect(obj.Items).To(HaveLen(1))
+			g.Expect(obj.Items[0].GetName()).To(Equal("bar"))
+		})
+
 		// TODO: test filtering by labels
 	})
 
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// historySize is the number of events kept for each resource group, so watches can start from a resource version
+// in the past; watches starting from an older resource version fail with a ResourceExpired error.
+const historySize = 1000
+
+// WatchEvent is an event for an object stored in the cache.
+type WatchEvent struct {
+	Type   watch.EventType
+	Object client.Object
+}
+
+type historyEvent struct {
+	resourceVersion uint64
+	gvk             schema.GroupVersionKind
+	event           WatchEvent
+}
+
+// ParseResourceVersion parses a resource version generated by the cache; an empty resource version is parsed as 0.
+func ParseResourceVersion(resourceVersion string) (uint64, error) {
+	if resourceVersion == "" {
+		return 0, nil
+	}
+	rv, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return 0, apierrors.NewBadRequest(fmt.Sprintf("invalid resourceVersion %q", resourceVersion))
+	}
+	return rv, nil
+}
+
+// EventsSince returns the events for objects of the given kind with a resource version greater than resourceVersion,
+// in the order they happened. If some of those events are not kept anymore, a ResourceExpired error is returned.
+// NOTE: An empty or zero resource version means "from now", and no events are returned.
+func (c *cache) EventsSince(resourceGroup string, gvk schema.GroupVersionKind, resourceVersion string) ([]WatchEvent, error) {
+	rv, err := ParseResourceVersion(resourceVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := c.resourceGroupTracker(resourceGroup)
+	if tracker == nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("resourceGroup %s does not exist", resourceGroup))
+	}
+
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	if rv == 0 {
+		return nil, nil
+	}
+	if err := tracker.checkResourceVersionLocked(rv); err != nil {
+		return nil, err
+	}
+
+	ret := []WatchEvent{}
+	for _, e := range tracker.history {
+		if e.resourceVersion <= rv || e.gvk != gvk {
+			continue
+		}
+		ret = append(ret, WatchEvent{Type: e.event.Type, Object: e.event.Object.DeepCopyObject().(client.Object)})
+	}
+	return ret, nil
+}
+
+// ResourceVersion returns the last resource version assigned to an object in the resource group.
+// NOTE: Events for objects up to the returned resource version are already dispatched to the event handlers,
+// because event handlers are called while holding the lock of the resource group.
+func (c *cache) ResourceVersion(resourceGroup string) (string, error) {
+	tracker := c.resourceGroupTracker(resourceGroup)
+	if tracker == nil {
+		return "", apierrors.NewBadRequest(fmt.Sprintf("resourceGroup %s does not exist", resourceGroup))
+	}
+
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	return strconv.FormatUint(tracker.resourceVersion, 10), nil
+}
+
+// checkResourceVersionLocked returns a ResourceExpired error if events after the given resource version are not kept anymore.
+// Note: The tracker must be already locked when calling this method.
+func (t *resourceGroupTracker) checkResourceVersionLocked(rv uint64) error {
+	if rv < t.compactedResourceVersion {
+		return apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rv, t.compactedResourceVersion))
+	}
+	return nil
+}
+
+// nextResourceVersionLocked returns the next resource version for the resource group.
+// Note: The tracker must be already locked when calling this method.
+func (t *resourceGroupTracker) nextResourceVersionLocked() string {
+	t.resourceVersion++
+	return strconv.FormatUint(t.resourceVersion, 10)
+}
+
+// recordEventLocked adds an event to the history of the resource group, dropping the oldest event when the history is full.
+// Note: The tracker must be already locked when calling this method.
+func (t *resourceGroupTracker) recordEventLocked(eventType watch.EventType, obj client.Object) {
+	rv, err := ParseResourceVersion(obj.GetResourceVersion())
+	if err != nil {
+		return
+	}
+	if len(t.history) >= historySize {
+		t.compactedResourceVersion = t.history[0].resourceVersion
+		t.history = t.history[1:]
+	}
+	t.history = append(t.history, historyEvent{
+		resourceVersion: rv,
+		gvk:             obj.GetObjectKind().GroupVersionKind(),
+		event:           WatchEvent{Type: eventType, Object: obj.DeepCopyObject().(client.Object)},
+	})
+}
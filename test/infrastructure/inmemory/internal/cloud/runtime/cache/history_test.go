@@ -0,0 +1,118 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cloudv1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/api/v1alpha1"
+)
+
+func Test_cache_history(t *testing.T) {
+	machineGVK := cloudv1.GroupVersion.WithKind(cloudv1.CloudMachineKind)
+
+	t.Run("resource versions are monotonic in a resource group", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+		c.AddResourceGroup("bar")
+
+		foo1 := createMachine(t, c, "foo", "foo1")
+		bar1 := createMachine(t, c, "bar", "bar1")
+		foo2 := createMachine(t, c, "foo", "foo2")
+		g.Expect(foo1.GetResourceVersion()).To(Equal("1"))
+		g.Expect(bar1.GetResourceVersion()).To(Equal("1"))
+		g.Expect(foo2.GetResourceVersion()).To(Equal("2"))
+
+		foo1.SetLabels(map[string]string{"foo": "bar"})
+		g.Expect(c.Update("foo", foo1)).To(Succeed())
+		g.Expect(foo1.GetResourceVersion()).To(Equal("3"))
+
+		list := &cloudv1.CloudMachineList{}
+		g.Expect(c.List("foo", list)).To(Succeed())
+		g.Expect(list.GetResourceVersion()).To(Equal("3"))
+
+		rv, err := c.ResourceVersion("foo")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rv).To(Equal("3"))
+	})
+
+	t.Run("EventsSince returns events after a resource version", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+
+		foo1 := createMachine(t, c, "foo", "foo1")
+		createMachine(t, c, "foo", "foo2")
+		g.Expect(c.Delete("foo", foo1)).To(Succeed())
+
+		events, err := c.EventsSince("foo", machineGVK, "")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(events).To(BeEmpty())
+
+		events, err = c.EventsSince("foo", machineGVK, "1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(eventStrings(events)).To(Equal([]string{"ADDED/foo2", "DELETED/foo1"}))
+
+		_, err = c.EventsSince("foo", machineGVK, "not-a-number")
+		g.Expect(apierrors.IsBadRequest(err)).To(BeTrue())
+	})
+
+	t.Run("Resource versions older than the history are expired", func(t *testing.T) {
+		g := NewWithT(t)
+
+		c := NewCache(scheme).(*cache)
+		c.AddResourceGroup("foo")
+
+		for i := 0; i <= historySize; i++ {
+			createMachine(t, c, "foo", fmt.Sprintf("foo%d", i))
+		}
+
+		_, err := c.EventsSince("foo", machineGVK, "0")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = c.EventsSince("foo", machineGVK, "1")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		// Add one more event, so the event with resource version 2 is dropped.
+		createMachine(t, c, "foo", "bar")
+
+		_, err = c.EventsSince("foo", machineGVK, "1")
+		g.Expect(apierrors.IsResourceExpired(err)).To(BeTrue())
+		events, err := c.EventsSince("foo", machineGVK, "2")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(events).To(HaveLen(historySize))
+
+		err = c.List("foo", &cloudv1.CloudMachineList{}, &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: "1"}})
+		g.Expect(apierrors.IsResourceExpired(err)).To(BeTrue())
+	})
+}
+
+func eventStrings(events []WatchEvent) []string {
+	ret := []string{}
+	for _, e := range events {
+		ret = append(ret, fmt.Sprintf("%s/%s", e.Type, e.Object.GetName()))
+	}
+	return ret
+}
@@ -17,30 +17,35 @@ limitations under the License.
 package cache
 
 import (
-	"fmt"
 	"reflect"
-	"strconv"
-	"strings"
 	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (c *cache) beforeCreate(_ string, obj client.Object) error {
+// NOTE: Hooks are called with the tracker of the resource group locked.
+
+func (c *cache) beforeCreate(resourceGroup string, obj client.Object) error {
 	now := time.Now().UTC()
 	obj.SetCreationTimestamp(metav1.Time{Time: now})
 	// TODO: UID
 	obj.SetAnnotations(appendAnnotations(obj, lastSyncTimeAnnotation, now.Format(time.RFC3339)))
-	obj.SetResourceVersion(fmt.Sprintf("v%d", 1))
+	if tracker := c.resourceGroupTracker(resourceGroup); tracker != nil {
+		obj.SetResourceVersion(tracker.nextResourceVersionLocked())
+	}
 	return nil
 }
 
 func (c *cache) afterCreate(resourceGroup string, obj client.Object) {
+	if tracker := c.resourceGroupTracker(resourceGroup); tracker != nil {
+		tracker.recordEventLocked(watch.Added, obj)
+	}
 	c.informCreate(resourceGroup, obj)
 }
 
-func (c *cache) beforeUpdate(_ string, oldObj, newObj client.Object) error {
+func (c *cache) beforeUpdate(resourceGroup string, oldObj, newObj client.Object) error {
 	newObj.SetCreationTimestamp(oldObj.GetCreationTimestamp())
 	newObj.SetResourceVersion(oldObj.GetResourceVersion())
 	// TODO: UID
@@ -52,18 +57,26 @@ func (c *cache) beforeUpdate(_ string, oldObj, newObj client.Object) error {
 		now := time.Now().UTC()
 		newObj.SetAnnotations(appendAnnotations(newObj, lastSyncTimeAnnotation, now.Format(time.RFC3339)))
 
-		oldResourceVersion, _ := strconv.Atoi(strings.TrimPrefix(oldObj.GetResourceVersion(), "v"))
-		newObj.SetResourceVersion(fmt.Sprintf("v%d", oldResourceVersion+1))
+		if tracker := c.resourceGroupTracker(resourceGroup); tracker != nil {
+			newObj.SetResourceVersion(tracker.nextResourceVersionLocked())
+		}
 	}
 	return nil
 }
 
 func (c *cache) afterUpdate(resourceGroup string, oldObj, newObj client.Object) {
+	tracker := c.resourceGroupTracker(resourceGroup)
 	if oldObj.GetDeletionTimestamp().IsZero() && !newObj.GetDeletionTimestamp().IsZero() {
+		if tracker != nil {
+			tracker.recordEventLocked(watch.Deleted, newObj)
+		}
 		c.informDelete(resourceGroup, newObj)
 		return
 	}
 	if !reflect.DeepEqual(newObj, oldObj) {
+		if tracker != nil {
+			tracker.recordEventLocked(watch.Modified, newObj)
+		}
 		c.informUpdate(resourceGroup, oldObj, newObj)
 	}
 }
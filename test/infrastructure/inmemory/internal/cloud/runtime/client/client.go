@@ -43,7 +43,7 @@ type Writer interface {
 	Update(ctx context.Context, obj client.Object) error
 
 	// Patch patches a resource in a resource group.
-	Patch(ctx context.Context, obj client.Object, patch client.Patch) error
+	Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error
 }
 
 // Client knows how to perform CRUD operations on resources in a resource group.
@@ -34,6 +34,7 @@ type Manager interface {
 	// TODO: refactor in resoucegroup.add/delete/get; make delete fail if rs does not exist
 	AddResourceGroup(name string)
 	DeleteResourceGroup(name string)
+	HasResourceGroup(name string) bool
 	GetResourceGroup(name string) cresourcegroup.ResourceGroup
 
 	GetScheme() *runtime.Scheme
@@ -75,6 +76,10 @@ func (m *manager) DeleteResourceGroup(name string) {
 	m.cache.DeleteResourceGroup(name)
 }
 
+func (m *manager) HasResourceGroup(name string) bool {
+	return m.cache.HasResourceGroup(name)
+}
+
 // GetResourceGroup returns a resource group which reads from the cache.
 func (m *manager) GetResourceGroup(name string) cresourcegroup.ResourceGroup {
 	return cresourcegroup.NewResourceGroup(name, m.cache)
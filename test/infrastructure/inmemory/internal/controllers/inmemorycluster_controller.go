@@ -145,6 +145,24 @@ func (r *InMemoryClusterReconciler) reconcileHotRestart(ctx context.Context) err
 	if err := r.Client.List(ctx, inMemoryClusterList); err != nil {
 		return err
 	}
+	// Create the resource groups for the existing InMemoryCluster before restarting the APIServerMux, which requires them.
+	// NOTE: resource groups are not persisted, so they are lost on restart.
+	// NOTE: resource groups are created only for InMemoryClusters whose Cluster still exists; the APIServerMux skips
+	// the other InMemoryClusters, which are going to be deleted.
+	for _, c := range inMemoryClusterList.Items {
+		resourceGroup, ok := c.Annotations[infrav1.ResourceGroupAnnotationName]
+		if !ok {
+			continue
+		}
+		cluster, err := util.GetOwnerCluster(ctx, r.Client, c.ObjectMeta)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		if cluster == nil {
+			continue
+		}
+		r.CloudManager.AddResourceGroup(resourceGroup)
+	}
 	if err := r.APIServerMux.HotRestart(inMemoryClusterList); err != nil {
 		return err
 	}
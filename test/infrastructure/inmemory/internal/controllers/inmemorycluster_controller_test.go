@@ -0,0 +1,105 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	infrav1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/api/v1alpha1"
+	cmanager "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/runtime/manager"
+	"sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/server"
+)
+
+func TestReconcileHotRestart(t *testing.T) {
+	g := NewWithT(t)
+
+	clusterScheme := runtime.NewScheme()
+	g.Expect(clusterv1.AddToScheme(clusterScheme)).To(Succeed())
+	g.Expect(infrav1.AddToScheme(clusterScheme)).To(Succeed())
+
+	existingCluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceDefault,
+			Name:      "existing",
+		},
+	}
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := server.NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		server.WithPortRange(server.DefaultMinPort+9200, server.DefaultMinPort+9299),
+		server.WithDebugPort(server.DefaultDebugPort+106),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+	t.Cleanup(func() {
+		g.Expect(wcmux.Shutdown(ctx)).To(Succeed())
+	})
+
+	r := InMemoryClusterReconciler{
+		Client: fake.NewClientBuilder().WithScheme(clusterScheme).WithObjects(
+			existingCluster,
+			newHotRestartInMemoryCluster("existing", "existing", server.DefaultMinPort+9200),
+			newHotRestartInMemoryCluster("deleted", "deleted", server.DefaultMinPort+9201),
+		).Build(),
+		CloudManager: manager,
+		APIServerMux: wcmux,
+	}
+
+	g.Expect(r.reconcileHotRestart(ctx)).To(Succeed())
+	g.Expect(r.hotRestartDone).To(BeTrue())
+
+	// The resource group and the listener are restored for the InMemoryCluster whose Cluster still exists.
+	g.Expect(manager.HasResourceGroup("default/existing")).To(BeTrue())
+	g.Expect(wcmux.ListListeners()).To(HaveKey("default/existing"))
+
+	// The InMemoryCluster whose Cluster has been deleted is skipped.
+	g.Expect(manager.HasResourceGroup("default/deleted")).To(BeFalse())
+	g.Expect(wcmux.ListListeners()).ToNot(HaveKey("default/deleted"))
+}
+
+func newHotRestartInMemoryCluster(name, clusterName string, port int) *infrav1.InMemoryCluster {
+	return &infrav1.InMemoryCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceDefault,
+			Name:      name,
+			Annotations: map[string]string{
+				infrav1.ResourceGroupAnnotationName: metav1.NamespaceDefault + "/" + clusterName,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: clusterv1.GroupVersion.String(),
+					Kind:       "Cluster",
+					Name:       clusterName,
+				},
+			},
+		},
+		Spec: infrav1.InMemoryClusterSpec{
+			ControlPlaneEndpoint: infrav1.APIEndpoint{
+				Host: "127.0.0.1",
+				Port: port,
+			},
+		},
+	}
+}
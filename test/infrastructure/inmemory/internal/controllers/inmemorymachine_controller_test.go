@@ -314,12 +314,11 @@ func TestReconcileNormalEtcd(t *testing.T) {
 		manager := cmanager.New(scheme)
 
 		host := "127.0.0.1" //nolint:goconst
-		wcmux, err := server.NewWorkloadClustersMux(manager, host, server.CustomPorts{
+		wcmux, err := server.NewWorkloadClustersMux(manager, host,
 			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-			MinPort:   server.DefaultMinPort + 1000,
-			MaxPort:   server.DefaultMinPort + 1099,
-			DebugPort: server.DefaultDebugPort + 10,
-		})
+			server.WithPortRange(server.DefaultMinPort+1000, server.DefaultMinPort+1099),
+			server.WithDebugPort(server.DefaultDebugPort+10),
+		)
 		g.Expect(err).ToNot(HaveOccurred())
 		_, err = wcmux.InitWorkloadClusterListener(klog.KObj(cluster).String())
 		g.Expect(err).ToNot(HaveOccurred())
@@ -393,12 +392,11 @@ func TestReconcileNormalEtcd(t *testing.T) {
 		manager := cmanager.New(scheme)
 
 		host := "127.0.0.1"
-		wcmux, err := server.NewWorkloadClustersMux(manager, host, server.CustomPorts{
+		wcmux, err := server.NewWorkloadClustersMux(manager, host,
 			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-			MinPort:   server.DefaultMinPort + 1200,
-			MaxPort:   server.DefaultMinPort + 1299,
-			DebugPort: server.DefaultDebugPort + 20,
-		})
+			server.WithPortRange(server.DefaultMinPort+1200, server.DefaultMinPort+1299),
+			server.WithDebugPort(server.DefaultDebugPort+20),
+		)
 		g.Expect(err).ToNot(HaveOccurred())
 		_, err = wcmux.InitWorkloadClusterListener(klog.KObj(cluster).String())
 		g.Expect(err).ToNot(HaveOccurred())
@@ -521,12 +519,11 @@ func TestReconcileNormalApiServer(t *testing.T) {
 		manager := cmanager.New(scheme)
 
 		host := "127.0.0.1"
-		wcmux, err := server.NewWorkloadClustersMux(manager, host, server.CustomPorts{
+		wcmux, err := server.NewWorkloadClustersMux(manager, host,
 			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-			MinPort:   server.DefaultMinPort + 1100,
-			MaxPort:   server.DefaultMinPort + 1199,
-			DebugPort: server.DefaultDebugPort + 11,
-		})
+			server.WithPortRange(server.DefaultMinPort+1100, server.DefaultMinPort+1199),
+			server.WithDebugPort(server.DefaultDebugPort+11),
+		)
 		g.Expect(err).ToNot(HaveOccurred())
 		_, err = wcmux.InitWorkloadClusterListener(klog.KObj(cluster).String())
 		g.Expect(err).ToNot(HaveOccurred())
@@ -19,10 +19,13 @@ package api
 import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 var (
-	// apiVersions is the value returned by /api discovery call.
+	// supportedAPIResourceLists are the APIResourceLists for all the resources supported by the API server handler;
+	// discovery calls return the subset of them registered in the scheme of the manager.
 	// Note: This must contain all APIs required by CAPI.
-	apiVersions = &metav1.APIVersions{
-		Versions: []string{"v1"},
+	supportedAPIResourceLists = []*metav1.APIResourceList{
+		corev1APIResourceList,
+		rbacv1APIResourceList,
+		appsV1ResourceList,
 	}
 
 	// apiVersions is the value returned by /api/v1 discovery call.
@@ -105,39 +108,6 @@ var (
 		},
 	}
 
-	// apiVersions is the value returned by /apis discovery call.
-	// Note: This must contain all APIs required by CAPI.
-	apiGroupList = &metav1.APIGroupList{
-		Groups: []metav1.APIGroup{
-			{
-				Name: "rbac.authorization.k8s.io",
-				Versions: []metav1.GroupVersionForDiscovery{
-					{
-						GroupVersion: "rbac.authorization.k8s.io/v1",
-						Version:      "v1",
-					},
-				},
-				PreferredVersion: metav1.GroupVersionForDiscovery{
-					GroupVersion: "rbac.authorization.k8s.io/v1",
-					Version:      "v1",
-				},
-			},
-			{
-				Name: "apps",
-				Versions: []metav1.GroupVersionForDiscovery{
-					{
-						GroupVersion: "apps/v1",
-						Version:      "v1",
-					},
-				},
-				PreferredVersion: metav1.GroupVersionForDiscovery{
-					GroupVersion: "apps/v1",
-					Version:      "v1",
-				},
-			},
-		},
-	}
-
 	// apiVersions is the value returned by /apis/rbac.authorization.k8s.io/v1  discovery call.
 	// Note: This must contain all APIs required by CAPI.
 	rbacv1APIResourceList = &metav1.APIResourceList{
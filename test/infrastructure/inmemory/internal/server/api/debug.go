@@ -17,28 +17,89 @@ limitations under the License.
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/emicklei/go-restful/v3"
 	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	cmanager "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/runtime/manager"
 )
 
+// DefaultDebugTimeout is the default timeout for collecting debug info.
+const DefaultDebugTimeout = 5 * time.Second
+
+// ErrListenerNotFound is returned by a DebugInfoProvider when the requested workload cluster listener does not exist.
+var ErrListenerNotFound = errors.New("workload cluster listener not found")
+
 // DebugInfoProvider defines the methods the server must implement
-// to provide debug info.
+// to provide debug info, and to support debug operations like restarting a listener.
+// NOTE: Methods accepting a context must return when the context is done, e.g. when the debug timeout expires.
 type DebugInfoProvider interface {
-	ListListeners() map[string]string
+	ListListenersWithContext(ctx context.Context) (map[string]string, error)
+	ListCertificateErrorsWithContext(ctx context.Context) (map[string][]string, error)
+	ListenerLimits() map[string]int
+	ListenerDetailsWithContext(ctx context.Context) (map[string]ListenerDetails, error)
+	RestartListener(ctx context.Context, name string) (string, error)
+}
+
+// ListenerRestart is the response of a listener restart.
+type ListenerRestart struct {
+	// Address is the address of the listener after the restart.
+	Address string `json:"address"`
+}
+
+// ListenerDetails provides details about a workload cluster listener.
+type ListenerDetails struct {
+	// Address is the address of the listener.
+	Address string `json:"address"`
+
+	// APIServerCertificateNotAfter is the expiry of the API server serving certificate, if any.
+	APIServerCertificateNotAfter *metav1.Time `json:"apiServerCertificateNotAfter,omitempty"`
+
+	// EtcdCertificatesNotAfter is the expiry of the etcd serving certificates, by SNI name.
+	EtcdCertificatesNotAfter map[string]metav1.Time `json:"etcdCertificatesNotAfter,omitempty"`
+
+	// APIServers is the number of API server instances behind the listener.
+	APIServers int `json:"apiServers,omitempty"`
+
+	// DesiredAPIServers is the desired number of API server instances behind the listener, if set.
+	DesiredAPIServers *int `json:"desiredAPIServers,omitempty"`
+
+	// Requests is the number of requests served by the listener.
+	Requests int64 `json:"requests,omitempty"`
+
+	// LastError is the last error served by the listener, if any.
+	LastError *ListenerLastError `json:"lastError,omitempty"`
+}
+
+// ListenerLastError provides details about the last error served by a workload cluster listener.
+type ListenerLastError struct {
+	// Time is the time when the error was served.
+	Time metav1.Time `json:"time"`
+
+	// Message describes the error.
+	Message string `json:"message"`
 }
 
 // NewDebugHandler returns an http.Handler for debugging the server.
-func NewDebugHandler(manager cmanager.Manager, log logr.Logger, infoProvider DebugInfoProvider) http.Handler {
+// If debug info are not collected within the given timeout, e.g. because the server is busy or degraded,
+// the handler responds with 503 Service Unavailable.
+func NewDebugHandler(manager cmanager.Manager, log logr.Logger, infoProvider DebugInfoProvider, timeout time.Duration) http.Handler {
+	if timeout <= 0 {
+		timeout = DefaultDebugTimeout
+	}
 	debugServer := &debugHandler{
 		container:    restful.NewContainer(),
 		manager:      manager,
 		log:          log,
 		infoProvider: infoProvider,
+		timeout:      timeout,
 	}
 
 	ws := new(restful.WebService)
@@ -46,6 +107,10 @@ func NewDebugHandler(manager cmanager.Manager, log logr.Logger, infoProvider Deb
 
 	// Discovery endpoints
 	ws.Route(ws.GET("/listeners").To(debugServer.listenersList))
+	ws.Route(ws.GET("/listeners/limits").To(debugServer.listenerLimits))
+	ws.Route(ws.GET("/listeners/details").To(debugServer.listenerDetails))
+	ws.Route(ws.GET("/certificates/errors").To(debugServer.certificateErrorsList))
+	ws.Route(ws.POST("/listeners/{name}/restart").To(debugServer.listenerRestart))
 
 	debugServer.container.Add(ws)
 
@@ -57,16 +122,75 @@ type debugHandler struct {
 	manager      cmanager.Manager
 	log          logr.Logger
 	infoProvider DebugInfoProvider
+	timeout      time.Duration
 }
 
 func (h *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.container.ServeHTTP(w, r)
 }
 
-func (h *debugHandler) listenersList(_ *restful.Request, resp *restful.Response) {
-	listeners := h.infoProvider.ListListeners()
+// writeEntityWithTimeout writes the debug info returned by get, or 503 Service Unavailable if get does not
+// complete within the timeout of the debug handler.
+// NOTE: get is called with a context which is done when the timeout expires.
+func (h *debugHandler) writeEntityWithTimeout(req *restful.Request, resp *restful.Response, get func(ctx context.Context) (interface{}, error)) {
+	ctx, cancel := context.WithTimeout(req.Request.Context(), h.timeout)
+	defer cancel()
+
+	result, err := get(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			h.log.Info("Timeout collecting debug info", "path", req.Request.URL.Path, "timeout", h.timeout)
+			_ = resp.WriteErrorString(http.StatusServiceUnavailable, fmt.Sprintf("failed to collect debug info within %s: the server is busy or degraded", h.timeout))
+			return
+		}
+		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := resp.WriteEntity(result); err != nil {
+		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+}
+
+func (h *debugHandler) listenersList(req *restful.Request, resp *restful.Response) {
+	h.writeEntityWithTimeout(req, resp, func(ctx context.Context) (interface{}, error) {
+		return h.infoProvider.ListListenersWithContext(ctx)
+	})
+}
+
+func (h *debugHandler) certificateErrorsList(req *restful.Request, resp *restful.Response) {
+	h.writeEntityWithTimeout(req, resp, func(ctx context.Context) (interface{}, error) {
+		return h.infoProvider.ListCertificateErrorsWithContext(ctx)
+	})
+}
+
+func (h *debugHandler) listenerLimits(req *restful.Request, resp *restful.Response) {
+	h.writeEntityWithTimeout(req, resp, func(_ context.Context) (interface{}, error) {
+		return h.infoProvider.ListenerLimits(), nil
+	})
+}
+
+func (h *debugHandler) listenerDetails(req *restful.Request, resp *restful.Response) {
+	h.writeEntityWithTimeout(req, resp, func(ctx context.Context) (interface{}, error) {
+		return h.infoProvider.ListenerDetailsWithContext(ctx)
+	})
+}
+
+func (h *debugHandler) listenerRestart(req *restful.Request, resp *restful.Response) {
+	name := req.PathParameter("name")
+	address, err := h.infoProvider.RestartListener(req.Request.Context(), name)
+	if err != nil {
+		if errors.Is(err, ErrListenerNotFound) {
+			_ = resp.WriteErrorString(http.StatusNotFound, err.Error())
+			return
+		}
+		h.log.Error(err, "Failed to restart listener", "listenerName", name)
+		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
 
-	if err := resp.WriteEntity(listeners); err != nil {
+	if err := resp.WriteEntity(ListenerRestart{Address: address}); err != nil {
 		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// openAPIV2Document is the value returned by the /openapi/v2 call.
+// NOTE: The document does not contain any definition; it only allows clients fetching the OpenAPI schema
+// before doing anything else to proceed.
+var openAPIV2Document = map[string]interface{}{
+	"swagger": "2.0",
+	"info": map[string]interface{}{
+		"title":   "Kubernetes",
+		"version": "in-memory",
+	},
+	"paths":       map[string]interface{}{},
+	"definitions": map[string]interface{}{},
+}
+
+// discoveryAPIResourceLists returns the APIResourceLists for the supported resources whose kind
+// is registered in the scheme; group versions without resources are dropped.
+func discoveryAPIResourceLists(scheme *runtime.Scheme) []*metav1.APIResourceList {
+	ret := []*metav1.APIResourceList{}
+	for _, resourceList := range supportedAPIResourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		filtered := &metav1.APIResourceList{GroupVersion: resourceList.GroupVersion}
+		for _, r := range resourceList.APIResources {
+			if scheme.Recognizes(gv.WithKind(r.Kind)) {
+				filtered.APIResources = append(filtered.APIResources, r)
+			}
+		}
+		if len(filtered.APIResources) > 0 {
+			ret = append(ret, filtered)
+		}
+	}
+	return ret
+}
+
+// discoveryAPIResourceList returns the value returned by the /api/{version} or /apis/{group}/{version} discovery calls,
+// or nil if the group version is not served.
+func discoveryAPIResourceList(scheme *runtime.Scheme, group, version string) *metav1.APIResourceList {
+	groupVersion := schema.GroupVersion{Group: group, Version: version}.String()
+	for _, resourceList := range discoveryAPIResourceLists(scheme) {
+		if resourceList.GroupVersion == groupVersion {
+			return resourceList
+		}
+	}
+	return nil
+}
+
+// discoveryAPIVersions returns the value returned by the /api discovery call.
+func discoveryAPIVersions(scheme *runtime.Scheme) *metav1.APIVersions {
+	ret := &metav1.APIVersions{Versions: []string{}}
+	for _, resourceList := range discoveryAPIResourceLists(scheme) {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil || gv.Group != "" {
+			continue
+		}
+		ret.Versions = append(ret.Versions, gv.Version)
+	}
+	return ret
+}
+
+// discoveryAPIGroupList returns the value returned by the /apis discovery call.
+// NOTE: The first version of each group is used as preferred version.
+func discoveryAPIGroupList(scheme *runtime.Scheme) *metav1.APIGroupList {
+	ret := &metav1.APIGroupList{Groups: []metav1.APIGroup{}}
+	groupIndex := map[string]int{}
+	for _, resourceList := range discoveryAPIResourceLists(scheme) {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil || gv.Group == "" {
+			continue
+		}
+
+		version := metav1.GroupVersionForDiscovery{
+			GroupVersion: resourceList.GroupVersion,
+			Version:      gv.Version,
+		}
+		i, ok := groupIndex[gv.Group]
+		if !ok {
+			groupIndex[gv.Group] = len(ret.Groups)
+			ret.Groups = append(ret.Groups, metav1.APIGroup{
+				Name:             gv.Group,
+				PreferredVersion: version,
+			})
+			i = len(ret.Groups) - 1
+		}
+		ret.Groups[i].Versions = append(ret.Groups[i].Versions, version)
+	}
+	return ret
+}
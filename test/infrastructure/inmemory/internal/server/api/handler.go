@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emicklei/go-restful/v3"
@@ -35,6 +36,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -46,7 +48,9 @@ import (
 	"k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/apiserver/pkg/server"
 	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
 
 	cmanager "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/runtime/manager"
 	gportforward "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/server/api/portforward"
@@ -56,9 +60,37 @@ import (
 // request targets.
 type ResourceGroupResolver func(host string) (string, error)
 
+// DefaultWatchBookmarkInterval is the default interval for sending bookmark events on watches
+// requesting them with allowWatchBookmarks=true.
+const DefaultWatchBookmarkInterval = time.Minute
+
+// APIServerHandlerOptions are options for the fake API server handler.
+type APIServerHandlerOptions struct {
+	// WatchBookmarkInterval is the interval for sending bookmark events on watches, see WithWatchBookmarkInterval.
+	WatchBookmarkInterval time.Duration
+}
+
+// APIServerHandlerOption define an option for the fake API server handler creation.
+type APIServerHandlerOption func(*APIServerHandlerOptions)
+
+// WithWatchBookmarkInterval sets the interval for sending bookmark events, carrying the latest resource version,
+// on watches requesting them with allowWatchBookmarks=true; if zero or negative, bookmarks are never sent.
+func WithWatchBookmarkInterval(interval time.Duration) APIServerHandlerOption {
+	return func(options *APIServerHandlerOptions) {
+		options.WatchBookmarkInterval = interval
+	}
+}
+
 // NewAPIServerHandler returns an http.Handler for a fake API server.
-func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver ResourceGroupResolver) http.Handler {
-	apiServer := &apiServerHandler{
+func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver ResourceGroupResolver, opts ...APIServerHandlerOption) *APIServerHandler {
+	options := &APIServerHandlerOptions{
+		WatchBookmarkInterval: DefaultWatchBookmarkInterval,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	apiServer := &APIServerHandler{
 		container:             restful.NewContainer(),
 		manager:               manager,
 		log:                   log,
@@ -66,6 +98,8 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 		requestInfoResolver: server.NewRequestInfoResolver(&server.Config{
 			LegacyAPIGroupPrefixes: sets.NewString(server.DefaultLegacyAPIPrefix),
 		}),
+		watchBookmarkInterval: options.WatchBookmarkInterval,
+		mutators:              map[schema.GroupVersionKind][]func(obj runtime.Object){},
 	}
 
 	apiServer.container.Filter(apiServer.globalLogging)
@@ -81,7 +115,9 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 	ws.Route(ws.GET("/api").To(apiServer.apiDiscovery))
 	ws.Route(ws.GET("/api/v1").To(apiServer.apiV1Discovery))
 	ws.Route(ws.GET("/apis").To(apiServer.apisDiscovery))
+	ws.Route(ws.GET("/apis/{group}").To(apiServer.apisGroupDiscovery))
 	ws.Route(ws.GET("/apis/{group}/{version}").To(apiServer.apisDiscovery))
+	ws.Route(ws.GET("/openapi/v2").To(apiServer.openAPIV2))
 
 	// CRUD endpoints (global objects)
 	ws.Route(ws.POST("/api/v1/{resource}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Create))
@@ -89,7 +125,7 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 	ws.Route(ws.GET("/api/v1/{resource}").If(isWatch).To(apiServer.apiV1Watch))
 	ws.Route(ws.GET("/api/v1/{resource}/{name}").To(apiServer.apiV1Get))
 	ws.Route(ws.PUT("/api/v1/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Update))
-	ws.Route(ws.PATCH("/api/v1/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType)).To(apiServer.apiV1Patch))
+	ws.Route(ws.PATCH("/api/v1/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType), string(types.ApplyPatchType)).To(apiServer.apiV1Patch))
 	ws.Route(ws.DELETE("/api/v1/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf, runtime.ContentTypeJSON).To(apiServer.apiV1Delete))
 
 	ws.Route(ws.POST("/apis/{group}/{version}/{resource}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Create))
@@ -97,7 +133,7 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 	ws.Route(ws.GET("/apis/{group}/{version}/{resource}").If(isWatch).To(apiServer.apiV1Watch))
 	ws.Route(ws.GET("/apis/{group}/{version}/{resource}/{name}").To(apiServer.apiV1Get))
 	ws.Route(ws.PUT("/apis/{group}/{version}/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Update))
-	ws.Route(ws.PATCH("/apis/{group}/{version}/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType)).To(apiServer.apiV1Patch))
+	ws.Route(ws.PATCH("/apis/{group}/{version}/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType), string(types.ApplyPatchType)).To(apiServer.apiV1Patch))
 	ws.Route(ws.DELETE("/apis/{group}/{version}/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf, runtime.ContentTypeJSON).To(apiServer.apiV1Delete))
 
 	// CRUD endpoints (namespaced objects)
@@ -106,7 +142,7 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 	ws.Route(ws.GET("/api/v1/namespaces/{namespace}/{resource}").If(isWatch).To(apiServer.apiV1Watch))
 	ws.Route(ws.GET("/api/v1/namespaces/{namespace}/{resource}/{name}").To(apiServer.apiV1Get))
 	ws.Route(ws.PUT("/api/v1/namespaces/{namespace}/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Update))
-	ws.Route(ws.PATCH("/api/v1/namespaces/{namespace}/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType)).To(apiServer.apiV1Patch))
+	ws.Route(ws.PATCH("/api/v1/namespaces/{namespace}/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType), string(types.ApplyPatchType)).To(apiServer.apiV1Patch))
 	ws.Route(ws.DELETE("/api/v1/namespaces/{namespace}/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf, runtime.ContentTypeJSON).To(apiServer.apiV1Delete))
 
 	ws.Route(ws.POST("/apis/{group}/{version}/namespaces/{namespace}/{resource}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Create))
@@ -114,7 +150,7 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 	ws.Route(ws.GET("/apis/{group}/{version}/namespaces/{namespace}/{resource}").If(isWatch).To(apiServer.apiV1Watch))
 	ws.Route(ws.GET("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}").To(apiServer.apiV1Get))
 	ws.Route(ws.PUT("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf).To(apiServer.apiV1Update))
-	ws.Route(ws.PATCH("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType)).To(apiServer.apiV1Patch))
+	ws.Route(ws.PATCH("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}").Consumes(string(types.MergePatchType), string(types.StrategicMergePatchType), string(types.ApplyPatchType)).To(apiServer.apiV1Patch))
 	ws.Route(ws.DELETE("/apis/{group}/{version}/namespaces/{namespace}/{resource}/{name}").Consumes(runtime.ContentTypeProtobuf, runtime.ContentTypeJSON).To(apiServer.apiV1Delete))
 
 	// Port forward endpoints
@@ -126,19 +162,75 @@ func NewAPIServerHandler(manager cmanager.Manager, log logr.Logger, resolver Res
 	return apiServer
 }
 
-type apiServerHandler struct {
+// APIServerHandler is an http.Handler for a fake API server.
+type APIServerHandler struct {
 	container             *restful.Container
 	manager               cmanager.Manager
 	log                   logr.Logger
 	resourceGroupResolver ResourceGroupResolver
 	requestInfoResolver   *request.RequestInfoFactory
+	watchBookmarkInterval time.Duration
+
+	mutatorsLock sync.RWMutex
+	mutators     map[schema.GroupVersionKind][]func(obj runtime.Object)
+}
+
+// RegisterMutator registers a func called on objects of the given kind when they are created, updated or patched,
+// before they are stored, e.g. to simulate defaulting or mutating webhooks of a real API server; mutators for the same
+// kind are called in the order they are registered.
+// NOTE: On patches, including server-side apply creating an object, mutators are called on the patched object.
+func (h *APIServerHandler) RegisterMutator(gvk schema.GroupVersionKind, fn func(obj runtime.Object)) {
+	h.mutatorsLock.Lock()
+	defer h.mutatorsLock.Unlock()
+
+	h.mutators[gvk] = append(h.mutators[gvk], fn)
 }
 
-func (h *apiServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// mutate calls the mutators registered for the given kind on an object.
+func (h *APIServerHandler) mutate(gvk schema.GroupVersionKind, obj runtime.Object) {
+	h.mutatorsLock.RLock()
+	mutators := h.mutators[gvk]
+	h.mutatorsLock.RUnlock()
+
+	for _, fn := range mutators {
+		fn(obj)
+	}
+}
+
+// mutateUnstructured calls the mutators registered for the given kind on an unstructured object, by converting
+// it to the corresponding typed object, which is what mutators expect.
+func (h *APIServerHandler) mutateUnstructured(gvk schema.GroupVersionKind, obj *unstructured.Unstructured) error {
+	h.mutatorsLock.RLock()
+	hasMutators := len(h.mutators[gvk]) > 0
+	h.mutatorsLock.RUnlock()
+	if !hasMutators {
+		return nil
+	}
+
+	typedObj, err := h.manager.GetScheme().New(gvk)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typedObj); err != nil {
+		return apierrors.NewInternalError(err)
+	}
+
+	h.mutate(gvk, typedObj)
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typedObj)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	obj.SetUnstructuredContent(content)
+	obj.SetGroupVersionKind(gvk)
+	return nil
+}
+
+func (h *APIServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.container.ServeHTTP(w, r)
 }
 
-func (h *apiServerHandler) globalLogging(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+func (h *APIServerHandler) globalLogging(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
 	h.log.V(4).Info("Serving", "method", req.Request.Method, "url", req.Request.URL, "contentType", req.HeaderParameter("Content-Type"))
 
 	start := time.Now()
@@ -161,7 +253,7 @@ func (h *apiServerHandler) globalLogging(req *restful.Request, resp *restful.Res
 		requestLatencyLabelValues := baseLabelValues
 
 		// Additional CAPIM specific label values.
-		wclName, _ := h.resourceGroupResolver(req.Request.Host)
+		wclName, _ := h.resourceGroupResolver(requestHostPort(req.Request))
 		userAgent := req.Request.Header.Get("User-Agent")
 		requestTotalLabelValues = append(requestTotalLabelValues, req.Request.Method, req.Request.Host, req.SelectedRoutePath(), wclName, userAgent)
 		requestLatencyLabelValues = append(requestLatencyLabelValues, req.Request.Method, req.Request.Host, req.SelectedRoutePath(), wclName, userAgent)
@@ -191,54 +283,73 @@ func cleanDryRun(u *url.URL) string {
 	return strings.Join(sets.NewString(dryRun...).List(), ",")
 }
 
-func (h *apiServerHandler) apiDiscovery(_ *restful.Request, resp *restful.Response) {
-	if err := resp.WriteEntity(apiVersions); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+func (h *APIServerHandler) apiDiscovery(_ *restful.Request, resp *restful.Response) {
+	if err := resp.WriteEntity(discoveryAPIVersions(h.manager.GetScheme())); err != nil {
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Discovery(_ *restful.Request, resp *restful.Response) {
-	if err := resp.WriteEntity(corev1APIResourceList); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+func (h *APIServerHandler) apiV1Discovery(_ *restful.Request, resp *restful.Response) {
+	resourceList := discoveryAPIResourceList(h.manager.GetScheme(), "", "v1")
+	if resourceList == nil {
+		writeStatusError(resp, newNotFoundError("discovery info not defined for v1"))
+		return
+	}
+	if err := resp.WriteEntity(resourceList); err != nil {
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apisDiscovery(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apisDiscovery(req *restful.Request, resp *restful.Response) {
 	if req.PathParameter("group") != "" {
-		if req.PathParameter("group") == "rbac.authorization.k8s.io" && req.PathParameter("version") == "v1" {
-			if err := resp.WriteEntity(rbacv1APIResourceList); err != nil {
-				_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
-				return
-			}
+		resourceList := discoveryAPIResourceList(h.manager.GetScheme(), req.PathParameter("group"), req.PathParameter("version"))
+		if resourceList == nil {
+			writeStatusError(resp, newNotFoundError(fmt.Sprintf("discovery info not defined for %s/%s", req.PathParameter("group"), req.PathParameter("version"))))
 			return
 		}
-		if req.PathParameter("group") == "apps" && req.PathParameter("version") == "v1" {
-			if err := resp.WriteEntity(appsV1ResourceList); err != nil {
-				_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
-				return
-			}
+		if err := resp.WriteEntity(resourceList); err != nil {
+			writeStatusError(resp, err)
 			return
 		}
+		return
+	}
 
-		_ = resp.WriteErrorString(http.StatusInternalServerError, fmt.Sprintf("discovery info not defined for %s/%s", req.PathParameter("group"), req.PathParameter("version")))
+	if err := resp.WriteEntity(discoveryAPIGroupList(h.manager.GetScheme())); err != nil {
+		writeStatusError(resp, err)
 		return
 	}
+}
+
+func (h *APIServerHandler) apisGroupDiscovery(req *restful.Request, resp *restful.Response) {
+	for _, group := range discoveryAPIGroupList(h.manager.GetScheme()).Groups {
+		if group.Name != req.PathParameter("group") {
+			continue
+		}
+		if err := resp.WriteEntity(group); err != nil {
+			writeStatusError(resp, err)
+			return
+		}
+		return
+	}
+	writeStatusError(resp, newNotFoundError(fmt.Sprintf("discovery info not defined for %s", req.PathParameter("group"))))
+}
 
-	if err := resp.WriteEntity(apiGroupList); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+func (h *APIServerHandler) openAPIV2(_ *restful.Request, resp *restful.Response) {
+	if err := resp.WriteEntity(openAPIV2Document); err != nil {
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Create(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1Create(req *restful.Request, resp *restful.Response) {
 	ctx := req.Request.Context()
 
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -248,7 +359,7 @@ func (h *apiServerHandler) apiV1Create(req *restful.Request, resp *restful.Respo
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -259,13 +370,13 @@ func (h *apiServerHandler) apiV1Create(req *restful.Request, resp *restful.Respo
 
 	newObj, err := h.manager.GetScheme().New(*gvk)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
 	codecFactory := serializer.NewCodecFactory(h.manager.GetScheme())
 	if err := runtime.DecodeInto(codecFactory.UniversalDecoder(), objData, newObj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, apierrors.NewBadRequest(err.Error()))
 		return
 	}
 
@@ -273,23 +384,24 @@ func (h *apiServerHandler) apiV1Create(req *restful.Request, resp *restful.Respo
 	obj := newObj.(client.Object)
 	// TODO: consider check vs enforce for namespace on the object - namespace on the request path
 	obj.SetNamespace(req.PathParameter("namespace"))
+	h.mutate(*gvk, obj)
 	if err := cloudClient.Create(ctx, obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 	if err := resp.WriteEntity(obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1List(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1List(req *restful.Request, resp *restful.Response) {
 	ctx := req.Request.Context()
 
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -299,7 +411,7 @@ func (h *apiServerHandler) apiV1List(req *restful.Request, resp *restful.Respons
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -313,56 +425,79 @@ func (h *apiServerHandler) apiV1List(req *restful.Request, resp *restful.Respons
 		listOpts = append(listOpts, client.InNamespace(req.PathParameter("namespace")))
 	}
 
-	// TODO: The only field selector which works is for `spec.nodeName` on pods.
-	selector, err := fields.ParseSelector(req.QueryParameter("fieldSelector"))
+	// NOTE: The only fields supported in field selectors are `metadata.name`, `metadata.namespace` and `spec.nodeName` on pods.
+	labelSelector, fieldSelector, err := requestSelectors(req)
+	if err != nil {
+		writeStatusError(resp, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	// NOTE: Pages after the first one are listed at the resource version of the first page, so clients get
+	// a ResourceExpired error if the continue token is too old.
+	limit, token, err := requestPagination(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, apierrors.NewBadRequest(err.Error()))
 		return
 	}
-	if selector != nil {
-		listOpts = append(listOpts, client.MatchingFieldsSelector{Selector: selector})
+	resourceVersion := req.QueryParameter("resourceVersion")
+	if token != nil {
+		resourceVersion = token.ResourceVersion
 	}
+	listOpts = append(listOpts,
+		client.MatchingLabelsSelector{Selector: labelSelector},
+		client.MatchingFieldsSelector{Selector: fieldSelector},
+		&client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: resourceVersion}},
+	)
 
 	if err := cloudClient.List(ctx, list, listOpts...); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
+		return
+	}
+	if err := paginate(list, limit, token); err != nil {
+		writeStatusError(resp, err)
+		return
+	}
+	if isTableRequest(req) {
+		if err := resp.WriteEntity(newTable(req, metav1.ListMeta{ResourceVersion: list.GetResourceVersion(), Continue: list.GetContinue(), RemainingItemCount: list.GetRemainingItemCount()}, list.Items...)); err != nil {
+			writeStatusError(resp, err)
+		}
 		return
 	}
 	if err := resp.WriteEntity(list); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Watch(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1Watch(req *restful.Request, resp *restful.Response) {
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
 	// If the request is a Watch handle it using watchForResource.
 	err = h.watchForResource(req, resp, resourceGroup, *gvk)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Get(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1Get(req *restful.Request, resp *restful.Response) {
 	ctx := req.Request.Context()
 
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -372,7 +507,7 @@ func (h *apiServerHandler) apiV1Get(req *restful.Request, resp *restful.Response
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -384,26 +519,28 @@ func (h *apiServerHandler) apiV1Get(req *restful.Request, resp *restful.Response
 	obj.SetNamespace(req.PathParameter("namespace"))
 
 	if err := cloudClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
-		if status, ok := err.(apierrors.APIStatus); ok || errors.As(err, &status) {
-			_ = resp.WriteHeaderAndEntity(int(status.Status().Code), status)
-			return
+		writeStatusError(resp, err)
+		return
+	}
+	if isTableRequest(req) {
+		if err := resp.WriteEntity(newTable(req, metav1.ListMeta{ResourceVersion: obj.GetResourceVersion()}, *obj)); err != nil {
+			writeStatusError(resp, err)
 		}
-		_ = resp.WriteHeaderAndEntity(http.StatusInternalServerError, err.Error())
 		return
 	}
 	if err := resp.WriteEntity(obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Update(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1Update(req *restful.Request, resp *restful.Response) {
 	ctx := req.Request.Context()
 
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -413,7 +550,7 @@ func (h *apiServerHandler) apiV1Update(req *restful.Request, resp *restful.Respo
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -424,13 +561,13 @@ func (h *apiServerHandler) apiV1Update(req *restful.Request, resp *restful.Respo
 
 	newObj, err := h.manager.GetScheme().New(*gvk)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
 	codecFactory := serializer.NewCodecFactory(h.manager.GetScheme())
 	if err := runtime.DecodeInto(codecFactory.UniversalDecoder(), objData, newObj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, apierrors.NewBadRequest(err.Error()))
 		return
 	}
 
@@ -438,23 +575,39 @@ func (h *apiServerHandler) apiV1Update(req *restful.Request, resp *restful.Respo
 	obj := newObj.(client.Object)
 	// TODO: consider check vs enforce for namespace on the object - namespace on the request path
 	obj.SetNamespace(req.PathParameter("namespace"))
-	if err := cloudClient.Update(ctx, obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+	h.mutate(*gvk, obj)
+
+	// NOTE: As in a real API server, updates with a resourceVersion not matching the stored object fail with a Conflict,
+	// while updates without a resourceVersion are unconditional, and they are retried if the object changes concurrently.
+	unconditional := obj.GetResourceVersion() == ""
+	err = retry.OnError(retry.DefaultRetry, func(err error) bool { return unconditional && apierrors.IsConflict(err) }, func() error {
+		if unconditional {
+			current := &unstructured.Unstructured{}
+			current.SetGroupVersionKind(*gvk)
+			if err := cloudClient.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+				return err
+			}
+			obj.SetResourceVersion(current.GetResourceVersion())
+		}
+		return cloudClient.Update(ctx, obj)
+	})
+	if err != nil {
+		writeStatusError(resp, err)
 		return
 	}
 	if err := resp.WriteEntity(obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Patch(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1Patch(req *restful.Request, resp *restful.Response) {
 	ctx := req.Request.Context()
 
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -464,7 +617,7 @@ func (h *apiServerHandler) apiV1Patch(req *restful.Request, resp *restful.Respon
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -475,35 +628,72 @@ func (h *apiServerHandler) apiV1Patch(req *restful.Request, resp *restful.Respon
 	patchType := types.PatchType(req.HeaderParameter("Content-Type"))
 	patch := client.RawPatch(patchType, patchData)
 
-	// Apply the Patch.
-	obj := &unstructured.Unstructured{}
-	// TODO: consider check vs enforce for gvk on the object - gvk on the request path (same for name/namespace)
-	obj.SetAPIVersion(gvk.GroupVersion().String())
-	obj.SetKind(gvk.Kind)
-	obj.SetName(req.PathParameter("name"))
-	obj.SetNamespace(req.PathParameter("namespace"))
+	patchOpts := []client.PatchOption{}
+	if fieldManager := req.QueryParameter("fieldManager"); fieldManager != "" {
+		patchOpts = append(patchOpts, client.FieldOwner(fieldManager))
+	}
+	if req.QueryParameter("force") == "true" {
+		patchOpts = append(patchOpts, client.ForceOwnership)
+	}
 
-	if err := cloudClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+	// NOTE: As in a real API server, a resourceVersion in the patch is a precondition, and patches with a resourceVersion
+	// not matching the stored object fail with a Conflict; patches without a resourceVersion are retried if the object
+	// changes concurrently.
+	precondition, err := patchResourceVersion(patchData)
+	if err != nil {
+		writeStatusError(resp, apierrors.NewBadRequest(err.Error()))
 		return
 	}
-	if err := cloudClient.Patch(ctx, obj, patch); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+
+	// Apply the Patch.
+	var obj *unstructured.Unstructured
+	err = retry.OnError(retry.DefaultRetry, func(err error) bool { return precondition == "" && apierrors.IsConflict(err) }, func() error {
+		obj = &unstructured.Unstructured{}
+		// TODO: consider check vs enforce for gvk on the object - gvk on the request path (same for name/namespace)
+		obj.SetAPIVersion(gvk.GroupVersion().String())
+		obj.SetKind(gvk.Kind)
+		obj.SetName(req.PathParameter("name"))
+		obj.SetNamespace(req.PathParameter("namespace"))
+
+		// NOTE: Server-side apply creates the object if it does not exist yet.
+		if err := cloudClient.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil && !(apierrors.IsNotFound(err) && patchType == types.ApplyPatchType) {
+			return err
+		}
+		exists := obj.GetResourceVersion() != ""
+		if precondition != "" && obj.GetResourceVersion() != "" && precondition != obj.GetResourceVersion() {
+			return apierrors.NewConflict(schema.GroupResource{Group: gvk.Group, Resource: req.PathParameter("resource")}, obj.GetName(), errors.New(optimisticLockErrorMsg))
+		}
+
+		// NOTE: The patch is applied without storing the result, so mutators are called on the patched object before
+		// it is stored; objects without a resourceVersion do not exist yet and are created by server-side apply.
+		if err := cloudClient.Patch(ctx, obj, patch, append(patchOpts, client.DryRunAll)...); err != nil {
+			return err
+		}
+		if err := h.mutateUnstructured(*gvk, obj); err != nil {
+			return err
+		}
+		if !exists {
+			return cloudClient.Create(ctx, obj)
+		}
+		return cloudClient.Update(ctx, obj)
+	})
+	if err != nil {
+		writeStatusError(resp, err)
 		return
 	}
 	if err := resp.WriteEntity(obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1Delete(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1Delete(req *restful.Request, resp *restful.Response) {
 	ctx := req.Request.Context()
 
 	// Gets the resource group the request targets (the resolver is aware of the mapping host<->resourceGroup)
-	resourceGroup, err := h.resourceGroupResolver(req.Request.Host)
+	resourceGroup, err := h.resourceGroupResolver(requestHostPort(req.Request))
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -513,7 +703,7 @@ func (h *apiServerHandler) apiV1Delete(req *restful.Request, resp *restful.Respo
 	// Maps the requested resource to a gvk.
 	gvk, err := requestToGVK(req)
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -525,12 +715,12 @@ func (h *apiServerHandler) apiV1Delete(req *restful.Request, resp *restful.Respo
 	obj.SetNamespace(req.PathParameter("namespace"))
 
 	if err := cloudClient.Delete(ctx, obj); err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 }
 
-func (h *apiServerHandler) apiV1PortForward(req *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) apiV1PortForward(req *restful.Request, resp *restful.Response) {
 	// In order to handle a port forward request the current connection has to be upgraded
 	// to become compliant with the SPDY protocol.
 	// This implies two steps:
@@ -548,7 +738,7 @@ func (h *apiServerHandler) apiV1PortForward(req *restful.Request, resp *restful.
 	respWriter := resp.ResponseWriter
 	_, err := httpstream.Handshake(request, respWriter, []string{portforward.PortForwardProtocolV1Name})
 	if err != nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		writeStatusError(resp, err)
 		return
 	}
 
@@ -561,7 +751,7 @@ func (h *apiServerHandler) apiV1PortForward(req *restful.Request, resp *restful.
 	upgrader := spdy.NewResponseUpgrader()
 	conn := upgrader.UpgradeResponse(respWriter, request, gportforward.HTTPStreamReceived(streamChan))
 	if conn == nil {
-		_ = resp.WriteErrorString(http.StatusInternalServerError, "failed to get upgraded connection")
+		writeStatusError(resp, errors.New("failed to get upgraded connection"))
 		return
 	}
 	defer func() {
@@ -580,7 +770,11 @@ func (h *apiServerHandler) apiV1PortForward(req *restful.Request, resp *restful.
 		func(ctx context.Context, podName, podNamespace, _ string, stream io.ReadWriteCloser) error {
 			// Given that in the in-memory provider there is no real infrastructure, and thus no real workload cluster,
 			// we are going to forward all the connection back to the same server (the CAPIM controller pod).
-			return h.doPortForward(ctx, req.Request.Host, stream)
+			network, address := "tcp", req.Request.Host
+			if localAddr := requestLocalAddr(req.Request); localAddr != nil {
+				network, address = localAddr.Network(), localAddr.String()
+			}
+			return h.doPortForward(ctx, network, address, stream)
 		},
 	)
 
@@ -591,9 +785,9 @@ func (h *apiServerHandler) apiV1PortForward(req *restful.Request, resp *restful.
 // doPortForward establish a connection to the target of the port forward operation,  and sets up
 // a bidirectional copy of data.
 // In the case of this provider, the target endpoint is always on the same server (the CAPIM controller pod).
-func (h *apiServerHandler) doPortForward(ctx context.Context, address string, stream io.ReadWriteCloser) error {
+func (h *APIServerHandler) doPortForward(ctx context.Context, network, address string, stream io.ReadWriteCloser) error {
 	// Get a connection to the target of the port forward operation.
-	dial, err := net.Dial("tcp", address)
+	dial, err := net.Dial(network, address)
 	if err != nil {
 		return fmt.Errorf("failed to dial %q: %w", address, err)
 	}
@@ -606,14 +800,14 @@ func (h *apiServerHandler) doPortForward(ctx context.Context, address string, st
 	return gportforward.HTTPStreamTunnel(ctx, stream, dial)
 }
 
-func (h *apiServerHandler) healthz(_ *restful.Request, resp *restful.Response) {
+func (h *APIServerHandler) healthz(_ *restful.Request, resp *restful.Response) {
 	resp.WriteHeader(http.StatusOK)
 }
 
 func requestToGVK(req *restful.Request) (*schema.GroupVersionKind, error) {
 	resourceList := getAPIResourceList(req)
 	if resourceList == nil {
-		return nil, errors.Errorf("no APIResourceList defined for %s", req.PathParameters())
+		return nil, newNotFoundError(fmt.Sprintf("no APIResourceList defined for %s", req.PathParameters()))
 	}
 	gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
 	if err != nil {
@@ -627,7 +821,7 @@ func requestToGVK(req *restful.Request) (*schema.GroupVersionKind, error) {
 			return &gvk, nil
 		}
 	}
-	return nil, errors.Errorf("Resource %s is not defined in the APIResourceList for %s", resource, req.PathParameters())
+	return nil, newNotFoundError(fmt.Sprintf("Resource %s is not defined in the APIResourceList for %s", resource, req.PathParameters()))
 }
 
 func getAPIResourceList(req *restful.Request) *metav1.APIResourceList {
@@ -643,6 +837,75 @@ func getAPIResourceList(req *restful.Request) *metav1.APIResourceList {
 	return corev1APIResourceList
 }
 
+// writeStatusError writes an error as a Status object with the matching HTTP status code, so clients can detect
+// the reason of the error, e.g. with apierrors.IsNotFound; errors which are not API errors are written as
+// InternalError.
+func writeStatusError(resp *restful.Response, err error) {
+	var apiStatus apierrors.APIStatus
+	if !errors.As(err, &apiStatus) {
+		apiStatus = apierrors.NewInternalError(err)
+	}
+	status := apiStatus.Status()
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	if status.Code == 0 {
+		status.Code = http.StatusInternalServerError
+	}
+	_ = resp.WriteHeaderAndEntity(int(status.Code), status)
+}
+
+// optimisticLockErrorMsg is the message of Conflict errors for stale resourceVersions, as in a real API server.
+const optimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
+
+// patchResourceVersion returns the resourceVersion in a JSON or YAML patch, if any.
+func patchResourceVersion(patchData []byte) (string, error) {
+	patch := map[string]interface{}{}
+	if err := yaml.Unmarshal(patchData, &patch); err != nil {
+		return "", errors.Wrap(err, "failed to parse patch")
+	}
+	// NOTE: Errors are ignored, because patches with an invalid resourceVersion fail when applied.
+	resourceVersion, _, _ := unstructured.NestedString(patch, "metadata", "resourceVersion")
+	return resourceVersion, nil
+}
+
+// newNotFoundError returns a NotFound error for requests which can't be mapped to a resource, as a real API server does.
+func newNotFoundError(message string) error {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusNotFound,
+		Reason:  metav1.StatusReasonNotFound,
+		Message: message,
+	}}
+}
+
+// requestSelectors returns the label and field selectors from the labelSelector and fieldSelector query parameters.
+func requestSelectors(req *restful.Request) (labels.Selector, fields.Selector, error) {
+	labelSelector, err := labels.Parse(req.QueryParameter("labelSelector"))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid labelSelector %q", req.QueryParameter("labelSelector"))
+	}
+	fieldSelector, err := fields.ParseSelector(req.QueryParameter("fieldSelector"))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid fieldSelector %q", req.QueryParameter("fieldSelector"))
+	}
+	return labelSelector, fieldSelector, nil
+}
+
+// requestLocalAddr returns the local address a request has been received on, if available.
+func requestLocalAddr(req *http.Request) net.Addr {
+	localAddr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return localAddr
+}
+
+// requestHostPort returns the local address a request has been received on, which identifies the workload cluster
+// (also when the request is sent to a Unix domain socket); if not available, the Host header is used.
+func requestHostPort(req *http.Request) string {
+	if localAddr := requestLocalAddr(req); localAddr != nil {
+		return localAddr.String()
+	}
+	return req.Host
+}
+
 // isWatch is true if the request contains `watch="true"` as a query parameter.
 func isWatch(req *http.Request) bool {
 	return req.URL.Query().Get("watch") == "true"
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// continueToken is the token returned in the continue field of a paginated list, and then passed by clients
+// to get the next page.
+type continueToken struct {
+	// ResourceVersion is the resource version of the first page; all the pages of a list have the same resource version.
+	ResourceVersion string `json:"rv"`
+	// Start is the key of the last object returned, so the next page starts from the object after it.
+	Start string `json:"start"`
+}
+
+// requestPagination returns the limit and the continue token of a list request, if any.
+func requestPagination(req *restful.Request) (int64, *continueToken, error) {
+	var limit int64
+	if l := req.QueryParameter("limit"); l != "" {
+		var err error
+		limit, err = strconv.ParseInt(l, 10, 64)
+		if err != nil {
+			return 0, nil, errors.Errorf("invalid limit %q", l)
+		}
+	}
+
+	c := req.QueryParameter("continue")
+	if c == "" {
+		return limit, nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return 0, nil, errors.New("invalid continue token")
+	}
+	token := &continueToken{}
+	if err := json.Unmarshal(data, token); err != nil || token.ResourceVersion == "" || token.Start == "" {
+		return 0, nil, errors.New("invalid continue token")
+	}
+	return limit, token, nil
+}
+
+// paginate sorts the items of a list by namespace and name, so the order is stable across pages, and then drops
+// the items before the continue token and after the limit; if there are items after the limit, the continue token
+// for the next page is set in the list.
+// NOTE: As the cache serves lists from the current state, objects created or deleted between pages are included
+// in, or excluded from, the next pages; the resource version of the first page is returned for all the pages,
+// so a watch started at the end of the list gets those changes again.
+func paginate(list *unstructured.UnstructuredList, limit int64, token *continueToken) error {
+	key := func(i int) string {
+		return types.NamespacedName{Namespace: list.Items[i].GetNamespace(), Name: list.Items[i].GetName()}.String()
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return key(i) < key(j) })
+
+	if token != nil {
+		start := sort.Search(len(list.Items), func(i int) bool { return key(i) > token.Start })
+		list.Items = list.Items[start:]
+		list.SetResourceVersion(token.ResourceVersion)
+	}
+
+	if limit <= 0 || int64(len(list.Items)) <= limit {
+		return nil
+	}
+	remaining := int64(len(list.Items)) - limit
+	list.Items = list.Items[:limit]
+
+	data, err := json.Marshal(&continueToken{ResourceVersion: list.GetResourceVersion(), Start: key(len(list.Items) - 1)})
+	if err != nil {
+		return err
+	}
+	list.SetContinue(base64.RawURLEncoding.EncodeToString(data))
+	list.SetRemainingItemCount(&remaining)
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// tableColumnDefinitions are the columns of the tables returned by the API server; as the in memory provider
+// does not know how to print each kind, only the name and the age of the objects are returned.
+var tableColumnDefinitions = []metav1.TableColumnDefinition{
+	{Name: "Name", Type: "string", Format: "name", Description: metav1.ObjectMeta{}.SwaggerDoc()["name"]},
+	{Name: "Age", Type: "string", Description: metav1.ObjectMeta{}.SwaggerDoc()["creationTimestamp"]},
+}
+
+// isTableRequest returns true if the request accepts a meta.k8s.io/v1 Table response, e.g. for kubectl get.
+func isTableRequest(req *restful.Request) bool {
+	for _, accept := range strings.Split(req.HeaderParameter("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" && params["as"] == "Table" && params["g"] == metav1.GroupName && params["v"] == "v1" {
+			return true
+		}
+	}
+	return false
+}
+
+// newTable returns a meta.k8s.io/v1 Table with a row for each object.
+// NOTE: As in a real API server, each row includes the object metadata, the full object or nothing depending
+// on the includeObject query parameter.
+func newTable(req *restful.Request, listMeta metav1.ListMeta, objs ...unstructured.Unstructured) *metav1.Table {
+	table := &metav1.Table{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: metav1.SchemeGroupVersion.String(),
+			Kind:       "Table",
+		},
+		ListMeta:          listMeta,
+		ColumnDefinitions: tableColumnDefinitions,
+		Rows:              []metav1.TableRow{},
+	}
+
+	includeObject := metav1.IncludeObjectPolicy(req.QueryParameter("includeObject"))
+	for i := range objs {
+		obj := &objs[i]
+		row := metav1.TableRow{
+			Cells: []interface{}{obj.GetName(), translateTimestampSince(obj.GetCreationTimestamp())},
+		}
+		switch includeObject {
+		case metav1.IncludeNone:
+		case metav1.IncludeObject:
+			row.Object = runtime.RawExtension{Object: obj}
+		default:
+			row.Object = runtime.RawExtension{Object: &metav1.PartialObjectMetadata{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: metav1.SchemeGroupVersion.String(),
+					Kind:       "PartialObjectMetadata",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              obj.GetName(),
+					Namespace:         obj.GetNamespace(),
+					UID:               obj.GetUID(),
+					ResourceVersion:   obj.GetResourceVersion(),
+					Generation:        obj.GetGeneration(),
+					CreationTimestamp: obj.GetCreationTimestamp(),
+					DeletionTimestamp: obj.GetDeletionTimestamp(),
+					Labels:            obj.GetLabels(),
+					Annotations:       obj.GetAnnotations(),
+					OwnerReferences:   obj.GetOwnerReferences(),
+					Finalizers:        obj.GetFinalizers(),
+				},
+			}}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// translateTimestampSince returns the elapsed time since timestamp in human-readable approximation, as kubectl does.
+func translateTimestampSince(timestamp metav1.Time) string {
+	if timestamp.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(timestamp.Time))
+}
@@ -24,10 +24,16 @@ import (
 
 	"github.com/emicklei/go-restful/v3"
 	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ccache "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/runtime/cache"
 )
 
 // Event records a lifecycle event for a Kubernetes object.
@@ -39,12 +45,40 @@ type Event struct {
 // WatchEventDispatcher dispatches events for a single resourceGroup.
 type WatchEventDispatcher struct {
 	resourceGroup string
+	// namespace is the namespace of the watched objects; if empty, objects in all namespaces are watched.
+	namespace string
+	// labelSelector and fieldSelector select the watched objects.
+	labelSelector labels.Selector
+	fieldSelector fields.Selector
 	events        chan *Event
+	// history are the events happened after the resource version the watch starts from, which are sent before
+	// the events in the events channel; events in the channel already included in history are skipped.
+	history []*Event
+	// bookmarkInterval is the interval for sending bookmark events; if zero, bookmarks are not sent.
+	bookmarkInterval time.Duration
+	// gvk is the kind of the watched objects, used for bookmark events.
+	gvk schema.GroupVersionKind
+	// resourceVersion returns the latest resource version of the resource group, used for bookmark events.
+	resourceVersion func() (string, error)
+}
+
+// matches returns true if events for an object should be dispatched.
+func (m *WatchEventDispatcher) matches(resourceGroup string, o client.Object) bool {
+	if resourceGroup != m.resourceGroup {
+		return false
+	}
+	if m.namespace != "" && o.GetNamespace() != m.namespace {
+		return false
+	}
+	if m.labelSelector != nil && !m.labelSelector.Matches(labels.Set(o.GetLabels())) {
+		return false
+	}
+	return m.fieldSelector == nil || m.fieldSelector.Matches(ccache.ObjectFields(o))
 }
 
 // OnCreate dispatches Create events.
 func (m *WatchEventDispatcher) OnCreate(resourceGroup string, o client.Object) {
-	if resourceGroup != m.resourceGroup {
+	if !m.matches(resourceGroup, o) {
 		return
 	}
 	m.events <- &Event{
@@ -55,7 +89,7 @@ func (m *WatchEventDispatcher) OnCreate(resourceGroup string, o client.Object) {
 
 // OnUpdate dispatches Update events.
 func (m *WatchEventDispatcher) OnUpdate(resourceGroup string, _, o client.Object) {
-	if resourceGroup != m.resourceGroup {
+	if !m.matches(resourceGroup, o) {
 		return
 	}
 	m.events <- &Event{
@@ -66,7 +100,7 @@ func (m *WatchEventDispatcher) OnUpdate(resourceGroup string, _, o client.Object
 
 // OnDelete dispatches Delete events.
 func (m *WatchEventDispatcher) OnDelete(resourceGroup string, o client.Object) {
-	if resourceGroup != m.resourceGroup {
+	if !m.matches(resourceGroup, o) {
 		return
 	}
 	m.events <- &Event{
@@ -77,7 +111,7 @@ func (m *WatchEventDispatcher) OnDelete(resourceGroup string, o client.Object) {
 
 // OnGeneric dispatches Generic events.
 func (m *WatchEventDispatcher) OnGeneric(resourceGroup string, o client.Object) {
-	if resourceGroup != m.resourceGroup {
+	if !m.matches(resourceGroup, o) {
 		return
 	}
 	m.events <- &Event{
@@ -86,7 +120,7 @@ func (m *WatchEventDispatcher) OnGeneric(resourceGroup string, o client.Object)
 	}
 }
 
-func (h *apiServerHandler) watchForResource(req *restful.Request, resp *restful.Response, resourceGroup string, gvk schema.GroupVersionKind) (reterr error) {
+func (h *APIServerHandler) watchForResource(req *restful.Request, resp *restful.Response, resourceGroup string, gvk schema.GroupVersionKind) (reterr error) {
 	ctx := req.Request.Context()
 	queryTimeout := req.QueryParameter("timeoutSeconds")
 	c := h.manager.GetCache()
@@ -94,6 +128,10 @@ func (h *apiServerHandler) watchForResource(req *restful.Request, resp *restful.
 	if err != nil {
 		return err
 	}
+	labelSelector, fieldSelector, err := requestSelectors(req)
+	if err != nil {
+		return err
+	}
 	h.log.Info(fmt.Sprintf("Serving Watch for %v", req.Request.URL))
 	// With an unbuffered event channel RemoveEventHandler could be blocked because it requires a lock on the informer.
 	// When Run stops reading from the channel the informer could be blocked with an unbuffered chanel and then RemoveEventHandler never goes through.
@@ -101,7 +139,18 @@ func (h *apiServerHandler) watchForResource(req *restful.Request, resp *restful.
 	events := make(chan *Event, 1000)
 	watcher := &WatchEventDispatcher{
 		resourceGroup: resourceGroup,
+		namespace:     req.PathParameter("namespace"),
+		labelSelector: labelSelector,
+		fieldSelector: fieldSelector,
 		events:        events,
+		gvk:           gvk,
+		resourceVersion: func() (string, error) {
+			return c.ResourceVersion(resourceGroup)
+		},
+	}
+	// NOTE: As in a real API server, bookmarks are sent only to clients requesting them.
+	if req.QueryParameter("allowWatchBookmarks") == "true" {
+		watcher.bookmarkInterval = h.watchBookmarkInterval
 	}
 
 	if err := i.AddEventHandler(watcher); err != nil {
@@ -120,10 +169,33 @@ func (h *apiServerHandler) watchForResource(req *restful.Request, resp *restful.
 				break L
 			}
 		}
-		reterr = i.RemoveEventHandler(watcher)
+		if err := i.RemoveEventHandler(watcher); err != nil && reterr == nil {
+			reterr = err
+		}
 		// Note: After we removed the handler, no new events will be written to the events channel.
 	}()
 
+	// Gets the events happened after the requested resource version.
+	// NOTE: This is done after adding the event handler, so no events are lost.
+	// NOTE: As in a real API server, watches starting from a resource version which is too old get an ERROR event
+	// with an Expired status, and then the watch is closed.
+	history, err := c.EventsSince(resourceGroup, gvk, req.QueryParameter("resourceVersion"))
+	if err != nil {
+		var apiStatus apierrors.APIStatus
+		if !apierrors.IsResourceExpired(err) || !errors.As(err, &apiStatus) {
+			return err
+		}
+		status := apiStatus.Status()
+		status.Kind = "Status"
+		status.APIVersion = "v1"
+		watcher.history = []*Event{{Type: watch.Error, Object: &status}}
+	}
+	for _, e := range history {
+		if watcher.matches(resourceGroup, e.Object) {
+			watcher.history = append(watcher.history, &Event{Type: e.Type, Object: e.Object})
+		}
+	}
+
 	return watcher.Run(ctx, queryTimeout, resp)
 }
 
@@ -149,17 +221,48 @@ func (m *WatchEventDispatcher) Run(ctx context.Context, timeout string, w http.R
 	ctx, cancel := context.WithTimeout(ctx, seconds)
 	defer cancel()
 	defer timeoutTimer.Stop()
+
+	var lastResourceVersion uint64
+	for _, event := range m.history {
+		if err := resp.WriteEntity(event); err != nil {
+			_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		}
+		if event.Type == watch.Error {
+			flusher.Flush()
+			return nil
+		}
+		lastResourceVersion = eventResourceVersion(event)
+	}
+	flusher.Flush()
+
+	var bookmarkCh <-chan time.Time
+	if m.bookmarkInterval > 0 {
+		bookmarkTicker := time.NewTicker(m.bookmarkInterval)
+		defer bookmarkTicker.Stop()
+		bookmarkCh = bookmarkTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		case <-timeoutTimer.C:
 			return nil
+		case <-bookmarkCh:
+			if event := m.bookmark(); event != nil {
+				if err := resp.WriteEntity(event); err != nil {
+					_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+				}
+				flusher.Flush()
+			}
 		case event, ok := <-m.events:
 			if !ok {
 				// End of results.
 				return nil
 			}
+			if lastResourceVersion > 0 && eventResourceVersion(event) <= lastResourceVersion {
+				continue
+			}
 			if err := resp.WriteEntity(event); err != nil {
 				_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
 			}
@@ -170,6 +273,34 @@ func (m *WatchEventDispatcher) Run(ctx context.Context, timeout string, w http.R
 	}
 }
 
+// bookmark returns a bookmark event carrying the latest resource version, or nil if a bookmark can't be sent.
+// NOTE: The resource version is read before checking the events channel is empty, so all the events up to the
+// resource version are already sent when the bookmark is sent; if there are pending events, the bookmark is skipped.
+func (m *WatchEventDispatcher) bookmark() *Event {
+	if m.resourceVersion == nil {
+		return nil
+	}
+	resourceVersion, err := m.resourceVersion()
+	if err != nil || len(m.events) > 0 {
+		return nil
+	}
+
+	o := &unstructured.Unstructured{}
+	o.SetGroupVersionKind(m.gvk)
+	o.SetResourceVersion(resourceVersion)
+	return &Event{Type: watch.Bookmark, Object: o}
+}
+
+// eventResourceVersion returns the resource version of the object in an event, or 0 if it can't be parsed.
+func eventResourceVersion(event *Event) uint64 {
+	o, ok := event.Object.(client.Object)
+	if !ok {
+		return 0
+	}
+	rv, _ := ccache.ParseResourceVersion(o.GetResourceVersion())
+	return rv
+}
+
 // setTimer creates a time.Timer with the passed `timeout` or a default timeout of 120 seconds if `timeout` is empty.
 func setTimer(timeout string) (*time.Timer, time.Duration, error) {
 	var defaultTimeout = 120 * time.Second
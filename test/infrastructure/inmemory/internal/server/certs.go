@@ -17,9 +17,17 @@ limitations under the License.
 package server
 
 import (
+	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math"
+	"math/big"
 	"net"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -38,8 +46,98 @@ func init() {
 	}
 }
 
-func newCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config *certs.Config) (*x509.Certificate, *rsa.PrivateKey, error) {
-	cert, err := config.NewSignedCert(key, caCert, caKey)
+// CertConfig is the configuration of a certificate to be generated by a CertificateFactory.
+type CertConfig struct {
+	// Config defines the subject, the SANs and the usages of the certificate.
+	Config *certs.Config
+
+	// CACert and CAKey are the CA to be used for signing the certificate.
+	CACert *x509.Certificate
+	CAKey  *rsa.PrivateKey
+
+	// Validity is the validity period of the certificate.
+	Validity time.Duration
+
+	// Now is the time the certificate is generated at, as seen by the clock of the workload clusters mux;
+	// the certificate expires after Validity from Now. If not set, the current time is used.
+	Now time.Time
+}
+
+// CertificateFactory generates a certificate as defined by a CertConfig, together with its private key.
+type CertificateFactory func(cfg CertConfig) (*x509.Certificate, crypto.Signer, error)
+
+// defaultCertificateFactory generates certificates using a shared RSA key.
+func defaultCertificateFactory(cfg CertConfig) (*x509.Certificate, crypto.Signer, error) {
+	now := cfg.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	cert, key, err := newCertAndKey(cfg.CACert, cfg.CAKey, cfg.Config, now, cfg.Validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// newTLSCertificate returns a tls.Certificate for a certificate and its private key.
+func newTLSCertificate(cert *x509.Certificate, key crypto.Signer) *tls.Certificate {
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+}
+
+// encodePrivateKeyPEM returns PEM-encoded private key data; RSA keys are encoded in PKCS1 format, while other
+// keys are encoded in PKCS8 format. nil is returned if the key can't be encoded.
+func encodePrivateKeyPEM(key crypto.Signer) []byte {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return certs.EncodePrivateKeyPEM(rsaKey)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// newCertAndKey creates a certificate signed by the given CA, valid for the given period of time starting from now.
+// NOTE: This mirrors certs.Config.NewSignedCert, which instead always uses certs.DefaultCertDuration.
+// NOTE: All the certificates use the same private key, which is generated only once, so adding API servers or
+// etcd members does not require RSA key generation; certificates are still distinct (subject, SANs, serial number).
+func newCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config *certs.Config, now time.Time, validity time.Duration) (*x509.Certificate, *rsa.PrivateKey, error) {
+	if config.CommonName == "" {
+		return nil, nil, errors.New("unable to create certificate: must specify a CommonName")
+	}
+	if len(config.Usages) == 0 {
+		return nil, nil, errors.New("unable to create certificate: must specify at least one ExtKeyUsage")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to create certificate: failed to generate serial number")
+	}
+
+	tmpl := x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   config.CommonName,
+			Organization: config.Organization,
+		},
+		DNSNames:     config.AltNames.DNSNames,
+		IPAddresses:  config.AltNames.IPs,
+		SerialNumber: serial,
+		NotBefore:    caCert.NotBefore,
+		NotAfter:     now.Add(validity).UTC(),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  config.Usages,
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, &tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to create certificate")
+	}
+
+	cert, err := x509.ParseCertificate(b)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "unable to create certificate")
 	}
@@ -47,8 +145,60 @@ func newCertAndKey(caCert *x509.Certificate, caKey *rsa.PrivateKey, config *cert
 	return cert, key, nil
 }
 
+// newAPIServerServingCertificate returns a serving certificate for the API server, signed by the given CA
+// and generated using the given CertificateFactory.
+func newAPIServerServingCertificate(factory CertificateFactory, host string, caCert *x509.Certificate, caKey *rsa.PrivateKey, now time.Time, validity time.Duration, extraSANs ...string) (*tls.Certificate, error) {
+	cert, key, err := factory(CertConfig{Config: apiServerCertificateConfig(host, extraSANs...), CACert: caCert, CAKey: caKey, Validity: validity, Now: now})
+	if err != nil {
+		return nil, err
+	}
+	return newTLSCertificate(cert, key), nil
+}
+
+// leafCertificate returns the parsed leaf certificate of a tls.Certificate, or nil if it is not available.
+func leafCertificate(certificate *tls.Certificate) *x509.Certificate {
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// verifyTLSCertificate verifies the leaf certificate of a tls.Certificate; see verifyCertificate for details.
+func verifyTLSCertificate(certificate *tls.Certificate, roots *x509.CertPool, now time.Time, usage x509.ExtKeyUsage) error {
+	if len(certificate.Certificate) == 0 {
+		return errors.New("certificate is empty")
+	}
+	cert, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return errors.Wrap(err, "unable to parse certificate")
+	}
+	return verifyCertificate(cert, roots, now, usage)
+}
+
+// verifyCertificate verifies that a certificate is not expired and, if roots are provided, that it chains to one of them.
+func verifyCertificate(cert *x509.Certificate, roots *x509.CertPool, now time.Time, usage x509.ExtKeyUsage) error {
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.Errorf("certificate is valid from %s to %s, current time is %s", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
+	if roots == nil {
+		return nil
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{usage},
+	}); err != nil {
+		return errors.Wrap(err, "unable to verify certificate against the CA")
+	}
+	return nil
+}
+
 // apiServerCertificateConfig returns the config for an API server serving certificate.
-func apiServerCertificateConfig(controlPlaneIP string) *certs.Config {
+func apiServerCertificateConfig(controlPlaneIP string, extraSANs ...string) *certs.Config {
 	altNames := &certs.AltNames{
 		DNSNames: []string{
 			// NOTE: DNS names for the kubernetes service are not required (the API
@@ -63,6 +213,16 @@ func apiServerCertificateConfig(controlPlaneIP string) *certs.Config {
 		},
 	}
 
+	// Extra SANs are added as IPs or DNS names depending on their format, e.g. to allow
+	// accessing the API server via a service name.
+	for _, san := range extraSANs {
+		if ip := net.ParseIP(san); ip != nil {
+			altNames.IPs = append(altNames.IPs, ip)
+			continue
+		}
+		altNames.DNSNames = append(altNames.DNSNames, san)
+	}
+
 	return &certs.Config{
 		CommonName: "kube-apiserver",
 		AltNames:   *altNames,
@@ -80,8 +240,9 @@ func adminClientCertificateConfig() *certs.Config {
 	}
 }
 
-// etcdServerCertificateConfig returns the config for an etcd member serving certificate.
-func etcdServerCertificateConfig(podName, podIP string) *certs.Config {
+// etcdServerCertificateConfig returns the config for an etcd member serving certificate, including the
+// same SANs kubeadm uses for etcd (pod name, localhost and loopback addresses) and the serviceName, if any.
+func etcdServerCertificateConfig(podName, podIP, serviceName string) *certs.Config {
 	altNames := certs.AltNames{
 		DNSNames: []string{
 			"localhost",
@@ -93,6 +254,9 @@ func etcdServerCertificateConfig(podName, podIP string) *certs.Config {
 			net.ParseIP(podIP),
 		},
 	}
+	if serviceName != "" {
+		altNames.DNSNames = append(altNames.DNSNames, serviceName)
+	}
 
 	return &certs.Config{
 		CommonName: podName,
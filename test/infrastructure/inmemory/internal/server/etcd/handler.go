@@ -19,18 +19,22 @@ package etcd
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/version"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	cloudv1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/api/v1alpha1"
@@ -42,14 +46,19 @@ import (
 // request targets.
 type ResourceGroupResolver func(host string) (string, error)
 
+// EtcdMembersResolver defines a func that returns the names of the etcd members
+// currently added to the workloadCluster served at host.
+type EtcdMembersResolver func(host string) (sets.Set[string], error)
+
 // NewEtcdServerHandler returns an http.Handler for fake etcd members.
-func NewEtcdServerHandler(manager cmanager.Manager, log logr.Logger, resolver ResourceGroupResolver) http.Handler {
+func NewEtcdServerHandler(manager cmanager.Manager, log logr.Logger, resolver ResourceGroupResolver, membersResolver EtcdMembersResolver) http.Handler {
 	svr := grpc.NewServer()
 
 	baseSvr := &baseServer{
 		manager:               manager,
 		log:                   log,
 		resourceGroupResolver: resolver,
+		etcdMembersResolver:   membersResolver,
 	}
 
 	clusterServerSrv := &clusterServerServer{
@@ -62,6 +71,21 @@ func NewEtcdServerHandler(manager cmanager.Manager, log logr.Logger, resolver Re
 	}
 	pb.RegisterMaintenanceServer(svr, maintenanceSrv)
 
+	kvSrv := &kvServer{
+		baseServer: baseSvr,
+	}
+	pb.RegisterKVServer(svr, kvSrv)
+
+	leaseSrv := &leaseServer{
+		baseServer: baseSvr,
+	}
+	pb.RegisterLeaseServer(svr, leaseSrv)
+
+	watchSrv := &watchServer{
+		baseServer: baseSvr,
+	}
+	pb.RegisterWatchServer(svr, watchSrv)
+
 	return svr
 }
 
@@ -105,16 +129,48 @@ func (m *maintenanceServer) Status(ctx context.Context, _ *pb.StatusRequest) (*p
 	cloudClient := m.manager.GetResourceGroup(resourceGroup).GetClient()
 
 	m.log.V(4).Info("Etcd: Status", "resourceGroup", resourceGroup, "etcdMember", etcdMember)
-	_, statusResponse, err := m.inspectEtcd(ctx, cloudClient, etcdMember)
+	_, statusResponse, err := m.inspectEtcd(ctx, cloudClient, resourceGroup, etcdMember)
 	if err != nil {
 		return nil, err
 	}
 
+	// Fills in the status of the key value store, so it is consistent across calls like in a real etcd.
+	revision, dbSize := m.getKVStore(resourceGroup).status()
+	if statusResponse.Header == nil {
+		statusResponse.Header = &pb.ResponseHeader{}
+	}
+	statusResponse.Header.Revision = revision
+	statusResponse.Version = version.Version
+	statusResponse.DbSize = dbSize
+	statusResponse.DbSizeInUse = dbSize
+	statusResponse.RaftTerm = 1
+	statusResponse.RaftIndex = uint64(revision)
+	statusResponse.RaftAppliedIndex = uint64(revision)
+
 	return statusResponse, nil
 }
 
-func (m *maintenanceServer) Defragment(_ context.Context, _ *pb.DefragmentRequest) (*pb.DefragmentResponse, error) {
-	return nil, fmt.Errorf("not implemented: Defragment")
+func (m *maintenanceServer) Defragment(ctx context.Context, _ *pb.DefragmentRequest) (*pb.DefragmentResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("Defragment", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = m.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.log.V(4).Info("Etcd: Defragment", "resourceGroup", resourceGroup, "etcdMember", etcdMember)
+
+	// NOTE: There is nothing to defragment in the in-memory key value store, so this is a no-op.
+	revision, _ := m.getKVStore(resourceGroup).status()
+	return &pb.DefragmentResponse{
+		Header: &pb.ResponseHeader{Revision: revision},
+	}, nil
 }
 
 func (m *maintenanceServer) Hash(_ context.Context, _ *pb.HashRequest) (*pb.HashResponse, error) {
@@ -255,7 +311,7 @@ func (c *clusterServerServer) MemberList(ctx context.Context, _ *pb.MemberListRe
 	cloudClient := c.manager.GetResourceGroup(resourceGroup).GetClient()
 
 	c.log.V(4).Info("Etcd: MemberList", "resourceGroup", resourceGroup, "etcdMember", etcdMember)
-	memberList, _, err := c.inspectEtcd(ctx, cloudClient, etcdMember)
+	memberList, _, err := c.inspectEtcd(ctx, cloudClient, resourceGroup, etcdMember)
 	if err != nil {
 		return nil, err
 	}
@@ -271,6 +327,24 @@ type baseServer struct {
 	manager               cmanager.Manager
 	log                   logr.Logger
 	resourceGroupResolver ResourceGroupResolver
+	etcdMembersResolver   EtcdMembersResolver
+
+	kvStoresLock sync.Mutex
+	kvStores     map[string]*kvStore
+}
+
+// getKVStore returns the kvStore for a resource group, creating it if it does not exist yet.
+func (b *baseServer) getKVStore(resourceGroup string) *kvStore {
+	b.kvStoresLock.Lock()
+	defer b.kvStoresLock.Unlock()
+
+	if b.kvStores == nil {
+		b.kvStores = map[string]*kvStore{}
+	}
+	if _, ok := b.kvStores[resourceGroup]; !ok {
+		b.kvStores[resourceGroup] = newKVStore()
+	}
+	return b.kvStores[resourceGroup]
 }
 
 func (b *baseServer) getResourceGroupAndMember(ctx context.Context) (resourceGroup string, etcdMember string, err error) {
@@ -289,7 +363,28 @@ func (b *baseServer) getResourceGroupAndMember(ctx context.Context) (resourceGro
 	return
 }
 
-func (b *baseServer) inspectEtcd(ctx context.Context, cloudClient cclient.Client, etcdMember string) (*pb.MemberListResponse, *pb.StatusResponse, error) {
+// inspectEtcd returns the member list and the status of the etcd cluster in a resourceGroup.
+// NOTE: Members are derived from etcd pods; if an etcdMembersResolver is set, only the members currently added
+// to the workload cluster are considered, so the member list is consistent with the members which can be reached.
+func (b *baseServer) inspectEtcd(ctx context.Context, cloudClient cclient.Client, resourceGroup, etcdMember string) (*pb.MemberListResponse, *pb.StatusResponse, error) {
+	var etcdMembers sets.Set[string]
+	if b.etcdMembersResolver != nil {
+		var err error
+		etcdMembers, err = b.etcdMembersResolver(fmt.Sprintf("%s", ctx.Value(http.LocalAddrContextKey)))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to get etcd members")
+		}
+	}
+
+	// Client and peer URLs are derived from the address of the listener serving the request.
+	var clientURLs, peerURLs []string
+	if localAddr, ok := ctx.Value(http.LocalAddrContextKey).(net.Addr); ok {
+		clientURLs = []string{fmt.Sprintf("https://%s", localAddr.String())}
+		if host, _, err := net.SplitHostPort(localAddr.String()); err == nil {
+			peerURLs = []string{fmt.Sprintf("https://%s", net.JoinHostPort(host, "2380"))}
+		}
+	}
+
 	etcdPods := &corev1.PodList{}
 	if err := cloudClient.List(ctx, etcdPods,
 		client.InNamespace(metav1.NamespaceSystem),
@@ -312,6 +407,9 @@ func (b *baseServer) inspectEtcd(ctx context.Context, cloudClient cclient.Client
 			}
 			continue
 		}
+		if etcdMembers != nil && !etcdMembers.Has(pod.Name) {
+			continue
+		}
 		if clusterID == 0 {
 			var err error
 			clusterID, err = strconv.Atoi(pod.Annotations[cloudv1.EtcdClusterIDAnnotationName])
@@ -334,7 +432,7 @@ func (b *baseServer) inspectEtcd(ctx context.Context, cloudClient cclient.Client
 			}
 		}
 
-		if pod.Name == etcdMember {
+		if pod.Name == fmt.Sprintf("%s%s", "etcd-", etcdMember) {
 			memberList.Header = &pb.ResponseHeader{
 				ClusterId: uint64(clusterID),
 				MemberId:  uint64(memberID),
@@ -342,8 +440,10 @@ func (b *baseServer) inspectEtcd(ctx context.Context, cloudClient cclient.Client
 			statusResponse.Header = memberList.Header
 		}
 		memberList.Members = append(memberList.Members, &pb.Member{
-			ID:   uint64(memberID),
-			Name: strings.TrimPrefix(pod.Name, "etcd-"),
+			ID:         uint64(memberID),
+			Name:       strings.TrimPrefix(pod.Name, "etcd-"),
+			ClientURLs: clientURLs,
+			PeerURLs:   peerURLs,
 		})
 	}
 
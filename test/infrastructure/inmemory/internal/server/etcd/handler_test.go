@@ -19,11 +19,17 @@ package etcd
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"testing"
 	"time"
 
 	. "github.com/onsi/gomega"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -107,15 +113,451 @@ func Test_etcd_scalingflow(t *testing.T) {
 		g.Expect(err).NotTo(HaveOccurred())
 
 		// Expect the inspect call to fail on a member which has been removed.
-		_, _, err = c.inspectEtcd(ctx, cloudClient, fmt.Sprintf("%d", etcdMemberToRemove))
+		_, _, err = c.inspectEtcd(ctx, cloudClient, "group1", fmt.Sprintf("%d", etcdMemberToRemove))
 		g.Expect(err).To(HaveOccurred())
 
 		// inspectEtcd should succeed when calling on a member that has not been removed.
-		members, status, err := c.inspectEtcd(ctx, cloudClient, fmt.Sprintf("%d", etcdMemberToBeLeader))
+		members, status, err := c.inspectEtcd(ctx, cloudClient, "group1", fmt.Sprintf("%d", etcdMemberToBeLeader))
 		g.Expect(err).ToNot(HaveOccurred())
 
 		g.Expect(status.Leader).To(Equal(etcdMemberToBeLeader))
 		g.Expect(members.GetMembers()).To(HaveLen(2))
 		g.Expect(members.GetMembers()).NotTo(ContainElement(fmt.Sprintf("etcd-%d", etcdMemberToRemove)))
 	})
+
+	t.Run("status and defragment", func(t *testing.T) {
+		status, err := m.Status(ctx, &pb.StatusRequest{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(status.Leader).To(Equal(etcdMemberToBeLeader))
+		g.Expect(status.Version).ToNot(BeEmpty())
+		g.Expect(status.DbSize).To(BeNumerically(">", 0))
+		g.Expect(status.RaftIndex).To(BeNumerically(">", 0))
+
+		_, err = m.Defragment(ctx, &pb.DefragmentRequest{})
+		g.Expect(err).NotTo(HaveOccurred())
+
+		// Status is consistent across calls.
+		status2, err := m.Status(ctx, &pb.StatusRequest{})
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(status2.DbSize).To(Equal(status.DbSize))
+		g.Expect(status2.RaftIndex).To(Equal(status.RaftIndex))
+	})
+}
+
+func Test_etcd_leases(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	g := NewWithT(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{":authority": "etcd-1"}))
+	manager := manager.New(scheme)
+	resourceGroupResolver := func(host string) (string, error) { return "group1", nil }
+	baseSvr := &baseServer{
+		log:                   log.FromContext(ctx),
+		manager:               manager,
+		resourceGroupResolver: resourceGroupResolver,
+	}
+	kv := &kvServer{baseServer: baseSvr}
+	l := &leaseServer{baseServer: baseSvr}
+
+	keyExists := func(key string) bool {
+		resp, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte(key)})
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.Count == 1
+	}
+
+	t.Run("keys attached to a lease are deleted when the lease expires", func(t *testing.T) {
+		lease, err := l.LeaseGrant(ctx, &pb.LeaseGrantRequest{TTL: 1})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("/expiring"), Value: []byte("foo"), Lease: lease.ID})
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("/not-expiring"), Value: []byte("foo")})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		ttl, err := l.LeaseTimeToLive(ctx, &pb.LeaseTimeToLiveRequest{ID: lease.ID, Keys: true})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ttl.GrantedTTL).To(Equal(int64(1)))
+		g.Expect(ttl.Keys).To(Equal([][]byte{[]byte("/expiring")}))
+		g.Expect(keyExists("/expiring")).To(BeTrue())
+
+		g.Eventually(func() bool { return keyExists("/expiring") }, 5*time.Second, 100*time.Millisecond).Should(BeFalse())
+		g.Expect(keyExists("/not-expiring")).To(BeTrue())
+
+		ttl, err = l.LeaseTimeToLive(ctx, &pb.LeaseTimeToLiveRequest{ID: lease.ID})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ttl.TTL).To(Equal(int64(-1)))
+	})
+
+	t.Run("keys attached to a lease are deleted when the lease is revoked", func(t *testing.T) {
+		lease, err := l.LeaseGrant(ctx, &pb.LeaseGrantRequest{TTL: 60})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("/revoked"), Value: []byte("foo"), Lease: lease.ID})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(keyExists("/revoked")).To(BeTrue())
+
+		leases, err := l.LeaseLeases(ctx, &pb.LeaseLeasesRequest{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(leases.Leases).To(ContainElement(HaveField("ID", lease.ID)))
+
+		_, err = l.LeaseRevoke(ctx, &pb.LeaseRevokeRequest{ID: lease.ID})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(keyExists("/revoked")).To(BeFalse())
+
+		_, err = kv.Put(ctx, &pb.PutRequest{Key: []byte("/revoked"), Value: []byte("foo"), Lease: lease.ID})
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func Test_etcd_txn(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	g := NewWithT(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{":authority": "etcd-1"}))
+	manager := manager.New(scheme)
+	resourceGroupResolver := func(host string) (string, error) { return "group1", nil }
+	kv := &kvServer{
+		baseServer: &baseServer{
+			log:                   log.FromContext(ctx),
+			manager:               manager,
+			resourceGroupResolver: resourceGroupResolver,
+		},
+	}
+
+	// compareAndSwap mimics how the Kubernetes storage layer updates a key using optimistic concurrency.
+	compareAndSwap := func(key string, modRevision int64, value string) *pb.TxnResponse {
+		resp, err := kv.Txn(ctx, &pb.TxnRequest{
+			Compare: []*pb.Compare{{
+				Key:         []byte(key),
+				Target:      pb.Compare_MOD,
+				Result:      pb.Compare_EQUAL,
+				TargetUnion: &pb.Compare_ModRevision{ModRevision: modRevision},
+			}},
+			Success: []*pb.RequestOp{{
+				Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: []byte(key), Value: []byte(value)}},
+			}},
+			Failure: []*pb.RequestOp{{
+				Request: &pb.RequestOp_RequestRange{RequestRange: &pb.RangeRequest{Key: []byte(key)}},
+			}},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	t.Run("create if the key does not exist", func(t *testing.T) {
+		resp := compareAndSwap("/foo", 0, "v1")
+		g.Expect(resp.Succeeded).To(BeTrue())
+		g.Expect(resp.Responses).To(HaveLen(1))
+		g.Expect(resp.Responses[0].GetResponsePut()).ToNot(BeNil())
+
+		resp = compareAndSwap("/foo", 0, "v1")
+		g.Expect(resp.Succeeded).To(BeFalse())
+	})
+
+	t.Run("update if the key did not change", func(t *testing.T) {
+		get, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte("/foo")})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(get.Kvs).To(HaveLen(1))
+		modRevision := get.Kvs[0].ModRevision
+
+		resp := compareAndSwap("/foo", modRevision, "v2")
+		g.Expect(resp.Succeeded).To(BeTrue())
+		g.Expect(resp.Header.Revision).To(Equal(modRevision + 1))
+
+		// A concurrent writer using the old revision gets the current value.
+		resp = compareAndSwap("/foo", modRevision, "v3")
+		g.Expect(resp.Succeeded).To(BeFalse())
+		g.Expect(resp.Responses).To(HaveLen(1))
+		current := resp.Responses[0].GetResponseRange()
+		g.Expect(current.Kvs).To(HaveLen(1))
+		g.Expect(current.Kvs[0].Value).To(Equal([]byte("v2")))
+		g.Expect(current.Kvs[0].Version).To(Equal(int64(2)))
+	})
+
+	t.Run("conditional delete", func(t *testing.T) {
+		resp, err := kv.Txn(ctx, &pb.TxnRequest{
+			Compare: []*pb.Compare{{
+				Key:         []byte("/foo"),
+				Target:      pb.Compare_VERSION,
+				Result:      pb.Compare_EQUAL,
+				TargetUnion: &pb.Compare_Version{Version: 2},
+			}},
+			Success: []*pb.RequestOp{{
+				Request: &pb.RequestOp_RequestDeleteRange{RequestDeleteRange: &pb.DeleteRangeRequest{Key: []byte("/foo")}},
+			}},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Succeeded).To(BeTrue())
+		g.Expect(resp.Responses[0].GetResponseDeleteRange().Deleted).To(Equal(int64(1)))
+	})
+
+	t.Run("txn is not partially applied", func(t *testing.T) {
+		_, err := kv.Txn(ctx, &pb.TxnRequest{
+			Success: []*pb.RequestOp{
+				{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: []byte("/bar"), Value: []byte("v1")}}},
+				{Request: &pb.RequestOp_RequestPut{RequestPut: &pb.PutRequest{Key: []byte("/baz"), Value: []byte("v1"), Lease: 123}}},
+			},
+		})
+		g.Expect(err).To(HaveOccurred())
+
+		get, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte("/bar")})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(get.Count).To(BeZero())
+	})
+}
+
+func Test_etcd_watch(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	g := NewWithT(t)
+	ctx := context.Background()
+	manager := manager.New(scheme)
+	resourceGroupResolver := func(host string) (string, error) { return "group1", nil }
+	baseSvr := &baseServer{
+		log:                   log.FromContext(ctx),
+		manager:               manager,
+		resourceGroupResolver: resourceGroupResolver,
+	}
+	svr := grpc.NewServer()
+	pb.RegisterKVServer(svr, &kvServer{baseServer: baseSvr})
+	pb.RegisterWatchServer(svr, &watchServer{baseServer: baseSvr})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	go func() {
+		_ = svr.Serve(l)
+	}()
+	defer svr.Stop()
+
+	newEtcdClient := func() *clientv3.Client {
+		etcdClient, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{l.Addr().String()},
+			DialTimeout: 2 * time.Second,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		return etcdClient
+	}
+	// watch starts a watch and waits until it is created, so no event is missed.
+	watch := func(ctx context.Context, etcdClient *clientv3.Client, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+		watchCh := etcdClient.Watch(ctx, key, append(opts, clientv3.WithCreatedNotify())...)
+		created := <-watchCh
+		g.Expect(created.Created).To(BeTrue())
+		return watchCh
+	}
+	nextEvents := func(watchCh clientv3.WatchChan) []*clientv3.Event {
+		select {
+		case resp := <-watchCh:
+			g.Expect(resp.Err()).ToNot(HaveOccurred())
+			return resp.Events
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for watch events")
+		}
+		return nil
+	}
+
+	etcdClient1 := newEtcdClient()
+	defer etcdClient1.Close()
+	etcdClient2 := newEtcdClient()
+	defer etcdClient2.Close()
+
+	watchCtx1, cancel1 := context.WithCancel(ctx)
+	defer cancel1()
+	watchCh1 := watch(watchCtx1, etcdClient1, "/registry/", clientv3.WithPrefix())
+	watchCtx2, cancel2 := context.WithCancel(ctx)
+	defer cancel2()
+	watchCh2 := watch(watchCtx2, etcdClient2, "/registry/", clientv3.WithPrefix(), clientv3.WithPrevKV())
+
+	t.Run("all the watchers on the same prefix observe a put", func(t *testing.T) {
+		put, err := etcdClient1.Put(ctx, "/registry/foo", "v1")
+		g.Expect(err).ToNot(HaveOccurred())
+		// Keys outside of the prefix are not observed.
+		_, err = etcdClient1.Put(ctx, "/other/foo", "v1")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = etcdClient1.Put(ctx, "/registry/foo", "v2")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		for _, watchCh := range []clientv3.WatchChan{watchCh1, watchCh2} {
+			events := nextEvents(watchCh)
+			g.Expect(events).To(HaveLen(1))
+			g.Expect(events[0].Type).To(Equal(mvccpb.PUT))
+			g.Expect(string(events[0].Kv.Key)).To(Equal("/registry/foo"))
+			g.Expect(string(events[0].Kv.Value)).To(Equal("v1"))
+			g.Expect(events[0].Kv.ModRevision).To(Equal(put.Header.Revision))
+
+			events = nextEvents(watchCh)
+			g.Expect(events).To(HaveLen(1))
+			g.Expect(string(events[0].Kv.Value)).To(Equal("v2"))
+			g.Expect(events[0].Kv.ModRevision).To(Equal(put.Header.Revision + 2))
+		}
+	})
+
+	t.Run("all the watchers on the same prefix observe a delete", func(t *testing.T) {
+		del, err := etcdClient2.Delete(ctx, "/registry/foo")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		events := nextEvents(watchCh1)
+		g.Expect(events).To(HaveLen(1))
+		g.Expect(events[0].Type).To(Equal(mvccpb.DELETE))
+		g.Expect(events[0].Kv.ModRevision).To(Equal(del.Header.Revision))
+		g.Expect(events[0].PrevKv).To(BeNil())
+
+		// Watchers requesting the previous value get it.
+		events = nextEvents(watchCh2)
+		g.Expect(events).To(HaveLen(1))
+		g.Expect(events[0].Type).To(Equal(mvccpb.DELETE))
+		g.Expect(events[0].PrevKv).ToNot(BeNil())
+		g.Expect(string(events[0].PrevKv.Value)).To(Equal("v2"))
+	})
+
+	t.Run("watchers are removed when the watch is cancelled", func(t *testing.T) {
+		store := baseSvr.getKVStore("group1")
+		watchers := func() int {
+			store.lock.Lock()
+			defer store.lock.Unlock()
+			return len(store.watchers)
+		}
+		g.Expect(watchers()).To(Equal(2))
+
+		cancel1()
+		g.Eventually(watchers, 5*time.Second, 10*time.Millisecond).Should(Equal(1))
+
+		// Other watchers are not affected.
+		_, err := etcdClient2.Put(ctx, "/registry/bar", "v1")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		events := nextEvents(watchCh2)
+		g.Expect(events).To(HaveLen(1))
+		g.Expect(string(events[0].Kv.Key)).To(Equal("/registry/bar"))
+	})
+
+	t.Run("watchers starting from a revision in the past observe the changes since that revision", func(t *testing.T) {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		watchCh := etcdClient1.Watch(watchCtx, "/registry/", clientv3.WithPrefix(), clientv3.WithRev(1), clientv3.WithPrevKV())
+
+		events := nextEvents(watchCh)
+		g.Expect(events).To(HaveLen(4))
+		g.Expect(events[0].Type).To(Equal(mvccpb.PUT))
+		g.Expect(string(events[0].Kv.Value)).To(Equal("v1"))
+		g.Expect(events[1].Type).To(Equal(mvccpb.PUT))
+		g.Expect(string(events[1].Kv.Value)).To(Equal("v2"))
+		g.Expect(string(events[1].PrevKv.Value)).To(Equal("v1"))
+		g.Expect(events[2].Type).To(Equal(mvccpb.DELETE))
+		g.Expect(string(events[2].Kv.Key)).To(Equal("/registry/foo"))
+		g.Expect(string(events[2].PrevKv.Value)).To(Equal("v2"))
+		g.Expect(string(events[3].Kv.Key)).To(Equal("/registry/bar"))
+	})
+
+	t.Run("watchers can't start from a compacted revision", func(t *testing.T) {
+		get, err := etcdClient1.Get(ctx, "/registry/bar")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = etcdClient1.Compact(ctx, get.Header.Revision)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		watchCh := etcdClient1.Watch(ctx, "/registry/", clientv3.WithPrefix(), clientv3.WithRev(1))
+		resp := <-watchCh
+		g.Expect(resp.Canceled).To(BeTrue())
+		g.Expect(resp.CompactRevision).To(Equal(get.Header.Revision))
+	})
+}
+
+func Test_etcd_history(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	g := NewWithT(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{":authority": "etcd-1"}))
+	manager := manager.New(scheme)
+	resourceGroupResolver := func(host string) (string, error) { return "group1", nil }
+	kv := &kvServer{
+		baseServer: &baseServer{
+			log:                   log.FromContext(ctx),
+			manager:               manager,
+			resourceGroupResolver: resourceGroupResolver,
+		},
+	}
+
+	put := func(key, value string) int64 {
+		resp, err := kv.Put(ctx, &pb.PutRequest{Key: []byte(key), Value: []byte(value)})
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.Header.Revision
+	}
+	// getAt returns the values of the keys with the given prefix as of the given revision.
+	getAt := func(prefix string, revision int64) ([]string, error) {
+		resp, err := kv.Range(ctx, &pb.RangeRequest{Key: []byte(prefix), RangeEnd: []byte(clientv3.GetPrefixRangeEnd(prefix)), Revision: revision})
+		if err != nil {
+			return nil, err
+		}
+		values := []string{}
+		for _, kv := range resp.Kvs {
+			values = append(values, string(kv.Value))
+		}
+		return values, nil
+	}
+
+	rev1 := put("/foo/a", "a1")
+	rev2 := put("/foo/b", "b1")
+	rev3 := put("/foo/a", "a2")
+	del, err := kv.DeleteRange(ctx, &pb.DeleteRangeRequest{Key: []byte("/foo/b")})
+	g.Expect(err).ToNot(HaveOccurred())
+	rev4 := del.Header.Revision
+
+	t.Run("range at a revision returns the values as of that revision", func(t *testing.T) {
+		for _, tt := range []struct {
+			revision int64
+			want     []string
+		}{
+			{revision: rev1 - 1, want: []string{}},
+			{revision: rev1, want: []string{"a1"}},
+			{revision: rev2, want: []string{"a1", "b1"}},
+			{revision: rev3, want: []string{"a2", "b1"}},
+			{revision: rev4, want: []string{"a2"}},
+		} {
+			values, err := getAt("/foo/", tt.revision)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(values).To(Equal(tt.want), "revision %d", tt.revision)
+		}
+
+		_, err := getAt("/foo/", rev4+1)
+		g.Expect(err).To(MatchError(rpctypes.ErrGRPCFutureRev))
+	})
+
+	t.Run("compact trims the history", func(t *testing.T) {
+		_, err := kv.Compact(ctx, &pb.CompactionRequest{Revision: rev3})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = getAt("/foo/", rev2)
+		g.Expect(err).To(MatchError(rpctypes.ErrGRPCCompacted))
+		values, err := getAt("/foo/", rev3)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(values).To(Equal([]string{"a2", "b1"}))
+
+		_, err = kv.Compact(ctx, &pb.CompactionRequest{Revision: rev3})
+		g.Expect(err).To(MatchError(rpctypes.ErrGRPCCompacted))
+
+		// Deleted keys are dropped from the history once compacted.
+		_, err = kv.Compact(ctx, &pb.CompactionRequest{Revision: rev4})
+		g.Expect(err).ToNot(HaveOccurred())
+		store := kv.getKVStore("group1")
+		g.Expect(store.history).To(HaveKey("/foo/a"))
+		g.Expect(store.history).ToNot(HaveKey("/foo/b"))
+	})
+
+	t.Run("history is bounded per key", func(t *testing.T) {
+		first := put("/bar", "0")
+		for i := 1; i <= maxHistoryPerKey; i++ {
+			put("/bar", strconv.Itoa(i))
+		}
+		store := kv.getKVStore("group1")
+		g.Expect(store.history["/bar"]).To(HaveLen(maxHistoryPerKey))
+
+		_, err := getAt("/bar", first)
+		g.Expect(err).To(MatchError(rpctypes.ErrGRPCCompacted))
+		values, err := getAt("/bar", first+1)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(values).To(Equal([]string{"1"}))
+	})
 }
@@ -0,0 +1,435 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// kvServer implements the KVServer grpc server.
+type kvServer struct {
+	*baseServer
+}
+
+func (k *kvServer) Range(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("Range", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = k.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.log.V(4).Info("Etcd: Range", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "key", string(req.Key))
+
+	store := k.getKVStore(resourceGroup)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.rangeRequestLocked(req)
+}
+
+func (k *kvServer) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("Put", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = k.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.log.V(4).Info("Etcd: Put", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "key", string(req.Key))
+
+	store := k.getKVStore(resourceGroup)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	resp, err := store.putRequestLocked(store.revision+1, req)
+	if err != nil {
+		return nil, err
+	}
+	store.revision++
+	store.publishLocked()
+	resp.Header = &pb.ResponseHeader{Revision: store.revision}
+	return resp, nil
+}
+
+func (k *kvServer) DeleteRange(ctx context.Context, req *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("DeleteRange", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = k.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.log.V(4).Info("Etcd: DeleteRange", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "key", string(req.Key))
+
+	store := k.getKVStore(resourceGroup)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	resp := store.deleteRangeRequestLocked(store.revision+1, req)
+	if resp.Deleted > 0 {
+		store.revision++
+		store.publishLocked()
+	}
+	resp.Header = &pb.ResponseHeader{Revision: store.revision}
+	return resp, nil
+}
+
+func (k *kvServer) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("Txn", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = k.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.log.V(4).Info("Etcd: Txn", "resourceGroup", resourceGroup, "etcdMember", etcdMember)
+
+	store := k.getKVStore(resourceGroup)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	// NOTE: Validate the txn before applying it, so a txn is never partially applied.
+	if err := store.validateTxnLocked(req); err != nil {
+		return nil, err
+	}
+
+	// NOTE: As in etcd, all the writes in a txn happen in a single revision.
+	resp, writes, err := store.txnRequestLocked(store.revision+1, req)
+	if err != nil {
+		return nil, err
+	}
+	if writes {
+		store.revision++
+		store.publishLocked()
+	}
+	setResponseHeader(resp, &pb.ResponseHeader{Revision: store.revision})
+	return resp, nil
+}
+
+func (k *kvServer) Compact(ctx context.Context, req *pb.CompactionRequest) (*pb.CompactionResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("Compact", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = k.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	k.log.V(4).Info("Etcd: Compact", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "revision", req.Revision)
+
+	store := k.getKVStore(resourceGroup)
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if err := store.compactLocked(req.Revision); err != nil {
+		return nil, err
+	}
+	return &pb.CompactionResponse{
+		Header: &pb.ResponseHeader{Revision: store.revision},
+	}, nil
+}
+
+func copyKeyValue(kv *mvccpb.KeyValue) *mvccpb.KeyValue {
+	c := *kv
+	return &c
+}
+
+// rangeRequestLocked serves a range request, at the current revision or at the revision of the request, if any.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) rangeRequestLocked(req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	if err := s.checkRevisionLocked(req.Revision); err != nil {
+		return nil, err
+	}
+
+	var kvs []*mvccpb.KeyValue
+	if req.Revision > 0 && req.Revision < s.revision {
+		kvs = s.rangeAtLocked(req.Revision, req.Key, req.RangeEnd)
+	} else {
+		kvs = s.rangeLocked(req.Key, req.RangeEnd)
+	}
+	resp := &pb.RangeResponse{
+		Header: &pb.ResponseHeader{Revision: s.revision},
+		Count:  int64(len(kvs)),
+	}
+	if req.CountOnly {
+		return resp, nil
+	}
+	if req.Limit > 0 && int64(len(kvs)) > req.Limit {
+		kvs = kvs[:req.Limit]
+		resp.More = true
+	}
+	for _, kv := range kvs {
+		kv = copyKeyValue(kv)
+		if req.KeysOnly {
+			kv.Value = nil
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+	}
+	return resp, nil
+}
+
+// putRequestLocked serves a put request at the given revision.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) putRequestLocked(revision int64, req *pb.PutRequest) (*pb.PutResponse, error) {
+	if len(req.Key) == 0 {
+		return nil, rpctypes.ErrGRPCEmptyKey
+	}
+
+	value, leaseID := req.Value, req.Lease
+	if req.IgnoreValue || req.IgnoreLease {
+		current, ok := s.kvs[string(req.Key)]
+		if !ok {
+			return nil, rpctypes.ErrGRPCKeyNotFound
+		}
+		if req.IgnoreValue {
+			value = current.Value
+		}
+		if req.IgnoreLease {
+			leaseID = current.Lease
+		}
+	}
+
+	prev, err := s.putLocked(revision, req.Key, value, leaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.PutResponse{}
+	if req.PrevKv && prev != nil {
+		resp.PrevKv = copyKeyValue(prev)
+	}
+	return resp, nil
+}
+
+// deleteRangeRequestLocked serves a delete range request at the given revision.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) deleteRangeRequestLocked(revision int64, req *pb.DeleteRangeRequest) *pb.DeleteRangeResponse {
+	kvs := s.rangeLocked(req.Key, req.RangeEnd)
+	resp := &pb.DeleteRangeResponse{
+		Deleted: int64(len(kvs)),
+	}
+	for _, kv := range kvs {
+		prev := s.deleteLocked(revision, kv.Key)
+		if req.PrevKv {
+			resp.PrevKvs = append(resp.PrevKvs, copyKeyValue(prev))
+		}
+	}
+	return resp
+}
+
+// txnRequestLocked serves a txn request at the given revision, and returns true if the txn included writes.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) txnRequestLocked(revision int64, req *pb.TxnRequest) (*pb.TxnResponse, bool, error) {
+	resp := &pb.TxnResponse{
+		Succeeded: s.compareLocked(req.Compare),
+	}
+	ops := req.Failure
+	if resp.Succeeded {
+		ops = req.Success
+	}
+
+	writes := false
+	for _, op := range ops {
+		switch r := op.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			rangeResp, err := s.rangeRequestLocked(r.RequestRange)
+			if err != nil {
+				return nil, false, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: rangeResp}})
+		case *pb.RequestOp_RequestPut:
+			putResp, err := s.putRequestLocked(revision, r.RequestPut)
+			if err != nil {
+				return nil, false, err
+			}
+			writes = true
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: putResp}})
+		case *pb.RequestOp_RequestDeleteRange:
+			deleteResp := s.deleteRangeRequestLocked(revision, r.RequestDeleteRange)
+			if deleteResp.Deleted > 0 {
+				writes = true
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: deleteResp}})
+		case *pb.RequestOp_RequestTxn:
+			txnResp, txnWrites, err := s.txnRequestLocked(revision, r.RequestTxn)
+			if err != nil {
+				return nil, false, err
+			}
+			writes = writes || txnWrites
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: txnResp}})
+		default:
+			return nil, false, fmt.Errorf("unknown txn operation %T", op.Request)
+		}
+	}
+	return resp, writes, nil
+}
+
+// validateTxnLocked checks that the operations in the txn branch which is going to be executed can be applied.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) validateTxnLocked(req *pb.TxnRequest) error {
+	ops := req.Failure
+	if s.compareLocked(req.Compare) {
+		ops = req.Success
+	}
+
+	putKeys := map[string]struct{}{}
+	for _, op := range ops {
+		switch r := op.Request.(type) {
+		case *pb.RequestOp_RequestPut:
+			put := r.RequestPut
+			if len(put.Key) == 0 {
+				return rpctypes.ErrGRPCEmptyKey
+			}
+			if _, ok := putKeys[string(put.Key)]; ok {
+				return rpctypes.ErrGRPCDuplicateKey
+			}
+			putKeys[string(put.Key)] = struct{}{}
+			if put.IgnoreValue || put.IgnoreLease {
+				if _, ok := s.kvs[string(put.Key)]; !ok {
+					return rpctypes.ErrGRPCKeyNotFound
+				}
+			}
+			if put.Lease != 0 && !put.IgnoreLease {
+				if _, ok := s.leases[put.Lease]; !ok {
+					return rpctypes.ErrGRPCLeaseNotFound
+				}
+			}
+		case *pb.RequestOp_RequestRange:
+			if err := s.checkRevisionLocked(r.RequestRange.Revision); err != nil {
+				return err
+			}
+		case *pb.RequestOp_RequestTxn:
+			if err := s.validateTxnLocked(r.RequestTxn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compareLocked returns true if all the compares are satisfied.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) compareLocked(compares []*pb.Compare) bool {
+	for _, c := range compares {
+		kvs := s.rangeLocked(c.Key, c.RangeEnd)
+		if len(kvs) == 0 {
+			// NOTE: As in etcd, a key which does not exist has version, revisions and lease equal to 0, and no value.
+			if c.Target == pb.Compare_VALUE {
+				return false
+			}
+			kvs = []*mvccpb.KeyValue{{}}
+		}
+		for _, kv := range kvs {
+			if !compareKeyValue(c, kv) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareKeyValue(c *pb.Compare, kv *mvccpb.KeyValue) bool {
+	var result int
+	switch c.Target {
+	case pb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, c.GetValue())
+	case pb.Compare_VERSION:
+		result = compareInt64(kv.Version, c.GetVersion())
+	case pb.Compare_CREATE:
+		result = compareInt64(kv.CreateRevision, c.GetCreateRevision())
+	case pb.Compare_MOD:
+		result = compareInt64(kv.ModRevision, c.GetModRevision())
+	case pb.Compare_LEASE:
+		result = compareInt64(kv.Lease, c.GetLease())
+	}
+
+	switch c.Result {
+	case pb.Compare_EQUAL:
+		return result == 0
+	case pb.Compare_NOT_EQUAL:
+		return result != 0
+	case pb.Compare_GREATER:
+		return result > 0
+	case pb.Compare_LESS:
+		return result < 0
+	}
+	return false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// setResponseHeader sets the header of a txn response and of all its nested responses.
+func setResponseHeader(resp *pb.TxnResponse, header *pb.ResponseHeader) {
+	resp.Header = header
+	for _, r := range resp.Responses {
+		switch r := r.Response.(type) {
+		case *pb.ResponseOp_ResponseRange:
+			r.ResponseRange.Header = header
+		case *pb.ResponseOp_ResponsePut:
+			r.ResponsePut.Header = header
+		case *pb.ResponseOp_ResponseDeleteRange:
+			r.ResponseDeleteRange.Header = header
+		case *pb.ResponseOp_ResponseTxn:
+			setResponseHeader(r.ResponseTxn, header)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+)
+
+// leaseServer implements the LeaseServer grpc server.
+type leaseServer struct {
+	*baseServer
+}
+
+func (l *leaseServer) LeaseGrant(ctx context.Context, req *pb.LeaseGrantRequest) (*pb.LeaseGrantResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("LeaseGrant", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = l.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l.log.V(4).Info("Etcd: LeaseGrant", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "ttl", req.TTL)
+
+	store := l.getKVStore(resourceGroup)
+	lease, err := store.grant(req.ID, req.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LeaseGrantResponse{
+		Header: l.leaseResponseHeader(store),
+		ID:     lease.id,
+		TTL:    lease.ttl,
+	}, nil
+}
+
+func (l *leaseServer) LeaseRevoke(ctx context.Context, req *pb.LeaseRevokeRequest) (*pb.LeaseRevokeResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("LeaseRevoke", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = l.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l.log.V(4).Info("Etcd: LeaseRevoke", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "id", req.ID)
+
+	store := l.getKVStore(resourceGroup)
+	if err := store.revoke(req.ID); err != nil {
+		return nil, err
+	}
+	return &pb.LeaseRevokeResponse{
+		Header: l.leaseResponseHeader(store),
+	}, nil
+}
+
+func (l *leaseServer) LeaseKeepAlive(stream pb.Lease_LeaseKeepAliveServer) error {
+	resourceGroup, etcdMember, err := l.getResourceGroupAndMember(stream.Context())
+	if err != nil {
+		return err
+	}
+	store := l.getKVStore(resourceGroup)
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		l.log.V(4).Info("Etcd: LeaseKeepAlive", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "id", req.ID)
+
+		// NOTE: As in etcd, keep alive for a lease which does not exist returns TTL 0.
+		ttl, err := store.keepAlive(req.ID)
+		if err != nil && !errors.Is(err, rpctypes.ErrGRPCLeaseNotFound) {
+			return err
+		}
+		if err := stream.Send(&pb.LeaseKeepAliveResponse{
+			Header: l.leaseResponseHeader(store),
+			ID:     req.ID,
+			TTL:    ttl,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *leaseServer) LeaseTimeToLive(ctx context.Context, req *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("LeaseTimeToLive", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = l.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l.log.V(4).Info("Etcd: LeaseTimeToLive", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "id", req.ID)
+
+	store := l.getKVStore(resourceGroup)
+	resp := &pb.LeaseTimeToLiveResponse{
+		Header: l.leaseResponseHeader(store),
+		ID:     req.ID,
+	}
+	ttl, grantedTTL, keys, err := store.timeToLive(req.ID)
+	if err != nil {
+		// NOTE: As in etcd, time to live for a lease which does not exist returns TTL -1.
+		if errors.Is(err, rpctypes.ErrGRPCLeaseNotFound) {
+			resp.TTL = -1
+			return resp, nil
+		}
+		return nil, err
+	}
+	resp.TTL = ttl
+	resp.GrantedTTL = grantedTTL
+	if req.Keys {
+		resp.Keys = keys
+	}
+	return resp, nil
+}
+
+func (l *leaseServer) LeaseLeases(ctx context.Context, _ *pb.LeaseLeasesRequest) (*pb.LeaseLeasesResponse, error) {
+	var resourceGroup string
+	start := time.Now()
+	defer func() {
+		requestLatency.WithLabelValues("LeaseLeases", resourceGroup).Observe(time.Since(start).Seconds())
+	}()
+
+	var etcdMember string
+	var err error
+	resourceGroup, etcdMember, err = l.getResourceGroupAndMember(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	l.log.V(4).Info("Etcd: LeaseLeases", "resourceGroup", resourceGroup, "etcdMember", etcdMember)
+
+	store := l.getKVStore(resourceGroup)
+	resp := &pb.LeaseLeasesResponse{
+		Header: l.leaseResponseHeader(store),
+	}
+	for _, id := range store.leaseIDs() {
+		resp.Leases = append(resp.Leases, &pb.LeaseStatus{ID: id})
+	}
+	return resp, nil
+}
+
+func (l *leaseServer) leaseResponseHeader(store *kvStore) *pb.ResponseHeader {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return &pb.ResponseHeader{Revision: store.revision}
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// emptyDBSize is the size of an empty etcd db.
+const emptyDBSize = 20 * 1024
+
+// maxHistoryPerKey is the maximum number of revisions kept for each key; older revisions are dropped, as if they
+// were compacted.
+const maxHistoryPerKey = 100
+
+// kvStore is a minimal in-memory implementation of the etcd key value store, shared by all the etcd members
+// of a resource group.
+// NOTE: The in memory provider does not run a real Kubernetes storage layer, so the kvStore is meant to support
+// components or tests talking directly with etcd.
+type kvStore struct {
+	lock sync.Mutex
+
+	revision int64
+	kvs      map[string]*mvccpb.KeyValue
+
+	// history are the revisions of each key, sorted by revision, including deletes; revisions older than
+	// compactRevision are dropped, except the ones required to serve requests at compactRevision.
+	history         map[string][]*mvccpb.KeyValue
+	compactRevision int64
+
+	leases      map[int64]*lease
+	lastLeaseID int64
+
+	// watchers are notified about the changes of each revision.
+	watchers map[*watcher]struct{}
+	// changes are the events of the revision being written, to be published to watchers.
+	changes []*mvccpb.Event
+}
+
+// lease is a lease in the kvStore.
+type lease struct {
+	id     int64
+	ttl    int64
+	expiry time.Time
+	timer  *time.Timer
+	keys   sets.Set[string]
+}
+
+func newKVStore() *kvStore {
+	return &kvStore{
+		// NOTE: As in etcd, the revision of an empty store is 1.
+		revision: 1,
+		kvs:      map[string]*mvccpb.KeyValue{},
+		history:  map[string][]*mvccpb.KeyValue{},
+		leases:   map[int64]*lease{},
+		watchers: map[*watcher]struct{}{},
+	}
+}
+
+// rangeLocked returns the keys in the range [key, rangeEnd), sorted by key.
+// If rangeEnd is empty, only key is returned; if rangeEnd is "\0", all the keys greater than or equal to key are returned.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) rangeLocked(key, rangeEnd []byte) []*mvccpb.KeyValue {
+	kvs := []*mvccpb.KeyValue{}
+	for k, kv := range s.kvs {
+		if inRange([]byte(k), key, rangeEnd) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs
+}
+
+// putLocked sets a key in the store at the given revision, and returns the previous value, if any.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) putLocked(revision int64, key, value []byte, leaseID int64) (*mvccpb.KeyValue, error) {
+	if leaseID != 0 {
+		if _, ok := s.leases[leaseID]; !ok {
+			return nil, rpctypes.ErrGRPCLeaseNotFound
+		}
+	}
+
+	prev := s.kvs[string(key)]
+	kv := &mvccpb.KeyValue{
+		Key:            key,
+		Value:          value,
+		CreateRevision: revision,
+		ModRevision:    revision,
+		Version:        1,
+		Lease:          leaseID,
+	}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		kv.Version = prev.Version + 1
+		if l, ok := s.leases[prev.Lease]; ok {
+			l.keys.Delete(string(key))
+		}
+	}
+	if l, ok := s.leases[leaseID]; ok {
+		l.keys.Insert(string(key))
+	}
+	s.kvs[string(key)] = kv
+	s.appendHistoryLocked(kv)
+	s.changes = append(s.changes, &mvccpb.Event{Type: mvccpb.PUT, Kv: kv, PrevKv: prev})
+	return prev, nil
+}
+
+// deleteLocked deletes a key from the store at the given revision, and returns the deleted value, if any.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) deleteLocked(revision int64, key []byte) *mvccpb.KeyValue {
+	prev, ok := s.kvs[string(key)]
+	if !ok {
+		return nil
+	}
+	if l, ok := s.leases[prev.Lease]; ok {
+		l.keys.Delete(string(key))
+	}
+	delete(s.kvs, string(key))
+
+	// NOTE: As in etcd, a delete is recorded as a key value with the revision of the delete and version 0.
+	tombstone := &mvccpb.KeyValue{Key: key, ModRevision: revision}
+	s.appendHistoryLocked(tombstone)
+	s.changes = append(s.changes, &mvccpb.Event{Type: mvccpb.DELETE, Kv: tombstone, PrevKv: prev})
+	return prev
+}
+
+// appendHistoryLocked records a revision of a key; if there are more than maxHistoryPerKey revisions for the key,
+// the oldest one is dropped, and the compact revision is moved forward so requests at the dropped revision fail.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) appendHistoryLocked(kv *mvccpb.KeyValue) {
+	k := string(kv.Key)
+	history := append(s.history[k], kv)
+	if len(history) > maxHistoryPerKey {
+		history = history[1:]
+		if history[0].ModRevision > s.compactRevision {
+			s.compactRevision = history[0].ModRevision
+		}
+	}
+	s.history[k] = history
+}
+
+// rangeAtLocked returns the keys in the range [key, rangeEnd) as of the given revision, sorted by key.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) rangeAtLocked(revision int64, key, rangeEnd []byte) []*mvccpb.KeyValue {
+	kvs := []*mvccpb.KeyValue{}
+	for k, history := range s.history {
+		if !inRange([]byte(k), key, rangeEnd) {
+			continue
+		}
+		// Find the last revision of the key before or at the given revision, if it is not a delete.
+		i := sort.Search(len(history), func(i int) bool { return history[i].ModRevision > revision })
+		if i == 0 || history[i-1].Version == 0 {
+			continue
+		}
+		kvs = append(kvs, history[i-1])
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return kvs
+}
+
+// checkRevisionLocked returns an error if the store can't serve a request at the given revision.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) checkRevisionLocked(revision int64) error {
+	if revision > s.revision {
+		return rpctypes.ErrGRPCFutureRev
+	}
+	if revision > 0 && revision < s.compactRevision {
+		return rpctypes.ErrGRPCCompacted
+	}
+	return nil
+}
+
+// compactLocked drops the revisions older than the given revision, except the ones required to serve
+// requests at the given revision.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) compactLocked(revision int64) error {
+	if revision > s.revision {
+		return rpctypes.ErrGRPCFutureRev
+	}
+	if revision <= s.compactRevision {
+		return rpctypes.ErrGRPCCompacted
+	}
+
+	for k, history := range s.history {
+		// Keep the last revision of the key before or at the compact revision, if it is not a delete.
+		i := sort.Search(len(history), func(i int) bool { return history[i].ModRevision > revision })
+		if i > 0 && history[i-1].Version != 0 {
+			i--
+		}
+		if i == len(history) {
+			delete(s.history, k)
+			continue
+		}
+		s.history[k] = append([]*mvccpb.KeyValue{}, history[i:]...)
+	}
+	s.compactRevision = revision
+	return nil
+}
+
+// grant creates a lease with the given ID and TTL in seconds; if the ID is 0, a new ID is generated.
+func (s *kvStore) grant(id, ttl int64) (*lease, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id == 0 {
+		for {
+			s.lastLeaseID++
+			if _, ok := s.leases[s.lastLeaseID]; !ok {
+				break
+			}
+		}
+		id = s.lastLeaseID
+	}
+	if _, ok := s.leases[id]; ok {
+		return nil, rpctypes.ErrGRPCLeaseExist
+	}
+
+	l := &lease{
+		id:     id,
+		ttl:    ttl,
+		expiry: time.Now().Add(time.Duration(ttl) * time.Second),
+		keys:   sets.Set[string]{},
+	}
+	l.timer = time.AfterFunc(time.Duration(ttl)*time.Second, func() {
+		_ = s.revoke(id)
+	})
+	s.leases[id] = l
+	return l, nil
+}
+
+// revoke deletes a lease and all the keys attached to it.
+func (s *kvStore) revoke(id int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l, ok := s.leases[id]
+	if !ok {
+		return rpctypes.ErrGRPCLeaseNotFound
+	}
+	l.timer.Stop()
+	delete(s.leases, id)
+
+	if l.keys.Len() == 0 {
+		return nil
+	}
+	// NOTE: As in etcd, all the keys attached to a lease are deleted in a single revision.
+	s.revision++
+	for _, k := range sets.List(l.keys) {
+		s.deleteLocked(s.revision, []byte(k))
+	}
+	s.publishLocked()
+	return nil
+}
+
+// keepAlive renews a lease, and returns its TTL.
+func (s *kvStore) keepAlive(id int64) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l, ok := s.leases[id]
+	if !ok {
+		return 0, rpctypes.ErrGRPCLeaseNotFound
+	}
+	l.expiry = time.Now().Add(time.Duration(l.ttl) * time.Second)
+	l.timer.Reset(time.Duration(l.ttl) * time.Second)
+	return l.ttl, nil
+}
+
+// timeToLive returns the remaining TTL of a lease in seconds, its granted TTL and the keys attached to it.
+func (s *kvStore) timeToLive(id int64) (int64, int64, [][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l, ok := s.leases[id]
+	if !ok {
+		return 0, 0, nil, rpctypes.ErrGRPCLeaseNotFound
+	}
+	keys := [][]byte{}
+	for _, k := range sets.List(l.keys) {
+		keys = append(keys, []byte(k))
+	}
+	remaining := int64(time.Until(l.expiry).Round(time.Second).Seconds())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, l.ttl, keys, nil
+}
+
+// leaseIDs returns the IDs of all the leases, sorted.
+func (s *kvStore) leaseIDs() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ids := []int64{}
+	for id := range s.leases {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// status returns the current revision and the size of the store in bytes.
+// NOTE: The size is computed as the size of an empty etcd db plus the size of all the keys and values.
+func (s *kvStore) status() (int64, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	size := int64(emptyDBSize)
+	for _, kv := range s.kvs {
+		size += int64(len(kv.Key) + len(kv.Value))
+	}
+	return s.revision, size
+}
+
+// inRange returns true if k is in the range defined by key and rangeEnd, using the etcd range semantic.
+func inRange(k, key, rangeEnd []byte) bool {
+	switch {
+	case len(rangeEnd) == 0:
+		return bytes.Equal(k, key)
+	case len(rangeEnd) == 1 && rangeEnd[0] == 0:
+		return bytes.Compare(k, key) >= 0
+	default:
+		return bytes.Compare(k, key) >= 0 && bytes.Compare(k, rangeEnd) < 0
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// invalidWatchID is the watch ID of the progress notifications sent in response to a progress request,
+// as defined by the etcd client.
+const invalidWatchID = -1
+
+// watchServer implements the WatchServer grpc server.
+type watchServer struct {
+	*baseServer
+}
+
+// Watch serves a watch stream; all the watchers created on the stream are notified about the changes of the
+// kvStore of the resource group, so multiple streams watching the same keys receive the same events.
+// NOTE: Watchers starting from an older revision receive the changes replayed from the history of the kvStore;
+// watchers starting from a compacted revision are cancelled.
+func (w *watchServer) Watch(stream pb.Watch_WatchServer) error {
+	resourceGroup, etcdMember, err := w.getResourceGroupAndMember(stream.Context())
+	if err != nil {
+		return err
+	}
+	store := w.getKVStore(resourceGroup)
+
+	ws := newWatchStream()
+	watchers := map[int64]*watcher{}
+	var nextWatchID int64
+	defer func() {
+		for _, watcher := range watchers {
+			store.removeWatcher(watcher)
+		}
+	}()
+
+	// NOTE: Requests are received in a separate goroutine, so events can be sent while waiting for requests.
+	reqCh := make(chan *pb.WatchRequest)
+	recvErrCh := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErrCh <- err
+				return
+			}
+			select {
+			case reqCh <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case err := <-recvErrCh:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case req := <-reqCh:
+			switch r := req.RequestUnion.(type) {
+			case *pb.WatchRequest_CreateRequest:
+				create := r.CreateRequest
+				w.log.V(4).Info("Etcd: Watch", "resourceGroup", resourceGroup, "etcdMember", etcdMember, "key", string(create.Key), "rangeEnd", string(create.RangeEnd), "startRevision", create.StartRevision)
+
+				id := create.WatchId
+				if id == 0 {
+					for watchers[nextWatchID] != nil {
+						nextWatchID++
+					}
+					id = nextWatchID
+					nextWatchID++
+				}
+				if _, ok := watchers[id]; ok {
+					ws.enqueue(&pb.WatchResponse{
+						Header:       &pb.ResponseHeader{Revision: store.currentRevision()},
+						WatchId:      id,
+						Created:      true,
+						Canceled:     true,
+						CancelReason: "mvcc: duplicate watch ID provided on the WatchStream",
+					})
+					continue
+				}
+
+				watcher := newWatcher(id, create, ws)
+				if store.addWatcher(watcher, create.StartRevision) {
+					watchers[id] = watcher
+				}
+			case *pb.WatchRequest_CancelRequest:
+				id := r.CancelRequest.WatchId
+				watcher, ok := watchers[id]
+				if !ok {
+					continue
+				}
+				store.removeWatcher(watcher)
+				delete(watchers, id)
+				ws.enqueue(&pb.WatchResponse{
+					Header:   &pb.ResponseHeader{Revision: store.currentRevision()},
+					WatchId:  id,
+					Canceled: true,
+				})
+			case *pb.WatchRequest_ProgressRequest:
+				ws.enqueue(&pb.WatchResponse{
+					Header:  &pb.ResponseHeader{Revision: store.currentRevision()},
+					WatchId: invalidWatchID,
+				})
+			}
+		case <-ws.ready:
+			for _, resp := range ws.dequeue() {
+				if err := stream.Send(resp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// watchStream buffers the responses for a watch stream, so writes to the kvStore are never blocked by slow clients.
+type watchStream struct {
+	lock      sync.Mutex
+	responses []*pb.WatchResponse
+	ready     chan struct{}
+}
+
+func newWatchStream() *watchStream {
+	return &watchStream{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// enqueue adds a response to be sent on the watch stream.
+func (s *watchStream) enqueue(resp *pb.WatchResponse) {
+	s.lock.Lock()
+	s.responses = append(s.responses, resp)
+	s.lock.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+// dequeue returns the responses to be sent on the watch stream, in order.
+func (s *watchStream) dequeue() []*pb.WatchResponse {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	responses := s.responses
+	s.responses = nil
+	return responses
+}
+
+// watcher watches a range of keys of the kvStore on behalf of a watch stream.
+type watcher struct {
+	id       int64
+	key      []byte
+	rangeEnd []byte
+	prevKV   bool
+	noPut    bool
+	noDelete bool
+
+	stream *watchStream
+}
+
+func newWatcher(id int64, req *pb.WatchCreateRequest, stream *watchStream) *watcher {
+	w := &watcher{
+		id:       id,
+		key:      req.Key,
+		rangeEnd: req.RangeEnd,
+		prevKV:   req.PrevKv,
+		stream:   stream,
+	}
+	for _, f := range req.Filters {
+		switch f {
+		case pb.WatchCreateRequest_NOPUT:
+			w.noPut = true
+		case pb.WatchCreateRequest_NODELETE:
+			w.noDelete = true
+		}
+	}
+	return w
+}
+
+// notify sends the events of a revision matching the watcher to its watch stream.
+func (w *watcher) notify(revision int64, events []*mvccpb.Event) {
+	var matching []*mvccpb.Event
+	for _, ev := range events {
+		if !inRange(ev.Kv.Key, w.key, w.rangeEnd) {
+			continue
+		}
+		if (ev.Type == mvccpb.PUT && w.noPut) || (ev.Type == mvccpb.DELETE && w.noDelete) {
+			continue
+		}
+		e := &mvccpb.Event{Type: ev.Type, Kv: copyKeyValue(ev.Kv)}
+		if w.prevKV && ev.PrevKv != nil {
+			e.PrevKv = copyKeyValue(ev.PrevKv)
+		}
+		matching = append(matching, e)
+	}
+	if len(matching) == 0 {
+		return
+	}
+	w.stream.enqueue(&pb.WatchResponse{
+		Header:  &pb.ResponseHeader{Revision: revision},
+		WatchId: w.id,
+		Events:  matching,
+	})
+}
+
+// addWatcher registers a watcher, so it gets notified about the changes starting from startRevision, and
+// returns true if the watcher has been registered.
+// NOTE: The created response is sent while holding the lock, so it is sent before any event for the watcher.
+func (s *kvStore) addWatcher(w *watcher, startRevision int64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	w.stream.enqueue(&pb.WatchResponse{
+		Header:  &pb.ResponseHeader{Revision: s.revision},
+		WatchId: w.id,
+		Created: true,
+	})
+
+	if startRevision > 0 && startRevision < s.compactRevision {
+		w.stream.enqueue(&pb.WatchResponse{
+			Header:          &pb.ResponseHeader{Revision: s.revision},
+			WatchId:         w.id,
+			Canceled:        true,
+			CompactRevision: s.compactRevision,
+		})
+		return false
+	}
+	if startRevision > 0 && startRevision <= s.revision {
+		w.notify(s.revision, s.eventsSinceLocked(startRevision))
+	}
+
+	s.watchers[w] = struct{}{}
+	return true
+}
+
+// eventsSinceLocked returns the events from the given revision to the current revision, rebuilt from the history
+// and sorted by revision and key.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) eventsSinceLocked(startRevision int64) []*mvccpb.Event {
+	var events []*mvccpb.Event
+	for _, history := range s.history {
+		for i, kv := range history {
+			if kv.ModRevision < startRevision {
+				continue
+			}
+			ev := &mvccpb.Event{Type: mvccpb.PUT, Kv: kv}
+			if kv.Version == 0 {
+				ev.Type = mvccpb.DELETE
+			}
+			if i > 0 && history[i-1].Version != 0 {
+				ev.PrevKv = history[i-1]
+			}
+			events = append(events, ev)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Kv.ModRevision != events[j].Kv.ModRevision {
+			return events[i].Kv.ModRevision < events[j].Kv.ModRevision
+		}
+		return bytes.Compare(events[i].Kv.Key, events[j].Kv.Key) < 0
+	})
+	return events
+}
+
+// removeWatcher unregisters a watcher.
+func (s *kvStore) removeWatcher(w *watcher) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.watchers, w)
+}
+
+// currentRevision returns the current revision of the store.
+func (s *kvStore) currentRevision() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.revision
+}
+
+// publishLocked notifies all the watchers about the changes of the current revision.
+// Note: s.lock must be locked before calling this method.
+func (s *kvStore) publishLocked() {
+	changes := s.changes
+	s.changes = nil
+	if len(changes) == 0 {
+		return
+	}
+
+	for w := range s.watchers {
+		w.notify(s.revision, changes)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// FaultConfig defines faults to be injected in API server requests for a workload cluster, e.g. for chaos testing.
+type FaultConfig struct {
+	// Latency is an artificial latency added to every request.
+	Latency time.Duration
+
+	// ErrorRate is the fraction of requests, between 0 and 1, failing with ErrorCode.
+	ErrorRate float64
+
+	// ErrorCode is the HTTP status code returned for failing requests; if not set, 500 is used.
+	ErrorCode int
+}
+
+// SetFaultInjection sets the faults to be injected in API server requests for a WorkloadClusterListener.
+// Setting an empty FaultConfig restores normal behavior.
+func (m *WorkloadClustersMux) SetFaultInjection(wclName string, cfg FaultConfig) error {
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return errors.Errorf("invalid error rate %v: it must be between 0 and 1", cfg.ErrorRate)
+	}
+	if cfg.ErrorCode != 0 && (cfg.ErrorCode < 400 || cfg.ErrorCode > 599) {
+		return errors.Errorf("invalid error code %d: it must be a 4xx or 5xx HTTP status code", cfg.ErrorCode)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before setting fault injection", wclName)
+	}
+	wcl.faultConfig = cfg
+	m.log.Info("Fault injection set for WorkloadClusterListener", "listenerName", wclName, "latency", cfg.Latency, "errorRate", cfg.ErrorRate, "errorCode", cfg.ErrorCode)
+	return nil
+}
+
+// getFaultConfig returns the faults to be injected in API server requests for a WorkloadClusterListener.
+func (m *WorkloadClustersMux) getFaultConfig(wclName string) FaultConfig {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return FaultConfig{}
+	}
+	return wcl.faultConfig
+}
+
+// injectFaults injects the faults defined in cfg in a request; it returns true if the request
+// has been failed and thus it should not be processed further.
+func injectFaults(cfg FaultConfig, w http.ResponseWriter, r *http.Request) bool {
+	if cfg.Latency > 0 {
+		select {
+		case <-time.After(cfg.Latency):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+
+	if cfg.ErrorRate <= 0 || rand.Float64() >= cfg.ErrorRate { //nolint:gosec // weak random numbers are ok for fault injection.
+		return false
+	}
+
+	code := cfg.ErrorCode
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	status := apierrors.NewGenericServerResponse(code, r.Method, schema.GroupResource{}, "", "injected fault", 0, false).ErrStatus
+	status.APIVersion = "v1"
+	status.Kind = "Status"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+	return true
+}
+
+// SetEtcdMemberHealth sets the health of an etcd member behind a WorkloadClusterListener;
+// while an etcd member is unhealthy, all the requests targeting it fail with an Unavailable error.
+func (m *WorkloadClustersMux) SetEtcdMemberHealth(wclName, podName string, healthy bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		m.log.Info("Failed to set etcd member health, WorkloadClusterListener does not exist", "listenerName", wclName, "podName", podName)
+		return
+	}
+	if healthy {
+		wcl.unhealthyEtcdMembers.Delete(podName)
+	} else {
+		wcl.unhealthyEtcdMembers.Insert(podName)
+	}
+	m.log.Info("Etcd member health set for WorkloadClusterListener", "listenerName", wclName, "podName", podName, "healthy", healthy)
+}
+
+// isEtcdMemberHealthy returns true if an etcd member behind a WorkloadClusterListener is healthy.
+func (m *WorkloadClustersMux) isEtcdMemberHealthy(wclName, podName string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return true
+	}
+	return !wcl.unhealthyEtcdMembers.Has(podName)
+}
+
+// SetAPIServerHealth sets the health of the API servers behind a WorkloadClusterListener;
+// while API servers are unhealthy, all the API server requests fail with 503 Service Unavailable,
+// but the listener keeps running.
+func (m *WorkloadClustersMux) SetAPIServerHealth(wclName string, healthy bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		m.log.Info("Failed to set API server health, WorkloadClusterListener does not exist", "listenerName", wclName)
+		return
+	}
+	wcl.apiServerUnhealthy = !healthy
+	m.log.Info("API server health set for WorkloadClusterListener", "listenerName", wclName, "healthy", healthy)
+}
+
+// isAPIServerHealthy returns true if the API servers behind a WorkloadClusterListener are healthy.
+func (m *WorkloadClustersMux) isAPIServerHealthy(wclName string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return true
+	}
+	return !wcl.apiServerUnhealthy
+}
+
+// writeServiceUnavailable writes a 503 Service Unavailable Status response.
+func writeServiceUnavailable(w http.ResponseWriter, r *http.Request, message string) {
+	status := apierrors.NewGenericServerResponse(http.StatusServiceUnavailable, r.Method, schema.GroupResource{}, "", message, 0, false).ErrStatus
+	status.APIVersion = "v1"
+	status.Kind = "Status"
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// writeGRPCUnavailable writes a gRPC response without body failing with an Unavailable error.
+func writeGRPCUnavailable(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
@@ -17,11 +17,16 @@ limitations under the License.
 package server
 
 import (
+	"context"
+	"crypto"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"net"
+	"net/http"
+	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -39,6 +44,9 @@ type WorkloadClusterListener struct {
 	host string
 	port int
 
+	// socketPath is the path of the Unix domain socket used by the listener instead of host:port, if any.
+	socketPath string
+
 	scheme *runtime.Scheme
 
 	apiServers                  sets.Set[string]
@@ -46,13 +54,52 @@ type WorkloadClusterListener struct {
 	apiServerCaKey              *rsa.PrivateKey
 	apiServerServingCertificate *tls.Certificate
 
+	// insecure is true if the listener serves plain HTTP instead of HTTPS, see AddAPIServerInsecure.
+	insecure bool
+
+	// apiServerExtraSANs are additional DNS names and IPs to be included in the API server serving certificate.
+	apiServerExtraSANs sets.Set[string]
+
 	adminCertificate *x509.Certificate
-	adminKey         *rsa.PrivateKey
+	adminKey         crypto.Signer
 
 	etcdMembers             sets.Set[string]
+	etcdCaCertificate       *x509.Certificate
+	etcdCaKey               *rsa.PrivateKey
 	etcdServingCertificates map[string]*tls.Certificate
 
 	listener net.Listener
+	server   *http.Server
+
+	// reservedListener is the listener bound to an ephemeral port when initializing the WorkloadClusterListener,
+	// it is used when the listener is started.
+	reservedListener net.Listener
+
+	// idleSince is the time since the listener has a port reserved but no API server.
+	idleSince time.Time
+
+	// preallocated is true if the listener has been preallocated and not used yet.
+	preallocated bool
+
+	// faultConfig defines faults to be injected in API server requests.
+	faultConfig FaultConfig
+
+	// unhealthyEtcdMembers are the etcd members failing all the requests.
+	unhealthyEtcdMembers sets.Set[string]
+
+	// apiServerUnhealthy is true if all the API server requests must fail.
+	apiServerUnhealthy bool
+
+	// desiredAPIServers is the desired number of API server instances, if set.
+	desiredAPIServers *int
+
+	// requestStats tracks the requests served by the listener.
+	requestStats *requestStats
+
+	// provisioningLock serializes the operations provisioning the listener, e.g. adding API servers or etcd members,
+	// so certificates can be generated without holding the lock of the workload clusters mux.
+	// NOTE: provisioningLock must be acquired before the lock of the workload clusters mux, never while holding it.
+	provisioningLock sync.Mutex
 }
 
 // Host returns the host of a WorkloadClusterListener.
@@ -65,22 +112,105 @@ func (s *WorkloadClusterListener) Port() int {
 	return s.port
 }
 
+// SocketPath returns the path of the Unix domain socket of a WorkloadClusterListener, if any.
+func (s *WorkloadClusterListener) SocketPath() string {
+	return s.socketPath
+}
+
+// Network returns the network of a WorkloadClusterListener, either tcp or unix.
+func (s *WorkloadClusterListener) Network() string {
+	if s.socketPath != "" {
+		return "unix"
+	}
+	return "tcp"
+}
+
 // Address returns the address of a WorkloadClusterListener.
+// NOTE: For listeners using a Unix domain socket, the address is unix://<socket path>; for listeners serving
+// plain HTTP, the address is http://<host port>.
 func (s *WorkloadClusterListener) Address() string {
+	if s.socketPath != "" {
+		return fmt.Sprintf("unix://%s", s.socketPath)
+	}
+	if s.insecure {
+		return fmt.Sprintf("http://%s", s.HostPort())
+	}
 	return fmt.Sprintf("https://%s", s.HostPort())
 }
 
 // HostPort returns the host port of a WorkloadClusterListener.
+// NOTE: For listeners using a Unix domain socket, the socket path is returned.
 func (s *WorkloadClusterListener) HostPort() string {
+	if s.socketPath != "" {
+		return s.socketPath
+	}
 	return net.JoinHostPort(s.host, fmt.Sprintf("%d", s.port))
 }
 
+// Addr returns the network address of a WorkloadClusterListener, a *net.UnixAddr for listeners using a Unix domain
+// socket, a *net.TCPAddr otherwise.
+// NOTE: The address is computed from the host and the port reserved for the listener, which are the ones the listener
+// is bound to once started; if the host is not an IP address, it is resolved, and the IP is nil if it can't be resolved.
+func (s *WorkloadClusterListener) Addr() net.Addr {
+	if s.socketPath != "" {
+		return &net.UnixAddr{Name: s.socketPath, Net: "unix"}
+	}
+	if ip := net.ParseIP(s.host); ip != nil {
+		return &net.TCPAddr{IP: ip, Port: s.port}
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", s.HostPort()); err == nil {
+		return addr
+	}
+	return &net.TCPAddr{Port: s.port}
+}
+
 // RESTConfig returns the rest config for a WorkloadClusterListener.
 func (s *WorkloadClusterListener) RESTConfig() (*rest.Config, error) {
-	kubeConfig := clientcmdapi.Config{
+	server := s.Address()
+	if s.socketPath != "" {
+		// NOTE: localhost is included in the API server serving certificate.
+		server = "https://localhost"
+		if s.insecure {
+			server = "http://localhost"
+		}
+	}
+
+	kubeConfig := s.kubeConfig(server)
+	b, err := clientcmd.Write(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.socketPath != "" {
+		socketPath := s.socketPath
+		restConfig.Dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		}
+	}
+
+	return restConfig, nil
+}
+
+// kubeConfig returns a kubeconfig for a WorkloadClusterListener using the admin certificate and the given server.
+// NOTE: For listeners serving plain HTTP, the kubeconfig does not include certificates.
+func (s *WorkloadClusterListener) kubeConfig(server string) clientcmdapi.Config {
+	if s.insecure {
+		return clientcmdapi.Config{
+			Clusters:       map[string]*clientcmdapi.Cluster{"in-memory": {Server: server}},
+			AuthInfos:      map[string]*clientcmdapi.AuthInfo{"in-memory": {Username: "in-memory"}},
+			Contexts:       map[string]*clientcmdapi.Context{"in-memory": {Cluster: "in-memory", AuthInfo: "in-memory"}},
+			CurrentContext: "in-memory",
+		}
+	}
+	return clientcmdapi.Config{
 		Clusters: map[string]*clientcmdapi.Cluster{
 			"in-memory": {
-				Server:                   s.Address(),
+				Server:                   server,
 				CertificateAuthorityData: certs.EncodeCertPEM(s.apiServerCaCertificate), // TODO: convert to PEM (store in double format
 			},
 		},
@@ -88,7 +218,7 @@ func (s *WorkloadClusterListener) RESTConfig() (*rest.Config, error) {
 			"in-memory": {
 				Username:              "in-memory",
 				ClientCertificateData: certs.EncodeCertPEM(s.adminCertificate), // TODO: convert to PEM
-				ClientKeyData:         certs.EncodePrivateKeyPEM(s.adminKey),   // TODO: convert to PEM
+				ClientKeyData:         encodePrivateKeyPEM(s.adminKey),         // TODO: convert to PEM
 			},
 		},
 		Contexts: map[string]*clientcmdapi.Context{
@@ -99,18 +229,6 @@ func (s *WorkloadClusterListener) RESTConfig() (*rest.Config, error) {
 		},
 		CurrentContext: "in-memory",
 	}
-
-	b, err := clientcmd.Write(kubeConfig)
-	if err != nil {
-		return nil, err
-	}
-
-	restConfig, err := clientcmd.RESTConfigFromKubeConfig(b)
-	if err != nil {
-		return nil, err
-	}
-
-	return restConfig, nil
 }
 
 // GetClient returns a client for a WorkloadClusterListener.
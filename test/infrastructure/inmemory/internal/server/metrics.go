@@ -0,0 +1,46 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+func init() {
+	// Register the metrics at the controller-runtime metrics registry.
+	ctrlmetrics.Registry.MustRegister(listenersActive)
+	ctrlmetrics.Registry.MustRegister(portsFree)
+	ctrlmetrics.Registry.MustRegister(requestTotal)
+}
+
+var (
+	listenersActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capim_mux_listeners_active",
+		Help: "Number of workload cluster listeners currently serving.",
+	}, []string{"mux"})
+
+	portsFree = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "capim_mux_ports_free",
+		Help: "Number of ports still available for workload cluster listeners.",
+	}, []string{"mux"})
+
+	requestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "capim_mux_requests_total",
+		Help: "Number of requests served by the workload clusters mux.",
+	}, []string{"mux", "cluster_name", "handler"})
+)
@@ -18,24 +18,37 @@ package server
 
 import (
 	"context"
+	"crypto"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	infrav1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/api/v1alpha1"
@@ -56,6 +69,24 @@ const (
 	DefaultMinPort = 20000
 	// DefaultMaxPort default max port of the workload clusters mux.
 	DefaultMaxPort = 24000
+
+	// DefaultReadHeaderTimeout default timeout for reading request headers in the servers of the workload clusters mux.
+	DefaultReadHeaderTimeout = 32 * time.Second
+)
+
+var (
+	// ErrListenerNotFound is returned when a WorkloadClusterListener is not initialized, or when it is not
+	// possible to resolve the WorkloadClusterListener serving a TLS connection.
+	// NOTE: This is the same error as api.ErrListenerNotFound, so the debug server can detect it.
+	ErrListenerNotFound = api.ErrListenerNotFound
+
+	// ErrCertificateNotReady is returned when the serving certificate for a TLS connection is not generated yet,
+	// e.g. because no API server or no etcd member with the requested server name has been added.
+	ErrCertificateNotReady = errors.New("serving certificate not ready")
+
+	// ErrCertificateAuthorityMismatch is returned when adding an API server or an etcd member with a CA different
+	// from the one used by the other API servers or etcd members of the same workload cluster.
+	ErrCertificateAuthorityMismatch = errors.New("certificate authority mismatch")
 )
 
 // WorkloadClustersMuxOption define an option for the WorkloadClustersMux creation.
@@ -68,6 +99,79 @@ type WorkloadClustersMuxOptions struct {
 	MinPort   int
 	MaxPort   int
 	DebugPort int
+
+	// DebugTimeout is the timeout for collecting the info served by the debug server.
+	DebugTimeout time.Duration
+
+	// ReadHeaderTimeout is the amount of time allowed to read request headers, preventing slow clients from
+	// holding connections indefinitely.
+	ReadHeaderTimeout time.Duration
+
+	// IdleListenerTimeout is the time after which listeners that have a port reserved but no API server
+	// are deleted, releasing their ports. If not set, idle listeners are never deleted.
+	IdleListenerTimeout time.Duration
+
+	// Clock is the clock used by the workload clusters mux.
+	Clock clock.WithTicker
+
+	// CertificateValidity is the validity period of the certificates generated by the workload clusters mux.
+	CertificateValidity time.Duration
+
+	// ClientCertVerification requires clients of the API server to present a certificate signed by the API server CA
+	// of the workload cluster.
+	ClientCertVerification bool
+
+	// MaxConnsPerListener is the maximum number of simultaneous connections accepted by each workload cluster
+	// listener; excess connections wait until one of the existing connections is closed.
+	// If not set, the number of connections is not limited.
+	MaxConnsPerListener int
+
+	// UnixSocketsDir is the directory where Unix domain sockets are created for the workload cluster listeners;
+	// if set, Unix domain sockets are used instead of TCP ports.
+	UnixSocketsDir string
+
+	// ListenerEventHandler is called when a workload cluster listener is started or stopped.
+	ListenerEventHandler func(ListenerEvent)
+
+	// TracerProvider is used to create spans for the requests served by the workload clusters mux.
+	TracerProvider trace.TracerProvider
+
+	// Logger is the logger used by the workload clusters mux.
+	Logger logr.Logger
+
+	// TLSConfigOptions customize the TLS config of the workload clusters listeners, e.g. the minimum TLS version
+	// or the cipher suites; they are applied after the defaults are set.
+	TLSConfigOptions []func(*tls.Config)
+
+	// HandlerMiddlewares wrap the handler serving requests for all the workload clusters, e.g. for
+	// request logging, fault injection or latency simulation. The first middleware is the outermost one.
+	HandlerMiddlewares []func(http.Handler) http.Handler
+
+	// DryRun configures the workload clusters mux to not bind any network listener, see WithDryRun.
+	DryRun bool
+
+	// ResourceGroupResolver identifies the resource group targeted by a request from the local address
+	// of the connection, see WithResourceGroupResolver.
+	ResourceGroupResolver func(host string) (string, error)
+
+	// CertificateFactory generates the certificates of the workload clusters mux, see WithCertificateFactory.
+	CertificateFactory CertificateFactory
+
+	// EtcdServiceName is an additional DNS name to be included in the etcd serving certificates,
+	// e.g. the name of the etcd service used by in-cluster clients.
+	EtcdServiceName string
+
+	// EphemeralPorts configures the workload clusters mux to use ports assigned by the OS instead of
+	// ports from the port range, see WithEphemeralPorts.
+	EphemeralPorts bool
+
+	// TCPKeepAlive is the keep-alive period for the connections accepted by the workload cluster listeners,
+	// see WithTCPKeepAlive.
+	TCPKeepAlive time.Duration
+
+	// WatchBookmarkInterval is the interval for sending bookmark events on watches served by the API servers,
+	// see WithWatchBookmarkInterval.
+	WatchBookmarkInterval time.Duration
 }
 
 // ApplyOptions applies WorkloadClustersMuxOption to the current WorkloadClustersMuxOptions.
@@ -79,6 +183,8 @@ func (o *WorkloadClustersMuxOptions) ApplyOptions(opts []WorkloadClustersMuxOpti
 }
 
 // CustomPorts allows to customize the ports used by the workload clusters mux.
+//
+// Deprecated: use WithPortRange and WithDebugPort instead; CustomPorts is implemented using them.
 type CustomPorts struct {
 	MinPort   int
 	MaxPort   int
@@ -87,13 +193,291 @@ type CustomPorts struct {
 
 // Apply applies this configuration to the given WorkloadClustersMuxOptions.
 func (c CustomPorts) Apply(options *WorkloadClustersMuxOptions) {
-	options.MinPort = c.MinPort
-	options.MaxPort = c.MaxPort
-	options.DebugPort = c.DebugPort
+	WithPortRange(c.MinPort, c.MaxPort).Apply(options)
+	WithDebugPort(c.DebugPort).Apply(options)
+}
+
+// workloadClustersMuxOptionFunc allows to use a func as a WorkloadClustersMuxOption.
+type workloadClustersMuxOptionFunc func(*WorkloadClustersMuxOptions)
+
+// Apply applies this configuration to the given WorkloadClustersMuxOptions.
+func (f workloadClustersMuxOptionFunc) Apply(options *WorkloadClustersMuxOptions) {
+	f(options)
+}
+
+// WithPortRange sets the range of ports used by the workload clusters listeners.
+func WithPortRange(minPort, maxPort int) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.MinPort = minPort
+		options.MaxPort = maxPort
+	})
+}
+
+// WithDebugPort sets the port used by the debug server of the workload clusters mux.
+func WithDebugPort(debugPort int) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.DebugPort = debugPort
+	})
+}
+
+// WithDebugTimeout sets the timeout for collecting the info served by the debug server of the workload clusters mux;
+// if the timeout expires, e.g. because the mux is busy or degraded, the debug server responds with 503 Service Unavailable.
+func WithDebugTimeout(timeout time.Duration) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.DebugTimeout = timeout
+	})
+}
+
+// validate validates WorkloadClustersMuxOptions.
+func (o *WorkloadClustersMuxOptions) validate() error {
+	if o.MinPort < 1 || o.MaxPort > 65535 {
+		return errors.Errorf("invalid port range %d-%d: ports must be in the 1-65535 range", o.MinPort, o.MaxPort)
+	}
+	if o.MinPort > o.MaxPort {
+		return errors.Errorf("invalid port range %d-%d: min port must be less than or equal to max port", o.MinPort, o.MaxPort)
+	}
+	if o.DebugPort < 1 || o.DebugPort > 65535 {
+		return errors.Errorf("invalid debug port %d: port must be in the 1-65535 range", o.DebugPort)
+	}
+	if o.DebugPort >= o.MinPort && o.DebugPort <= o.MaxPort {
+		return errors.Errorf("invalid debug port %d: port must not be in the %d-%d port range", o.DebugPort, o.MinPort, o.MaxPort)
+	}
+	if o.DebugTimeout <= 0 {
+		return errors.Errorf("invalid debug timeout %s: it must be greater than zero", o.DebugTimeout)
+	}
+	if o.MaxConnsPerListener < 0 {
+		return errors.Errorf("invalid max connections per listener %d: it must be greater than or equal to zero", o.MaxConnsPerListener)
+	}
+	if o.CertificateValidity <= 0 {
+		return errors.Errorf("invalid certificate validity %s: it must be greater than zero", o.CertificateValidity)
+	}
+	if o.TracerProvider == nil {
+		return errors.New("invalid tracer provider: it must not be nil")
+	}
+	if o.CertificateFactory == nil {
+		return errors.New("invalid certificate factory: it must not be nil")
+	}
+	return nil
+}
+
+// WithReadHeaderTimeout sets the amount of time allowed to read request headers in the servers of the workload clusters mux.
+func WithReadHeaderTimeout(timeout time.Duration) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.ReadHeaderTimeout = timeout
+	})
+}
+
+// WithIdleListenerTimeout enables a background reaper deleting listeners that remain with a port
+// reserved but without API servers for longer than the given timeout.
+func WithIdleListenerTimeout(timeout time.Duration) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.IdleListenerTimeout = timeout
+	})
+}
+
+// WithClock allows to inject the clock used by the workload clusters mux, e.g. a fake clock in tests.
+// The clock is used also for generating certificates, for verifying client certificates during TLS handshakes
+// and by VerifyCertificates, so tests can move the clock past certificate expiry instead of waiting for it.
+func WithClock(c clock.WithTicker) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.Clock = c
+	})
+}
+
+// WithCertificateValidity sets the validity period of the certificates generated by the workload clusters mux,
+// e.g. to mint short-lived certificates in tests exercising certificate expiry.
+func WithCertificateValidity(validity time.Duration) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.CertificateValidity = validity
+	})
+}
+
+// WithClientCertVerification requires clients to present a certificate signed by the CA of the workload cluster
+// being targeted; the CA is selected using the server name (SNI) of the TLS handshake: requests targeting an etcd
+// member, i.e. with the name of an etcd pod as server name, are verified using the etcd CA, while all the other
+// requests are verified using the API server CA, like e.g. the admin certificate.
+func WithClientCertVerification() WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.ClientCertVerification = true
+	})
+}
+
+// WithMaxConnsPerListener limits the number of simultaneous connections accepted by each workload cluster listener;
+// excess connections wait until one of the existing connections is closed.
+func WithMaxConnsPerListener(n int) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.MaxConnsPerListener = n
+	})
+}
+
+// WithTLSConfig adds a func customizing the TLS config of the workload clusters listeners, e.g. to set the minimum
+// TLS version or to restrict the cipher suites. The func is applied after the defaults are set.
+// NOTE: The func should not change GetCertificate, which is used to select certificates for each workload cluster.
+func WithTLSConfig(opt func(*tls.Config)) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.TLSConfigOptions = append(options.TLSConfigOptions, opt)
+	})
+}
+
+// WithHandlerMiddleware adds a middleware wrapping the handler serving requests for all the workload clusters,
+// e.g. for request logging, fault injection or latency simulation.
+// NOTE: Middlewares are applied in the order they are added, the first one being the outermost; they wrap both
+// the API server and the etcd handlers.
+func WithHandlerMiddleware(middleware func(http.Handler) http.Handler) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.HandlerMiddlewares = append(options.HandlerMiddlewares, middleware)
+	})
+}
+
+// WithUnixSockets configures the workload clusters mux to use Unix domain sockets instead of TCP ports,
+// e.g. for CI environments that forbid binding TCP ports. The socket for each workload cluster is
+// created in dir as <wclName>.sock, with slashes in wclName replaced by underscores.
+// NOTE: Certificates are resolved using the socket path, which is the local address of the connections.
+// NOTE: Clients must dial the socket, see WorkloadClusterListener.RESTConfig.
+// NOTE: HotRestart is not supported with Unix domain sockets.
+func WithUnixSockets(dir string) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.UnixSocketsDir = dir
+	})
+}
+
+// WithDryRun configures the workload clusters mux to not bind any network listener, e.g. for unit tests
+// of controllers that only need ports and certificates to be handed out.
+// AddAPIServer reserves the port, generates certificates and records the API server instances as usual,
+// but the listener is not started; also the debug server is not started.
+// NOTE: Requests are not served in this mode, so clients can't connect to the workload clusters;
+// WaitForListener returns immediately.
+// NOTE: Ports are reserved without checking if they are bound by other processes.
+func WithDryRun() WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.DryRun = true
+	})
+}
+
+// WithResourceGroupResolver configures the func used to identify the resource group targeted by a request
+// from the local address of the connection (host:port, or the socket path for Unix domain sockets),
+// so the name of a listener and the name of the corresponding resource group can differ.
+// If not set, the resource group has the same name of the listener serving the request.
+// NOTE: Faults, health and metrics of the workload clusters mux still refer to listener names.
+func WithResourceGroupResolver(resolver func(host string) (string, error)) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.ResourceGroupResolver = resolver
+	})
+}
+
+// ListenerEventType is the type of a ListenerEvent.
+type ListenerEventType string
+
+const (
+	// ListenerStarted is the type of the event fired when a workload cluster listener is confirmed serving.
+	ListenerStarted ListenerEventType = "Started"
+
+	// ListenerStopped is the type of the event fired when a workload cluster listener is stopped.
+	ListenerStopped ListenerEventType = "Stopped"
+)
+
+// ListenerEvent is an event fired when a workload cluster listener is started or stopped.
+type ListenerEvent struct {
+	ListenerName string
+	Address      string
+	Type         ListenerEventType
+}
+
+// ListenerError reports a workload cluster listener whose serve loop exited unexpectedly.
+type ListenerError struct {
+	ListenerName string
+	Err          error
+}
+
+// Error implements error.
+func (e ListenerError) Error() string {
+	return fmt.Sprintf("WorkloadClusterListener %s failed: %v", e.ListenerName, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e ListenerError) Unwrap() error {
+	return e.Err
+}
+
+// tracerName is the name of the tracer used by the workload clusters mux.
+const tracerName = "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/server"
+
+// listenerErrorsBufferSize is the size of the buffer of the channel returned by ListenerErrors.
+const listenerErrorsBufferSize = 100
+
+// WithTracerProvider sets the TracerProvider used to create a span for each request served by the workload clusters mux.
+// If not set, a no-op TracerProvider is used.
+func WithTracerProvider(tp trace.TracerProvider) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.TracerProvider = tp
+	})
+}
+
+// WithListenerEventHandler sets a func to be called when a workload cluster listener is started or stopped, e.g.
+// to orchestrate external components depending on the workload cluster endpoint without polling ListListeners.
+// NOTE: The handler is called synchronously and without holding the lock of the workload clusters mux.
+func WithListenerEventHandler(handler func(ListenerEvent)) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.ListenerEventHandler = handler
+	})
+}
+
+// WithEtcdServiceName sets an additional DNS name to be included in the etcd serving certificates, so
+// in-cluster etcd clients connecting via a service name can validate them as well as port-forwarded clients.
+func WithEtcdServiceName(name string) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.EtcdServiceName = name
+	})
+}
+
+// WithCertificateFactory sets the func used to generate certificates, e.g. to use precomputed or ECDSA keys
+// and speed up large stress tests; by default, certificates are generated using RSA keys.
+func WithCertificateFactory(factory func(cfg CertConfig) (*x509.Certificate, crypto.Signer, error)) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.CertificateFactory = factory
+	})
+}
+
+// WithLogger sets the logger used by the workload clusters mux.
+func WithLogger(log logr.Logger) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.Logger = log
+	})
+}
+
+// WithEphemeralPorts configures the workload clusters mux to bind port 0 when initializing workload cluster listeners
+// and to use the port assigned by the OS, e.g. for tests that don't need deterministic ports; this avoids collisions
+// with other processes and makes the port range irrelevant.
+// NOTE: The port stays bound from when the listener is initialized until it is started; when the listener is stopped
+// the port is released, and starting the listener again binds the same port if it is still available.
+// NOTE: Ports from the port range are used in dry-run mode, with Unix domain sockets the option has no effect.
+func WithEphemeralPorts() WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.EphemeralPorts = true
+	})
+}
+
+// WithTCPKeepAlive sets the keep-alive period for the connections accepted by the workload cluster listeners,
+// e.g. to detect dead clients faster in long-running or high-throughput tests; if zero, keep-alives are enabled
+// with the default period of the net package, if negative, keep-alives are disabled.
+// NOTE: The option has no effect on listeners using Unix domain sockets.
+func WithTCPKeepAlive(period time.Duration) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.TCPKeepAlive = period
+	})
+}
+
+// WithWatchBookmarkInterval sets the interval for sending bookmark events on watches served by the API servers
+// and requesting them with allowWatchBookmarks=true, e.g. to exercise bookmark handling in clients with a short
+// interval; if zero or negative, bookmarks are never sent. Default is api.DefaultWatchBookmarkInterval.
+func WithWatchBookmarkInterval(interval time.Duration) WorkloadClustersMuxOption {
+	return workloadClustersMuxOptionFunc(func(options *WorkloadClustersMuxOptions) {
+		options.WatchBookmarkInterval = interval
+	})
 }
 
 // WorkloadClustersMux implements a server that handles requests for multiple workload clusters.
-// Each workload clusters will get its own listener, serving on a dedicated port, eg.
+// Each workload clusters will get its own listener and http.Server, serving on a dedicated port, eg.
 // wkl-cluster-1 >> :20000, wkl-cluster-2 >> :20001 etc.
 // Each workload cluster will act both as API server and as etcd for the cluster; the
 // WorkloadClustersMux is also responsible for handling certificates for each of the above use cases.
@@ -102,15 +486,52 @@ type WorkloadClustersMux struct {
 	minPort   int // TODO: move port management to a port range type
 	maxPort   int
 	portIndex int
+	// freePorts are ports below portIndex which have been released and can be reused.
+	freePorts sets.Set[int]
+	// busyPorts are ports below portIndex which have been skipped because in use by other processes;
+	// they are not free, but they can be used if they become available later.
+	busyPorts sets.Set[int]
 
 	manager cmanager.Manager // TODO: figure out if we can have a smaller interface (GetResourceGroup, GetSchema)
 
-	debugServer              http.Server
-	muxServer                http.Server
+	debugServer http.Server
+	// muxHandler and muxTLSConfig are shared by the servers of all the workload clusters listeners;
+	// apiHandler is the API server handler used by muxHandler.
+	muxHandler               http.Handler
+	muxTLSConfig             *tls.Config
+	apiHandler               *api.APIServerHandler
+	readHeaderTimeout        time.Duration
 	workloadClusterListeners map[string]*WorkloadClusterListener
 	// workloadClusterNameByHost maps from Host to workload cluster name.
 	workloadClusterNameByHost map[string]string
 
+	idleListenerTimeout time.Duration
+	clock               clock.WithTicker
+	stopCh              chan struct{}
+
+	// goroutines tracks the goroutines serving the listeners and the idle listener reaper,
+	// so Shutdown can wait for them to exit.
+	goroutines sync.WaitGroup
+
+	certificateValidity  time.Duration
+	maxConnsPerListener  int
+	listenerEventHandler func(ListenerEvent)
+	listenerErrors       chan ListenerError
+	unixSocketsDir       string
+	tracer               trace.Tracer
+
+	preallocatedListenersIndex int
+	dryRun                     bool
+	etcdServiceName            string
+	certificateFactory         CertificateFactory
+	ephemeralPorts             bool
+	tcpKeepAlive               time.Duration
+	watchBookmarkInterval      time.Duration
+
+	// metricsID identifies the workload clusters mux in the metrics, so metrics of different workload clusters mux
+	// in the same process do not overwrite each other; the address of the debug server is used, because it is unique.
+	metricsID string
+
 	lock sync.RWMutex
 	log  logr.Logger
 }
@@ -118,57 +539,168 @@ type WorkloadClustersMux struct {
 // NewWorkloadClustersMux returns a WorkloadClustersMux that handles requests for multiple workload clusters.
 func NewWorkloadClustersMux(manager cmanager.Manager, host string, opts ...WorkloadClustersMuxOption) (*WorkloadClustersMux, error) {
 	options := WorkloadClustersMuxOptions{
-		MinPort:   DefaultMinPort,
-		MaxPort:   DefaultMaxPort,
-		DebugPort: DefaultDebugPort,
+		MinPort:             DefaultMinPort,
+		MaxPort:             DefaultMaxPort,
+		DebugPort:           DefaultDebugPort,
+		DebugTimeout:        api.DefaultDebugTimeout,
+		ReadHeaderTimeout:   DefaultReadHeaderTimeout,
+		Clock:               clock.RealClock{},
+		CertificateValidity: certs.DefaultCertDuration,
+		TracerProvider:      trace.NewNoopTracerProvider(),
+		Logger:              log.Log,
+		CertificateFactory:  defaultCertificateFactory,
+
+		WatchBookmarkInterval: api.DefaultWatchBookmarkInterval,
 	}
 	options.ApplyOptions(opts)
+	if err := options.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid options for workload cluster mux")
+	}
+
+	// Normalize IP addresses, so host:port keys match the local address of accepted connections,
+	// e.g. "0:0:0:0:0:0:0:1" becomes "::1" and it is then formatted as "[::1]:port".
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
 
 	m := &WorkloadClustersMux{
 		host:                      host,
 		minPort:                   options.MinPort,
 		maxPort:                   options.MaxPort,
 		portIndex:                 options.MinPort,
+		freePorts:                 sets.Set[int]{},
+		busyPorts:                 sets.Set[int]{},
 		manager:                   manager,
 		workloadClusterListeners:  map[string]*WorkloadClusterListener{},
 		workloadClusterNameByHost: map[string]string{},
-		log:                       log.Log,
-	}
-
-	//nolint:gosec // Ignoring the following for now: "G112: Potential Slowloris Attack because ReadHeaderTimeout is not configured in the http.Server (gosec)"
-	m.muxServer = http.Server{
-		// Use an handler that can serve either API server calls or etcd calls.
-		Handler: m.mixedHandler(),
-		// Use a TLS config that selects certificates for a specific cluster depending on
-		// the request being processed (API server and etcd have different certificates).
-		TLSConfig: &tls.Config{
-			GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
-				return m.getCertificate(info)
-			},
-			MinVersion: tls.VersionTLS12,
+		readHeaderTimeout:         options.ReadHeaderTimeout,
+		idleListenerTimeout:       options.IdleListenerTimeout,
+		clock:                     options.Clock,
+		stopCh:                    make(chan struct{}),
+		certificateValidity:       options.CertificateValidity,
+		maxConnsPerListener:       options.MaxConnsPerListener,
+		listenerEventHandler:      options.ListenerEventHandler,
+		listenerErrors:            make(chan ListenerError, listenerErrorsBufferSize),
+		unixSocketsDir:            options.UnixSocketsDir,
+		tracer:                    options.TracerProvider.Tracer(tracerName),
+		dryRun:                    options.DryRun,
+		etcdServiceName:           options.EtcdServiceName,
+		certificateFactory:        options.CertificateFactory,
+		ephemeralPorts:            options.EphemeralPorts,
+		tcpKeepAlive:              options.TCPKeepAlive,
+		watchBookmarkInterval:     options.WatchBookmarkInterval,
+		metricsID:                 net.JoinHostPort(host, fmt.Sprintf("%d", options.DebugPort)),
+		log:                       options.Logger,
+	}
+
+	// Use an handler that can serve either API server calls or etcd calls.
+	m.muxHandler = m.mixedHandler(options.ResourceGroupResolver, options.HandlerMiddlewares)
+	// Use a TLS config that selects certificates for a specific cluster depending on
+	// the request being processed (API server and etcd have different certificates).
+	// NOTE: Session tickets are enabled, so clients configured with a tls.ClientSessionCache can resume TLS sessions
+	// instead of doing a full handshake for each new connection; the session ticket keys of this config are used
+	// also for the configs returned by getConfigForClient, so sessions can be resumed across connections.
+	m.muxTLSConfig = &tls.Config{
+		GetCertificate: func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.getCertificate(info)
 		},
+		MinVersion: tls.VersionTLS12,
+		// NOTE: Client certificates are verified using the clock of the workload clusters mux, so tests using
+		// a fake clock can check that expired certificates are rejected.
+		Time: m.clock.Now,
+	}
+	if options.ClientCertVerification {
+		m.muxTLSConfig.GetConfigForClient = m.getConfigForClient
+	}
+	for _, opt := range options.TLSConfigOptions {
+		opt(m.muxTLSConfig)
 	}
 
-	//nolint:gosec // Ignoring the following for now: "G112: Potential Slowloris Attack because ReadHeaderTimeout is not configured in the http.Server (gosec)"
 	m.debugServer = http.Server{
-		Handler: api.NewDebugHandler(manager, m.log, m),
+		Handler:           api.NewDebugHandler(manager, m.log, m, options.DebugTimeout),
+		ReadHeaderTimeout: options.ReadHeaderTimeout,
 	}
-	l, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", options.DebugPort)))
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create listener for workload cluster mux")
+	if !m.dryRun {
+		l, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", options.DebugPort)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create listener for workload cluster mux")
+		}
+		m.goroutines.Add(1)
+		go func() {
+			defer m.goroutines.Done()
+			if err := m.debugServer.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				m.log.Error(err, "Debug server for the workload cluster mux failed")
+			}
+		}()
 	}
-	go func() { _ = m.debugServer.Serve(l) }()
 
+	if m.idleListenerTimeout > 0 {
+		m.goroutines.Add(1)
+		go func() {
+			defer m.goroutines.Done()
+			m.runIdleListenerReaper()
+		}()
+	}
+
+	// NOTE: m is not shared yet, so it is not required to lock it.
+	m.updateMetricsLocked()
 	return m, nil
 }
 
+// runIdleListenerReaper periodically deletes listeners that have a port reserved but no API server
+// for longer than idleListenerTimeout, until the mux is shut down.
+func (m *WorkloadClustersMux) runIdleListenerReaper() {
+	ticker := m.clock.NewTicker(m.idleListenerTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C():
+			m.reapIdleListeners()
+		}
+	}
+}
+
+// reapIdleListeners deletes listeners that have a port reserved but no API server for longer than idleListenerTimeout.
+func (m *WorkloadClustersMux) reapIdleListeners() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for wclName, wcl := range m.workloadClusterListeners {
+		if wcl.listener != nil || wcl.apiServers.Len() > 0 || wcl.preallocated {
+			continue
+		}
+		if m.clock.Since(wcl.idleSince) < m.idleListenerTimeout {
+			continue
+		}
+
+		if err := m.deleteWorkloadClusterListenerLocked(wclName); err != nil {
+			m.log.Error(err, "Failed to delete idle workload cluster listener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+			continue
+		}
+		m.log.Info("Idle workload cluster listener deleted", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+	}
+}
+
+// newWorkloadClusterServer returns a server for a WorkloadClusterListener.
+// NOTE: Each listener gets its own server, so it is possible to stop a listener and drain its
+// connections without impacting other listeners.
+func (m *WorkloadClustersMux) newWorkloadClusterServer() *http.Server {
+	return &http.Server{
+		Handler:           m.muxHandler,
+		ReadHeaderTimeout: m.readHeaderTimeout,
+		TLSConfig:         m.muxTLSConfig,
+	}
+}
+
 // mixedHandler returns an handler that can serve either API server calls or etcd calls.
-func (m *WorkloadClustersMux) mixedHandler() http.Handler {
-	// Prepare a function that can identify which workloadCluster/resourceGroup a
-	// request targets to.
-	// IMPORTANT: this function assumes that both the listener and the resourceGroup
-	// for a workload cluster have the same name.
-	resourceGroupResolver := func(host string) (string, error) {
+// If resourceGroupResolver is nil, requests target the resourceGroup with the same name of the listener.
+// The handler is wrapped by the given middlewares, the first one being the outermost.
+func (m *WorkloadClustersMux) mixedHandler(resourceGroupResolver func(host string) (string, error), middlewares []func(http.Handler) http.Handler) http.Handler {
+	// Prepare a function that can identify which workloadCluster listener a request targets to.
+	listenerNameResolver := func(host string) (string, error) {
 		m.lock.RLock()
 		defer m.lock.RUnlock()
 		wclName, ok := m.workloadClusterNameByHost[host]
@@ -178,43 +710,172 @@ func (m *WorkloadClustersMux) mixedHandler() http.Handler {
 		return wclName, nil
 	}
 
+	// Prepare a function that can identify which resourceGroup a request targets to.
+	// NOTE: by default, the listener and the resourceGroup for a workload cluster have the same name.
+	if resourceGroupResolver == nil {
+		resourceGroupResolver = listenerNameResolver
+	}
+
+	// Prepare a function that returns the etcd members added to the workload cluster listener serving a request.
+	etcdMembersResolver := func(host string) (sets.Set[string], error) {
+		m.lock.RLock()
+		defer m.lock.RUnlock()
+		wclName, ok := m.workloadClusterNameByHost[host]
+		if !ok {
+			return nil, errors.Errorf("failed to get workloadClusterListener for host %s", host)
+		}
+		wcl, ok := m.workloadClusterListeners[wclName]
+		if !ok {
+			return nil, errors.Errorf("failed to get workloadClusterListener with name %s", wclName)
+		}
+		return wcl.etcdMembers.Clone(), nil
+	}
+
 	// build the handlers for API server and etcd.
-	apiHandler := api.NewAPIServerHandler(m.manager, m.log, resourceGroupResolver)
-	etcdHandler := etcd.NewEtcdServerHandler(m.manager, m.log, resourceGroupResolver)
+	apiHandler := api.NewAPIServerHandler(m.manager, m.log, resourceGroupResolver, api.WithWatchBookmarkInterval(m.watchBookmarkInterval))
+	m.apiHandler = apiHandler
+	etcdHandler := etcd.NewEtcdServerHandler(m.manager, m.log, resourceGroupResolver, etcdMembersResolver)
 
 	// Creates the mixed handler combining the two above depending on
 	// the type of request being processed
-	mixedHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("content-type"), "application/grpc") {
+	var mixedHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wclName, resolveErr := listenerNameResolver(fmt.Sprintf("%s", r.Context().Value(http.LocalAddrContextKey)))
+		if stats := m.getRequestStats(wclName); stats != nil {
+			stats.recordRequest()
+			recorder := &statusRecorder{ResponseWriter: w}
+			w = recorder
+			defer func() {
+				if message, ok := recorder.errorMessage(r); ok {
+					stats.recordError(m.clock.Now(), message)
+				}
+			}()
+		}
+		if isGRPCWebRequest(r) {
+			http.Error(w, "gRPC-Web requests are not supported", http.StatusUnsupportedMediaType)
+			return
+		}
+		if isGRPCRequest(r) {
+			// NOTE: the gRPC server expects a lower case content-type.
+			r.Header.Set("Content-Type", requestMediaType(r))
+			// NOTE: Requests which can't be mapped to a workload cluster are not counted.
+			if resolveErr == nil {
+				requestTotal.WithLabelValues(m.metricsID, wclName, "etcd").Inc()
+			}
+			r, span := m.startSpan(r, wclName, "etcd")
+			defer span.End()
+			if r.TLS != nil && !m.isEtcdMemberHealthy(wclName, r.TLS.ServerName) {
+				writeGRPCUnavailable(w, fmt.Sprintf("etcd member %s is unhealthy", r.TLS.ServerName))
+				return
+			}
 			etcdHandler.ServeHTTP(w, r)
 			return
 		}
+		if resolveErr == nil {
+			requestTotal.WithLabelValues(m.metricsID, wclName, "apiserver").Inc()
+		}
+		r, span := m.startSpan(r, wclName, "apiserver")
+		defer span.End()
+		if !m.isAPIServerHealthy(wclName) {
+			writeServiceUnavailable(w, r, "API server is unhealthy")
+			return
+		}
+		if injectFaults(m.getFaultConfig(wclName), w, r) {
+			return
+		}
 		apiHandler.ServeHTTP(w, r)
 	})
 
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		mixedHandler = middlewares[i](mixedHandler)
+	}
+
 	return h2c.NewHandler(mixedHandler, &http2.Server{})
 }
 
+// startSpan starts a span for a request served by the workload clusters mux, using the incoming trace context if any,
+// and returns the request with the span in its context.
+func (m *WorkloadClustersMux) startSpan(r *http.Request, wclName, handlerType string) (*http.Request, trace.Span) {
+	ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := m.tracer.Start(ctx, fmt.Sprintf("%s %s", handlerType, wclName),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("workloadCluster", wclName),
+			attribute.String("handler", handlerType),
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// isGRPCRequest returns true if a request is a gRPC request, which should be served by etcd.
+// NOTE: The content-type is matched case-insensitively and ignoring parameters, and it can
+// include a sub-type, e.g. application/grpc+proto.
+func isGRPCRequest(r *http.Request) bool {
+	if r.ProtoMajor != 2 {
+		return false
+	}
+	contentType := requestMediaType(r)
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+")
+}
+
+// isGRPCWebRequest returns true if a request is a gRPC-Web request, e.g. application/grpc-web or application/grpc-web-text.
+func isGRPCWebRequest(r *http.Request) bool {
+	return strings.HasPrefix(requestMediaType(r), "application/grpc-web")
+}
+
+// requestMediaType returns the media type of a request, lower case and without parameters.
+func requestMediaType(r *http.Request) string {
+	contentType, _, _ := strings.Cut(r.Header.Get("content-type"), ";")
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// getConfigForClient returns a TLS config requiring clients to present a certificate signed by the API server CA
+// or by the etcd CA of the workload cluster being targeted, depending on the request being processed.
+func (m *WorkloadClustersMux) getConfigForClient(info *tls.ClientHelloInfo) (*tls.Config, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, wcl, err := m.resolveWorkloadClusterListenerLocked(info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requests targeting a specific etcd member are verified using the etcd CA, all the others using the API server CA.
+	caCertificate := wcl.apiServerCaCertificate
+	if wcl.etcdMembers.Has(info.ServerName) {
+		caCertificate = wcl.etcdCaCertificate
+	}
+
+	clientCAs := x509.NewCertPool()
+	if caCertificate != nil {
+		clientCAs.AddCert(caCertificate)
+	}
+
+	config := m.muxTLSConfig.Clone()
+	config.GetConfigForClient = nil
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = clientCAs
+	return config, nil
+}
+
+// newCertificate generates a certificate signed by the given CA using the certificate factory of the workload clusters mux.
+func (m *WorkloadClustersMux) newCertificate(caCert *x509.Certificate, caKey *rsa.PrivateKey, config *certs.Config) (*x509.Certificate, crypto.Signer, error) {
+	return m.certificateFactory(CertConfig{Config: config, CACert: caCert, CAKey: caKey, Validity: m.certificateValidity, Now: m.clock.Now()})
+}
+
 // getCertificate selects certificates for a specific cluster depending on the request being processed
 // (API server and etcd have different certificates).
 func (m *WorkloadClustersMux) getCertificate(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	// Identify which workloadCluster/resourceGroup a request targets to.
 	hostPort := info.Conn.LocalAddr().String()
-	wclName, ok := m.workloadClusterNameByHost[hostPort]
-	if !ok {
-		err := errors.Errorf("failed to get listener name for workload cluster serving on %s", hostPort)
-		m.log.Error(err, "Error resolving certificates")
-		return nil, err
-	}
 
-	// Gets the listener config for the target workloadCluster.
-	wcl, ok := m.workloadClusterListeners[wclName]
-	if !ok {
-		err := errors.Errorf("failed to get listener with name %s for workload cluster serving on %s", wclName, hostPort)
-		m.log.Error(err, "Error resolving certificates")
+	// Identify which workloadCluster/resourceGroup a request targets to.
+	wclName, wcl, err := m.resolveWorkloadClusterListenerLocked(info)
+	if err != nil {
+		m.log.Error(err, "Error resolving certificates", "serverName", info.ServerName, "hostPort", hostPort)
 		return nil, err
 	}
 
@@ -222,18 +883,55 @@ func (m *WorkloadClustersMux) getCertificate(info *tls.ClientHelloInfo) (*tls.Ce
 	// NOTE: the port forward call to etcd sets the server name to the name of the targeted etcd pod,
 	// which is also the name of the corresponding etcd member.
 	if wcl.etcdMembers.Has(info.ServerName) {
-		m.log.V(4).Info("Using etcd serving certificate", "listenerName", wcl, "host", hostPort, "etcdPod", info.ServerName)
-		return wcl.etcdServingCertificates[info.ServerName], nil
+		m.log.V(4).Info("Using etcd serving certificate", "listenerName", wclName, "serverName", info.ServerName, "hostPort", hostPort, "podName", info.ServerName)
+		certificate, ok := wcl.etcdServingCertificates[info.ServerName]
+		if !ok {
+			return nil, errors.Wrapf(ErrCertificateNotReady, "failed to get etcd serving certificate for listener %s (serverName: %q, hostPort: %s)", wclName, info.ServerName, hostPort)
+		}
+		return certificate, nil
 	}
 
 	// Otherwise we assume the request targets the API server.
-	m.log.V(4).Info("Using API server serving certificate", "listenerName", wcl, "host", hostPort)
+	m.log.V(4).Info("Using API server serving certificate", "listenerName", wclName, "serverName", info.ServerName, "hostPort", hostPort)
+	if wcl.apiServerServingCertificate == nil {
+		return nil, errors.Wrapf(ErrCertificateNotReady, "failed to get API server serving certificate for listener %s (serverName: %q, hostPort: %s)", wclName, info.ServerName, hostPort)
+	}
 	return wcl.apiServerServingCertificate, nil
 }
 
+// resolveWorkloadClusterListenerLocked identifies the WorkloadClusterListener serving a TLS connection.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) resolveWorkloadClusterListenerLocked(info *tls.ClientHelloInfo) (string, *WorkloadClusterListener, error) {
+	hostPort := info.Conn.LocalAddr().String()
+	wclName, ok := m.workloadClusterNameByHost[hostPort]
+	if !ok {
+		return "", nil, errors.Wrapf(ErrListenerNotFound, "failed to get listener name for workload cluster (serverName: %q, hostPort: %s)", info.ServerName, hostPort)
+	}
+
+	// Gets the listener config for the target workloadCluster.
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return "", nil, errors.Wrapf(ErrListenerNotFound, "failed to get listener with name %s for workload cluster (serverName: %q, hostPort: %s)", wclName, info.ServerName, hostPort)
+	}
+	return wclName, wcl, nil
+}
+
 // HotRestart tries to set up the mux according to an existing set of InMemoryClusters.
+// Clusters whose resource group does not exist, e.g. because the Cluster has been deleted, are skipped
+// with a warning, because their listeners would fail every request.
 // NOTE: This is done at best effort in order to make iterative development workflows easier.
 func (m *WorkloadClustersMux) HotRestart(clusters *infrav1.InMemoryClusterList) error {
+	return m.hotRestart(clusters, false)
+}
+
+// HotRestartWithPortRepair is like HotRestart, but when two or more clusters are using the same port, instead of failing,
+// a new free port is assigned to all the clusters but the first one. The ControlPlaneEndpoint of those clusters
+// is updated in the given list, so the caller can persist the corrected endpoints.
+func (m *WorkloadClustersMux) HotRestartWithPortRepair(clusters *infrav1.InMemoryClusterList) error {
+	return m.hotRestart(clusters, true)
+}
+
+func (m *WorkloadClustersMux) hotRestart(clusters *infrav1.InMemoryClusterList, repairPortCollisions bool) error {
 	if len(clusters.Items) == 0 {
 		return nil
 	}
@@ -244,10 +942,15 @@ func (m *WorkloadClustersMux) HotRestart(clusters *infrav1.InMemoryClusterList)
 	if len(m.workloadClusterListeners) > 0 {
 		return errors.New("WorkloadClustersMux cannot be hot restarted when there are already initialized listeners")
 	}
+	if m.unixSocketsDir != "" {
+		return errors.New("WorkloadClustersMux cannot be hot restarted when using Unix domain sockets")
+	}
 
 	ports := sets.Set[int]{}
 	maxPort := m.minPort - 1
-	for _, c := range clusters.Items {
+	collisions := []int{}
+	for i := range clusters.Items {
+		c := &clusters.Items[i]
 		if c.Spec.ControlPlaneEndpoint.Host == "" {
 			continue
 		}
@@ -256,61 +959,185 @@ func (m *WorkloadClustersMux) HotRestart(clusters *infrav1.InMemoryClusterList)
 			return errors.Errorf("unable to restart the WorkloadClustersMux, the host address is changed from %s to %s", c.Spec.ControlPlaneEndpoint.Host, m.host)
 		}
 
-		if ports.Has(c.Spec.ControlPlaneEndpoint.Port) {
-			return errors.Errorf("unable to restart the WorkloadClustersMux, there are two or more clusters using port %d", c.Spec.ControlPlaneEndpoint.Port)
-		}
-
 		resourceGroup, ok := c.Annotations[infrav1.ResourceGroupAnnotationName]
 		if !ok {
 			return errors.Errorf("unable to restart the WorkloadClustersMux, cluster %s doesn't have the %s annotation", klog.KRef(c.Namespace, c.Name), infrav1.ResourceGroupAnnotationName)
 		}
 
-		m.initWorkloadClusterListenerWithPortLocked(resourceGroup, c.Spec.ControlPlaneEndpoint.Port)
+		// Skip clusters whose resource group does not exist, otherwise the listener will come up but every request will fail.
+		if !m.manager.HasResourceGroup(resourceGroup) {
+			m.log.Info("Warning: skipping hot restart of cluster, the resource group does not exist; resource groups must be created before hot restart", "cluster", klog.KRef(c.Namespace, c.Name), "resourceGroup", resourceGroup)
+			continue
+		}
+
+		if ports.Has(c.Spec.ControlPlaneEndpoint.Port) {
+			if !repairPortCollisions {
+				return errors.Errorf("unable to restart the WorkloadClustersMux, there are two or more clusters using port %d", c.Spec.ControlPlaneEndpoint.Port)
+			}
+			// Clusters colliding with others will get a new port once all the ports in use are known.
+			collisions = append(collisions, i)
+			continue
+		}
+
+		m.initWorkloadClusterListenerWithPortLocked(resourceGroup, m.host, c.Spec.ControlPlaneEndpoint.Port)
+		ports.Insert(c.Spec.ControlPlaneEndpoint.Port)
 
 		if maxPort < c.Spec.ControlPlaneEndpoint.Port {
 			maxPort = c.Spec.ControlPlaneEndpoint.Port
 		}
 	}
 
+	// Ports in the range not used by any existing cluster can be reused; ports in use by other processes
+	// are detected again when picking ports, so the busy ports tracked so far are dropped.
+	m.busyPorts = sets.Set[int]{}
+	for port := m.minPort; port < maxPort; port++ {
+		if !ports.Has(port) {
+			m.freePorts.Insert(port)
+		}
+	}
 	m.portIndex = maxPort + 1
-	return nil
-}
+
+	for _, i := range collisions {
+		c := &clusters.Items[i]
+		port, err := m.getFreePortLocked(m.host)
+		if err != nil {
+			return errors.Wrapf(err, "unable to restart the WorkloadClustersMux, failed to get a new port for cluster %s", klog.KRef(c.Namespace, c.Name))
+		}
+		m.log.Info("Port already used by another cluster, assigning a new port", "clusterName", klog.KRef(c.Namespace, c.Name), "listenerName", c.Annotations[infrav1.ResourceGroupAnnotationName], "oldPort", c.Spec.ControlPlaneEndpoint.Port, "newPort", port)
+
+		c.Spec.ControlPlaneEndpoint.Port = port
+		m.initWorkloadClusterListenerWithPortLocked(c.Annotations[infrav1.ResourceGroupAnnotationName], m.host, port)
+	}
+
+	m.updateMetricsLocked()
+	return nil
+}
 
 // InitWorkloadClusterListener initialize a WorkloadClusterListener by reserving a port for it.
 // Note: The listener will be started when the first API server will be added.
 func (m *WorkloadClustersMux) InitWorkloadClusterListener(wclName string) (*WorkloadClusterListener, error) {
+	return m.InitWorkloadClusterListenerWithHost(wclName, m.host)
+}
+
+// InitWorkloadClusterListenerWithHost initialize a WorkloadClusterListener bound to a specific host address
+// by reserving a port for it, e.g. to have each workload cluster on its own loopback alias (127.0.0.2, 127.0.0.3, …).
+// Note: The listener will be started when the first API server will be added.
+// Note: Ports are unique across all the listeners, no matter of the host they are bound to.
+// Note: HotRestart only supports listeners bound to the host address of the workload clusters mux.
+func (m *WorkloadClustersMux) InitWorkloadClusterListenerWithHost(wclName, host string) (*WorkloadClusterListener, error) {
+	// Normalize IP addresses, so host:port keys match the local address of accepted connections.
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	if wcl, ok := m.workloadClusterListeners[wclName]; ok {
+		if wcl.host != host {
+			return nil, errors.Errorf("workloadClusterListener with name %s is already initialized with host %s", wclName, wcl.host)
+		}
+		return wcl, nil
+	}
+
+	return m.initWorkloadClusterListenerWithHostLocked(wclName, host)
+}
+
+// PreallocateListeners initializes n WorkloadClusterListeners in advance, by reserving a port for each of them,
+// and returns their names; tests can then use those names for the workload clusters (and the corresponding
+// resource groups), amortizing the setup cost and surfacing port exhaustion before the test begins.
+// If it is not possible to reserve all the ports, no listener is initialized and an error is returned.
+// NOTE: The listeners are started when the first API server will be added, as usual.
+// NOTE: Preallocated listeners are not deleted by the idle listener reaper until they are used for the first time.
+func (m *WorkloadClustersMux) PreallocateListeners(n int) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	names := []string{}
+	for len(names) < n {
+		m.preallocatedListenersIndex++
+		wclName := fmt.Sprintf("preallocated-%d", m.preallocatedListenersIndex)
+		if _, ok := m.workloadClusterListeners[wclName]; ok {
+			continue
+		}
+
+		wcl, err := m.initWorkloadClusterListenerWithHostLocked(wclName, m.host)
+		if err != nil {
+			for _, name := range names {
+				if err := m.deleteWorkloadClusterListenerLocked(name); err != nil {
+					m.log.Error(err, "Failed to delete preallocated workload cluster listener", "listenerName", name)
+				}
+			}
+			return nil, errors.Wrapf(err, "failed to preallocate %d listeners", n)
+		}
+		wcl.preallocated = true
+		names = append(names, wclName)
+	}
+	return names, nil
+}
+
+// initWorkloadClusterListenerWithHostLocked initializes a workload cluster listener bound to a specific host address,
+// by reserving a port or by using a Unix domain socket.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) initWorkloadClusterListenerWithHostLocked(wclName, host string) (*WorkloadClusterListener, error) {
+	if m.unixSocketsDir != "" {
+		socketPath := filepath.Join(m.unixSocketsDir, fmt.Sprintf("%s.sock", strings.ReplaceAll(wclName, "/", "_")))
+		return m.initWorkloadClusterListenerWithSocketLocked(wclName, host, socketPath), nil
+	}
+
+	if m.ephemeralPorts && !m.dryRun {
+		// Bind port 0 and keep the listener open until the API server is started, so the port
+		// assigned by the OS can't be taken by someone else in the meantime.
+		l, err := m.listenConfig().Listen(context.Background(), "tcp", net.JoinHostPort(host, "0"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to bind an ephemeral port for WorkloadClusterListener %s", wclName)
+		}
+		wcl := m.initWorkloadClusterListenerWithPortLocked(wclName, host, l.Addr().(*net.TCPAddr).Port)
+		wcl.reservedListener = l
 		return wcl, nil
 	}
 
-	port, err := m.getFreePortLocked()
+	port, err := m.getFreePortLocked(host)
 	if err != nil {
 		return nil, err
 	}
 
-	wcl := m.initWorkloadClusterListenerWithPortLocked(wclName, port)
-
-	return wcl, nil
+	return m.initWorkloadClusterListenerWithPortLocked(wclName, host, port), nil
 }
 
 // initWorkloadClusterListenerWithPortLocked initializes a workload cluster listener.
 // Note: m.lock must be locked before calling this method.
-func (m *WorkloadClustersMux) initWorkloadClusterListenerWithPortLocked(wclName string, port int) *WorkloadClusterListener {
+func (m *WorkloadClustersMux) initWorkloadClusterListenerWithPortLocked(wclName, host string, port int) *WorkloadClusterListener {
+	return m.initWorkloadClusterListenerLocked(wclName, host, port, "")
+}
+
+// initWorkloadClusterListenerWithSocketLocked initializes a workload cluster listener using a Unix domain socket.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) initWorkloadClusterListenerWithSocketLocked(wclName, host, socketPath string) *WorkloadClusterListener {
+	return m.initWorkloadClusterListenerLocked(wclName, host, 0, socketPath)
+}
+
+// initWorkloadClusterListenerLocked initializes a workload cluster listener.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) initWorkloadClusterListenerLocked(wclName, host string, port int, socketPath string) *WorkloadClusterListener {
 	wcl := &WorkloadClusterListener{
 		scheme:                  m.manager.GetScheme(),
-		host:                    m.host,
+		host:                    host,
 		port:                    port,
+		socketPath:              socketPath,
 		apiServers:              sets.New[string](),
+		apiServerExtraSANs:      sets.New[string](),
 		etcdMembers:             sets.New[string](),
+		unhealthyEtcdMembers:    sets.New[string](),
 		etcdServingCertificates: map[string]*tls.Certificate{},
+		idleSince:               m.clock.Now(),
+		requestStats:            &requestStats{},
 	}
 	m.workloadClusterListeners[wclName] = wcl
 	m.workloadClusterNameByHost[wcl.HostPort()] = wclName
+	m.updateMetricsLocked()
 
-	m.log.Info("Workload cluster listener created", "listenerName", wclName, "address", wcl.Address())
+	m.log.Info("Workload cluster listener created", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
 	return wcl
 }
 
@@ -318,269 +1145,1581 @@ func (m *WorkloadClustersMux) initWorkloadClusterListenerWithPortLocked(wclName
 // When the first API server instance is added the serving certificates and the admin certificate
 // for tests are generated, and the listener is started.
 func (m *WorkloadClustersMux) AddAPIServer(wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
-	// Start server
-	// Note: It is important that we unlock once the server is started. Because otherwise the server
-	// doesn't work yet as GetCertificate (which is required for the tls handshake) also requires the lock.
-	var startServerErr error
-	var wcl *WorkloadClusterListener
-	err := func() error {
-		m.lock.Lock()
-		defer m.lock.Unlock()
-
-		var ok bool
-		wcl, ok = m.workloadClusterListeners[wclName]
-		if !ok {
-			return errors.Errorf("workloadClusterListener with name %s must be initialized before adding an APIserver", wclName)
-		}
-		wcl.apiServers.Insert(podName)
-		m.log.Info("APIServer instance added to workloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "podName", podName)
-
-		// TODO: check if cert/key are already set, they should match
-		wcl.apiServerCaCertificate = caCert
-		wcl.apiServerCaKey = caKey
-
-		// Generate Serving certificates for the API server instance
-		// NOTE: There is only one server certificate for all API server instances (kubeadm
-		// instead creates one for each API server pod). We don't need this because we are
-		// accessing all API servers via the same endpoint.
-		if wcl.apiServerServingCertificate == nil {
-			config := apiServerCertificateConfig(wcl.host)
-			cert, key, err := newCertAndKey(caCert, caKey, config)
-			if err != nil {
-				return errors.Wrapf(err, "failed to create serving certificate for API server %s", podName)
-			}
+	return m.AddAPIServerWithContext(context.Background(), wclName, podName, caCert, caKey)
+}
 
-			certificate, err := tls.X509KeyPair(certs.EncodeCertPEM(cert), certs.EncodePrivateKeyPEM(key))
-			if err != nil {
-				return errors.Wrapf(err, "failed to create X509KeyPair for API server %s", podName)
-			}
-			wcl.apiServerServingCertificate = &certificate
-		}
+// AddAPIServerWithContext is like AddAPIServer, but it respects the context cancellation while starting the listener
+// and while waiting for it to serve; if the context is cancelled, a listener started by this call is stopped and
+// the API server instance is removed.
+func (m *WorkloadClustersMux) AddAPIServerWithContext(ctx context.Context, wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
+	return m.addAPIServer(ctx, wclName, podName, caCert, caKey, nil)
+}
 
-		// Generate admin certificates to be used for accessing the API server.
-		// NOTE: this is used for tests because CAPI creates its own.
-		if wcl.adminCertificate == nil {
-			config := adminClientCertificateConfig()
-			cert, key, err := newCertAndKey(caCert, caKey, config)
-			if err != nil {
-				return errors.Wrapf(err, "failed to create admin certificate for API server %s", podName)
-			}
+// AddAPIServerWithSANs is like AddAPIServer, but it adds extraSANs, either DNS names or IPs, to the API server
+// serving certificate, e.g. when tests access the endpoint via multiple names.
+// NOTE: Extra SANs are kept for the WorkloadClusterListener, so they are preserved also when the serving certificate
+// is rotated; if new extra SANs are added, the serving certificate is regenerated.
+func (m *WorkloadClustersMux) AddAPIServerWithSANs(wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey, extraSANs []string) error {
+	return m.addAPIServer(context.Background(), wclName, podName, caCert, caKey, extraSANs)
+}
 
-			wcl.adminCertificate = cert
-			wcl.adminKey = key
-		}
+// AddAPIServerInsecure is like AddAPIServer, but the listener serves plain HTTP instead of HTTPS, e.g. for tests
+// using a trivial HTTP client; no certificates are generated, and requests are routed by the mixed handler as usual.
+// NOTE: etcd requests are served using HTTP/2 without TLS (h2c), so etcd clients must use plain text connections.
+// NOTE: All the API servers of a WorkloadClusterListener must either serve HTTPS or plain HTTP.
+func (m *WorkloadClustersMux) AddAPIServerInsecure(wclName, podName string) error {
+	ctx := context.Background()
 
-		// Start the listener for the API server.
-		// NOTE: There is only one listener for all API server instances; the same listener will act
-		// as a port forward target too.
-		if wcl.listener != nil {
-			return nil
-		}
+	unlock := m.lockListenerProvisioning(wclName)
+	defer unlock()
 
-		l, err := net.Listen("tcp", wcl.HostPort())
-		if err != nil {
-			return errors.Wrapf(err, "failed to start WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
-		}
-		wcl.listener = l
+	startup, err := func() (*apiServerStartup, error) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
 
-		go func() {
-			if startServerErr = m.muxServer.ServeTLS(wcl.listener, "", ""); startServerErr != nil && !errors.Is(startServerErr, http.ErrServerClosed) {
-				m.log.Error(startServerErr, "Failed to start WorkloadClusterListener", "listenerName", wclName, "address", wcl.Address())
-			}
-		}()
-		return nil
+		return m.addAPIServerInsecureLocked(ctx, wclName, podName)
 	}()
 	if err != nil {
 		return errors.Wrapf(err, "error starting server")
 	}
+	return m.waitForAPIServerStartup(ctx, startup)
+}
 
-	// Wait until the sever is working.
-	var pollErr error
-	err = wait.PollUntilContextTimeout(context.TODO(), 10*time.Millisecond, 1*time.Second, true, func(ctx context.Context) (done bool, err error) {
-		d := &net.Dialer{Timeout: 50 * time.Millisecond}
-		conn, err := tls.DialWithDialer(d, "tcp", wcl.HostPort(), &tls.Config{
-			InsecureSkipVerify: true, //nolint:gosec // config is used to connect to our own port.
-		})
-		if err != nil {
-			pollErr = fmt.Errorf("server is not reachable: %w", err)
-			return false, nil
-		}
+// addAPIServerInsecureLocked adds an API server instance serving plain HTTP behind the WorkloadClusterListener
+// and starts the listener if necessary.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) addAPIServerInsecureLocked(ctx context.Context, wclName, podName string) (*apiServerStartup, error) {
+	startup := &apiServerStartup{wclName: wclName, podName: podName}
 
-		if err := conn.Close(); err != nil {
-			pollErr = fmt.Errorf("server is not reachable: closing connection: %w", err)
-			return false, nil
-		}
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return nil, errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before adding an APIserver", wclName)
+	}
+	startup.wcl = wcl
 
-		return true, nil
-	})
-	if err != nil {
-		return kerrors.NewAggregate([]error{err, pollErr})
+	if !wcl.insecure && wcl.apiServers.Len() > 0 {
+		return nil, errors.Errorf("failed to add insecure APIServer %s to WorkloadClusterListener %s: the existing API servers serve HTTPS", podName, wclName)
 	}
 
-	if startServerErr != nil {
-		return startServerErr
+	// Re-adding an existing API server is a no-op.
+	if wcl.apiServers.Has(podName) && wcl.listener != nil {
+		startup.noop = true
+		return startup, nil
 	}
 
-	m.log.Info("WorkloadClusterListener successfully started", "listenerName", wclName, "address", wcl.Address())
-	return nil
-}
+	startup.podAdded = !wcl.apiServers.Has(podName)
+	wcl.apiServers.Insert(podName)
+	wcl.insecure = true
+	wcl.preallocated = false
+	m.log.Info("Insecure APIServer instance added to workloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port(), "podName", podName)
 
-// DeleteAPIServer removes an API server instance from the WorkloadClusterListener.
-func (m *WorkloadClustersMux) DeleteAPIServer(wclName, podName string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	return m.startListenerLocked(ctx, startup)
+}
 
+// lockListenerProvisioning acquires the provisioning lock of the WorkloadClusterListener with the given name and
+// returns a func releasing it; if the listener does not exist a no-op func is returned, and reporting the error
+// is left to the caller.
+// NOTE: m.lock must not be locked when calling this method.
+func (m *WorkloadClustersMux) lockListenerProvisioning(wclName string) func() {
+	m.lock.RLock()
 	wcl, ok := m.workloadClusterListeners[wclName]
+	m.lock.RUnlock()
 	if !ok {
-		return errors.Errorf("workloadClusterListener with name %s must be initialized before removing an APIserver", wclName)
+		return func() {}
 	}
-	wcl.apiServers.Delete(podName)
-	m.log.Info("APIServer instance removed from the workloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "podName", podName)
 
-	if wcl.apiServers.Len() < 1 && wcl.listener != nil {
-		if err := wcl.listener.Close(); err != nil {
-			return errors.Wrapf(err, "failed to stop WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
+	wcl.provisioningLock.Lock()
+	return wcl.provisioningLock.Unlock
+}
+
+// lockListenersProvisioning is like lockListenerProvisioning, but it acquires the provisioning locks of all the
+// WorkloadClusterListeners with the given names; locks are acquired sorted by name, so concurrent batches can't deadlock.
+// NOTE: m.lock must not be locked when calling this method.
+func (m *WorkloadClustersMux) lockListenersProvisioning(wclNames ...string) func() {
+	unlocks := []func(){}
+	for _, wclName := range sets.List(sets.New(wclNames...)) {
+		unlocks = append(unlocks, m.lockListenerProvisioning(wclName))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
 		}
-		wcl.listener = nil
-		m.log.Info("WorkloadClusterListener stopped because there are no APIServer left", "listenerName", wclName, "address", wcl.Address())
 	}
-	return nil
 }
 
-// HasAPIServer returns true if the workload cluster already has an apiserver with podName.
-func (m *WorkloadClustersMux) HasAPIServer(wclName, podName string) bool {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+// apiServerCertificates are certificates for an API server instance generated before acquiring m.lock.
+type apiServerCertificates struct {
+	host      string
+	caCert    *x509.Certificate
+	extraSANs sets.Set[string]
 
-	wcl, ok := m.workloadClusterListeners[wclName]
-	if !ok {
-		return false
+	servingCertificate *tls.Certificate
+	adminCertificate   *x509.Certificate
+	adminKey           crypto.Signer
+}
+
+// servingCertificateFor returns the serving certificate, if it has been generated for the given host, CA and extra SANs.
+func (c *apiServerCertificates) servingCertificateFor(host string, caCert *x509.Certificate, extraSANs sets.Set[string]) *tls.Certificate {
+	if c == nil || c.servingCertificate == nil || c.host != host || !c.caCert.Equal(caCert) || !c.extraSANs.Equal(extraSANs) {
+		return nil
 	}
-	return wcl.apiServers.Has(podName)
+	return c.servingCertificate
 }
 
-// AddEtcdMember mimics adding an etcd Member behind the WorkloadClusterListener;
-// every etcd member gets a dedicated serving certificate, so it will be possible to serve port forward requests
-// to a specific etcd pod/member.
-func (m *WorkloadClustersMux) AddEtcdMember(wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// adminCertificateFor returns the admin certificate and key, if they have been generated for the given CA.
+func (c *apiServerCertificates) adminCertificateFor(caCert *x509.Certificate) (*x509.Certificate, crypto.Signer) {
+	if c == nil || c.adminCertificate == nil || !c.caCert.Equal(caCert) {
+		return nil, nil
+	}
+	return c.adminCertificate, c.adminKey
+}
 
+// newAPIServerCertificates generates the certificates required for adding an API server instance to the
+// WorkloadClusterListener with the given name, if any.
+// NOTE: Certificates are generated without holding m.lock, so they must be validated before being used;
+// nil is returned if the listener does not exist.
+func (m *WorkloadClustersMux) newAPIServerCertificates(wclName string, caCert *x509.Certificate, caKey *rsa.PrivateKey, extraSANs []string) (*apiServerCertificates, error) {
+	m.lock.RLock()
 	wcl, ok := m.workloadClusterListeners[wclName]
 	if !ok {
-		return errors.Errorf("workloadClusterListener with name %s must be initialized before adding an etcd member", wclName)
+		m.lock.RUnlock()
+		return nil, nil
 	}
-	wcl.etcdMembers.Insert(podName)
-	m.log.Info("Etcd member added to WorkloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "podName", podName)
+	caChanged := wcl.apiServerCaCertificate == nil || !wcl.apiServerCaCertificate.Equal(caCert)
+	needsServingCertificate := caChanged || wcl.apiServerServingCertificate == nil || !wcl.apiServerExtraSANs.HasAll(extraSANs...)
+	needsAdminCertificate := caChanged || wcl.adminCertificate == nil
+	certificates := &apiServerCertificates{
+		host:      wcl.host,
+		caCert:    caCert,
+		extraSANs: wcl.apiServerExtraSANs.Clone().Insert(extraSANs...),
+	}
+	m.lock.RUnlock()
 
-	// Generate Serving certificates for the etcdMember
-	if _, ok := wcl.etcdServingCertificates[podName]; !ok {
-		config := etcdServerCertificateConfig(podName, wcl.host)
-		cert, key, err := newCertAndKey(caCert, caKey, config)
+	if needsServingCertificate {
+		certificate, err := newAPIServerServingCertificate(m.certificateFactory, certificates.host, caCert, caKey, m.clock.Now(), m.certificateValidity, sets.List(certificates.extraSANs)...)
 		if err != nil {
-			return errors.Wrapf(err, "failed to create serving certificate for etcd member %s", podName)
+			return nil, errors.Wrapf(err, "failed to create serving certificate for workloadClusterListener %s", wclName)
 		}
-
-		certificate, err := tls.X509KeyPair(certs.EncodeCertPEM(cert), certs.EncodePrivateKeyPEM(key))
+		certificates.servingCertificate = certificate
+	}
+	if needsAdminCertificate {
+		cert, key, err := m.newCertificate(caCert, caKey, adminClientCertificateConfig())
 		if err != nil {
-			return errors.Wrapf(err, "failed to create X509KeyPair for etcd member %s", podName)
+			return nil, errors.Wrapf(err, "failed to create admin certificate for workloadClusterListener %s", wclName)
 		}
-		wcl.etcdServingCertificates[podName] = &certificate
+		certificates.adminCertificate = cert
+		certificates.adminKey = key
 	}
-
-	return nil
+	return certificates, nil
 }
 
-// HasEtcdMember returns true if the workload cluster already has an etcd member with podName.
-func (m *WorkloadClustersMux) HasEtcdMember(wclName, podName string) bool {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+// addAPIServer adds an API server instance behind the WorkloadClusterListener, adding extraSANs to the serving certificate.
+func (m *WorkloadClustersMux) addAPIServer(ctx context.Context, wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey, extraSANs []string) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrapf(err, "failed to add APIServer %s to WorkloadClusterListener %s", podName, wclName)
+	}
 
-	wcl, ok := m.workloadClusterListeners[wclName]
-	if !ok {
-		return false
+	// Generate certificates before acquiring the lock, so provisioning a listener does not block
+	// other listeners nor TLS handshakes.
+	unlock := m.lockListenerProvisioning(wclName)
+	defer unlock()
+
+	certificates, err := m.newAPIServerCertificates(wclName, caCert, caKey, extraSANs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to add APIServer %s to WorkloadClusterListener %s", podName, wclName)
 	}
-	return wcl.etcdMembers.Has(podName)
+
+	// Start server
+	// Note: It is important that we unlock once the server is started. Because otherwise the server
+	// doesn't work yet as GetCertificate (which is required for the tls handshake) also requires the lock.
+	startup, err := func() (*apiServerStartup, error) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		return m.addAPIServerLocked(ctx, wclName, podName, caCert, caKey, extraSANs, certificates)
+	}()
+	if err != nil {
+		return errors.Wrapf(err, "error starting server")
+	}
+	return m.waitForAPIServerStartup(ctx, startup)
 }
 
-// DeleteEtcdMember removes an etcd Member from the WorkloadClusterListener.
-func (m *WorkloadClustersMux) DeleteEtcdMember(wclName, podName string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+// apiServerStartup tracks an API server instance added to a WorkloadClusterListener, so it is possible
+// to wait for the listener to serve after releasing the lock.
+type apiServerStartup struct {
+	wclName string
+	podName string
+	wcl     *WorkloadClusterListener
+
+	// server is the server started while adding the API server instance, if any.
+	server *http.Server
+
+	// serveErrCh is used to stop waiting for the server as soon as it fails; it is nil if the
+	// server was already started before adding the API server instance.
+	serveErrCh chan error
+
+	podAdded bool
+	noop     bool
+
+	// insecure is true if the listener serves plain HTTP.
+	insecure bool
+}
+
+// addAPIServerLocked adds an API server instance behind the WorkloadClusterListener and starts the listener if necessary;
+// certificates generated in advance are used if still valid, otherwise new certificates are generated.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) addAPIServerLocked(ctx context.Context, wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey, extraSANs []string, certificates *apiServerCertificates) (*apiServerStartup, error) {
+	startup := &apiServerStartup{wclName: wclName, podName: podName}
 
 	wcl, ok := m.workloadClusterListeners[wclName]
 	if !ok {
-		return errors.Errorf("workloadClusterListener with name %s must be initialized before removing an etcd member", wclName)
+		return nil, errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before adding an APIserver", wclName)
 	}
-	wcl.etcdMembers.Delete(podName)
-	delete(wcl.etcdServingCertificates, podName)
-	m.log.Info("Etcd member removed from WorkloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "podName", podName)
+	startup.wcl = wcl
 
-	return nil
-}
+	if wcl.insecure && wcl.apiServers.Len() > 0 {
+		return nil, errors.Errorf("failed to add APIServer %s to WorkloadClusterListener %s: the existing API servers serve plain HTTP", podName, wclName)
+	}
+	wcl.insecure = false
 
-// ListListeners implements api.DebugInfoProvider.
-func (m *WorkloadClustersMux) ListListeners() map[string]string {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+	// All the API servers of a workload cluster must use the same CA, because there is only one serving
+	// certificate; RotateAPIServerCertificate must be used to change it.
+	if wcl.apiServerCaCertificate != nil && !wcl.apiServerCaCertificate.Equal(caCert) {
+		if wcl.apiServers.Len() > 0 {
+			return nil, errors.Wrapf(ErrCertificateAuthorityMismatch, "failed to add APIServer %s to WorkloadClusterListener %s: the CA is different from the one used by the existing API servers, use RotateAPIServerCertificate to change it", podName, wclName)
+		}
+		// There are no API servers left using the previous CA, drop the certificates signed by it.
+		wcl.apiServerServingCertificate = nil
+		wcl.adminCertificate = nil
+		wcl.adminKey = nil
+	}
 
-	ret := map[string]string{}
-	for k, l := range m.workloadClusterListeners {
-		ret[k] = l.Address()
+	// New extra SANs require a new serving certificate.
+	if !wcl.apiServerExtraSANs.HasAll(extraSANs...) {
+		wcl.apiServerExtraSANs.Insert(extraSANs...)
+		wcl.apiServerServingCertificate = nil
 	}
-	return ret
-}
 
-// DeleteWorkloadClusterListener deletes a WorkloadClusterListener.
-func (m *WorkloadClustersMux) DeleteWorkloadClusterListener(wclName string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	// Re-adding an existing API server with the same CA is a no-op.
+	if wcl.apiServers.Has(podName) && wcl.listener != nil && wcl.apiServerServingCertificate != nil {
+		startup.noop = true
+		return startup, nil
+	}
 
-	wcl, ok := m.workloadClusterListeners[wclName]
-	if !ok {
-		return nil
+	startup.podAdded = !wcl.apiServers.Has(podName)
+	wcl.apiServers.Insert(podName)
+	wcl.preallocated = false
+	m.log.Info("APIServer instance added to workloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port(), "podName", podName)
+
+	wcl.apiServerCaCertificate = caCert
+	wcl.apiServerCaKey = caKey
+
+	// Generate Serving certificates for the API server instance
+	// NOTE: There is only one server certificate for all API server instances (kubeadm
+	// instead creates one for each API server pod). We don't need this because we are
+	// accessing all API servers via the same endpoint.
+	if wcl.apiServerServingCertificate == nil {
+		certificate := certificates.servingCertificateFor(wcl.host, caCert, wcl.apiServerExtraSANs)
+		if certificate == nil {
+			var err error
+			certificate, err = newAPIServerServingCertificate(m.certificateFactory, wcl.host, caCert, caKey, m.clock.Now(), m.certificateValidity, sets.List(wcl.apiServerExtraSANs)...)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create serving certificate for API server %s", podName)
+			}
+		}
+		wcl.apiServerServingCertificate = certificate
+	}
+
+	// Generate admin certificates to be used for accessing the API server.
+	// NOTE: this is used for tests because CAPI creates its own.
+	if wcl.adminCertificate == nil {
+		cert, key := certificates.adminCertificateFor(caCert)
+		if cert == nil {
+			var err error
+			cert, key, err = m.newCertificate(caCert, caKey, adminClientCertificateConfig())
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create admin certificate for API server %s", podName)
+			}
+		}
+
+		wcl.adminCertificate = cert
+		wcl.adminKey = key
 	}
 
+	return m.startListenerLocked(ctx, startup)
+}
+
+// startListenerLocked starts the listener for the API server instance being added, if not already started.
+// NOTE: There is only one listener for all API server instances; the same listener will act
+// as a port forward target too.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) startListenerLocked(ctx context.Context, startup *apiServerStartup) (*apiServerStartup, error) {
+	wclName, wcl := startup.wclName, startup.wcl
+	startup.insecure = wcl.insecure
 	if wcl.listener != nil {
-		if err := wcl.listener.Close(); err != nil {
-			return errors.Wrapf(err, "failed to stop WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
+		return startup, nil
+	}
+
+	// In dry-run mode the listener is not started.
+	if m.dryRun {
+		startup.noop = true
+		return startup, nil
+	}
+
+	// Listeners can't be started after Shutdown, because Shutdown won't wait for them to exit.
+	select {
+	case <-m.stopCh:
+		return nil, errors.Errorf("failed to start WorkloadClusterListener %s, the workload clusters mux is shut down", wclName)
+	default:
+	}
+
+	if wcl.socketPath != "" {
+		// Remove stale sockets, e.g. left by a previous run.
+		if err := os.Remove(wcl.socketPath); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to remove stale socket for WorkloadClusterListener %s, %s", wclName, wcl.socketPath)
+		}
+	}
+	l := wcl.reservedListener
+	wcl.reservedListener = nil
+	if l == nil {
+		var err error
+		l, err = m.listenConfig().Listen(ctx, wcl.Network(), wcl.HostPort())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to start WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
 		}
 	}
+	if m.maxConnsPerListener > 0 {
+		l = netutil.LimitListener(l, m.maxConnsPerListener)
+	}
+	wcl.listener = l
+	wcl.server = m.newWorkloadClusterServer()
+	m.updateMetricsLocked()
+
+	server := wcl.server
+	startup.server = server
+	address := wcl.Address()
+	port := wcl.Port()
+	serveErrCh := make(chan error, 1)
+	startup.serveErrCh = serveErrCh
+	insecure := wcl.insecure
+	m.goroutines.Add(1)
+	go func() {
+		defer m.goroutines.Done()
+		var err error
+		if insecure {
+			err = server.Serve(l)
+		} else {
+			err = server.ServeTLS(l, "", "")
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.log.Error(err, "WorkloadClusterListener failed", "listenerName", wclName, "address", address, "port", port)
+			serveErrCh <- err
+			m.reportListenerError(ListenerError{ListenerName: wclName, Err: err})
+		}
+	}()
+	return startup, nil
+}
 
-	delete(m.workloadClusterListeners, wclName)
-	delete(m.workloadClusterNameByHost, wcl.HostPort())
+// listenConfig returns the config for binding the workload cluster listeners.
+func (m *WorkloadClustersMux) listenConfig() *net.ListenConfig {
+	return &net.ListenConfig{KeepAlive: m.tcpKeepAlive}
+}
+
+// waitForAPIServerStartup waits until the listener of an API server instance is serving; in case of failure,
+// a listener started while adding the API server instance is stopped and the API server instance is removed.
+// NOTE: m.lock must not be locked when calling this method.
+func (m *WorkloadClustersMux) waitForAPIServerStartup(ctx context.Context, startup *apiServerStartup) error {
+	if startup.noop {
+		return nil
+	}
+	wclName, wcl := startup.wclName, startup.wcl
 
-	m.log.Info("Workload cluster listener deleted", "listenerName", wclName, "address", wcl.Address())
+	// Wait until the sever is working.
+	waitCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+	if err := waitForServer(waitCtx, wcl.Network(), wcl.HostPort(), startup.insecure, startup.serveErrCh); err != nil {
+		m.cleanupAPIServerStartup(wclName, startup.podName, startup.podAdded, startup.server)
+		return errors.Wrapf(err, "failed to start WorkloadClusterListener %s", wclName)
+	}
+
+	m.log.Info("WorkloadClusterListener successfully started", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+	if startup.serveErrCh != nil {
+		m.notifyListenerEvent(ListenerEvent{ListenerName: wclName, Address: wcl.Address(), Type: ListenerStarted})
+	}
 	return nil
 }
 
-// Shutdown shuts down the workload cluster mux.
-func (m *WorkloadClustersMux) Shutdown(ctx context.Context) error {
+// cleanupAPIServerStartup cleans up after a failure while starting an API server instance, by removing
+// the API server instance, if it was added, and by stopping the listener, if it was started.
+func (m *WorkloadClustersMux) cleanupAPIServerStartup(wclName, podName string, podAdded bool, server *http.Server) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if err := m.debugServer.Shutdown(ctx); err != nil {
-		return errors.Wrap(err, "failed to shutdown the debug server")
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return
 	}
 
-	// NOTE: this closes all the listeners
-	if err := m.muxServer.Shutdown(ctx); err != nil {
-		return errors.Wrap(err, "failed to shutdown the mux server")
+	if podAdded {
+		wcl.apiServers.Delete(podName)
 	}
 
-	return nil
+	// NOTE: Stop the listener only if it is the one started by the failed call.
+	if server == nil || wcl.server != server {
+		return
+	}
+	if err := server.Close(); err != nil {
+		m.log.Error(err, "Failed to stop WorkloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+	}
+	wcl.listener = nil
+	wcl.server = nil
+	wcl.idleSince = m.clock.Now()
+	m.updateMetricsLocked()
+	m.log.Info("WorkloadClusterListener stopped because it failed to start", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
 }
 
-// getFreePortLocked gets a free port.
-// Note: m.lock must be locked before calling this method.
-func (m *WorkloadClustersMux) getFreePortLocked() (int, error) {
-	port := m.portIndex
-	if port > m.maxPort {
-		return -1, errors.Errorf("no more free ports in the %d-%d range", m.minPort, m.maxPort)
+// WaitForListener blocks until the WorkloadClusterListener is accepting TLS connections or the context is cancelled.
+// NOTE: The listener is started when the first API server is added, so this can be used by tests to know
+// when it is possible to connect to the workload cluster.
+func (m *WorkloadClustersMux) WaitForListener(ctx context.Context, wclName string) error {
+	m.lock.RLock()
+	wcl, ok := m.workloadClusterListeners[wclName]
+	var insecure bool
+	if ok {
+		insecure = wcl.insecure
+	}
+	m.lock.RUnlock()
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before waiting for it", wclName)
+	}
+	if m.dryRun {
+		return nil
 	}
 
-	// TODO: check the port is actually free. If not try the next one
+	return waitForServer(ctx, wcl.Network(), wcl.HostPort(), insecure, nil)
+}
+
+// waitForServer waits until a TLS handshake with the server at address succeeds, or until a connection can be
+// established for servers serving plain HTTP, instead of assuming the server is accepting connections as soon as it is started.
+// If serveErrCh is not nil, it stops waiting as soon as an error is received from it.
+func waitForServer(ctx context.Context, network, address string, insecure bool, serveErrCh <-chan error) error {
+	var pollErr error
+	err := wait.PollUntilContextCancel(ctx, 10*time.Millisecond, true, func(ctx context.Context) (done bool, err error) {
+		select {
+		case err := <-serveErrCh:
+			return false, err
+		default:
+		}
+
+		d := &net.Dialer{Timeout: 50 * time.Millisecond}
+		var conn net.Conn
+		if insecure {
+			conn, err = d.DialContext(ctx, network, address)
+		} else {
+			conn, err = tls.DialWithDialer(d, network, address, &tls.Config{
+				InsecureSkipVerify: true, //nolint:gosec // config is used to connect to our own port.
+			})
+		}
+		if err != nil {
+			pollErr = fmt.Errorf("server is not reachable: %w", err)
+			return false, nil
+		}
 
-	m.portIndex++
-	return port, nil
+		if err := conn.Close(); err != nil {
+			pollErr = fmt.Errorf("server is not reachable: closing connection: %w", err)
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return kerrors.NewAggregate([]error{err, pollErr})
+	}
+	return nil
+}
+
+// RotateAPIServerCertificate regenerates the API server serving certificate for a WorkloadClusterListener
+// using the given CA, which could be different from the CA used so far.
+// If the CA changes, also the admin certificate is regenerated.
+// NOTE: The certificate is swapped under the lock, so in-flight TLS handshakes keep using the previous certificate
+// while new connections will get the new one.
+func (m *WorkloadClustersMux) RotateAPIServerCertificate(wclName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
+	// Generate certificates before acquiring the lock, so rotating certificates does not block
+	// other listeners nor TLS handshakes; the provisioning lock prevents concurrent changes to the listener.
+	unlock := m.lockListenerProvisioning(wclName)
+	defer unlock()
+
+	m.lock.RLock()
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		m.lock.RUnlock()
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before rotating the APIserver certificate", wclName)
+	}
+	host := wcl.host
+	extraSANs := sets.List(wcl.apiServerExtraSANs)
+	caChanged := wcl.apiServerCaCertificate == nil || !wcl.apiServerCaCertificate.Equal(caCert)
+	m.lock.RUnlock()
+
+	certificate, err := newAPIServerServingCertificate(m.certificateFactory, host, caCert, caKey, m.clock.Now(), m.certificateValidity, extraSANs...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create serving certificate for workloadClusterListener %s", wclName)
+	}
+
+	var adminCertificate *x509.Certificate
+	var adminKey crypto.Signer
+	if caChanged {
+		adminCertificate, adminKey, err = m.newCertificate(caCert, caKey, adminClientCertificateConfig())
+		if err != nil {
+			return errors.Wrapf(err, "failed to create admin certificate for workloadClusterListener %s", wclName)
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok = m.workloadClusterListeners[wclName]
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s was deleted while rotating the APIserver certificate", wclName)
+	}
+
+	if adminCertificate != nil {
+		wcl.adminCertificate = adminCertificate
+		wcl.adminKey = adminKey
+	}
+	wcl.apiServerCaCertificate = caCert
+	wcl.apiServerCaKey = caKey
+	// NOTE: If the last API server was removed while generating certificates, the serving certificate
+	// is not set, so it will be generated again when a new API server instance is added.
+	if wcl.apiServers.Len() == 0 {
+		m.log.Info("APIServer serving certificate not rotated because there are no APIServer left", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+		return nil
+	}
+	wcl.apiServerServingCertificate = certificate
+	m.log.Info("APIServer serving certificate rotated", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+	return nil
+}
+
+// ReloadCA replaces the CA of a WorkloadClusterListener, e.g. when simulating a CA rotation, and regenerates
+// all the serving certificates signed by it, both for the API server and for every etcd member, as well as the admin certificate.
+// NOTE: All the certificates are generated before swapping any of them, so in case of errors the
+// WorkloadClusterListener is left unchanged.
+// NOTE: After the reload both the API server and etcd use the new CA.
+func (m *WorkloadClustersMux) ReloadCA(wclName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
+	// Generate certificates before acquiring the lock, so reloading the CA does not block
+	// other listeners nor TLS handshakes; the provisioning lock prevents concurrent changes to the listener.
+	unlock := m.lockListenerProvisioning(wclName)
+	defer unlock()
+
+	m.lock.RLock()
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		m.lock.RUnlock()
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before reloading the CA", wclName)
+	}
+	host := wcl.host
+	extraSANs := sets.List(wcl.apiServerExtraSANs)
+	hasAPIServerServingCertificate := wcl.apiServerServingCertificate != nil
+	etcdPodNames := []string{}
+	for podName := range wcl.etcdServingCertificates {
+		etcdPodNames = append(etcdPodNames, podName)
+	}
+	m.lock.RUnlock()
+
+	// NOTE: The API server serving certificate is generated only if it exists, so the first API server
+	// added to the listener keeps generating it.
+	var apiServerServingCertificate *tls.Certificate
+	if hasAPIServerServingCertificate {
+		certificate, err := newAPIServerServingCertificate(m.certificateFactory, host, caCert, caKey, m.clock.Now(), m.certificateValidity, extraSANs...)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create serving certificate for workloadClusterListener %s", wclName)
+		}
+		apiServerServingCertificate = certificate
+	}
+
+	adminCertificate, adminKey, err := m.newCertificate(caCert, caKey, adminClientCertificateConfig())
+	if err != nil {
+		return errors.Wrapf(err, "failed to create admin certificate for workloadClusterListener %s", wclName)
+	}
+
+	etcdServingCertificates := map[string]*tls.Certificate{}
+	for _, podName := range etcdPodNames {
+		cert, key, err := m.newCertificate(caCert, caKey, etcdServerCertificateConfig(podName, host, m.etcdServiceName))
+		if err != nil {
+			return errors.Wrapf(err, "failed to create serving certificate for etcd member %s", podName)
+		}
+		etcdServingCertificates[podName] = newTLSCertificate(cert, key)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok = m.workloadClusterListeners[wclName]
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s was deleted while reloading the CA", wclName)
+	}
+
+	// NOTE: API servers and etcd members removed while generating certificates are not added back.
+	if wcl.apiServerServingCertificate == nil {
+		apiServerServingCertificate = nil
+	}
+	for podName := range etcdServingCertificates {
+		if _, ok := wcl.etcdServingCertificates[podName]; !ok {
+			delete(etcdServingCertificates, podName)
+		}
+	}
+
+	wcl.apiServerCaCertificate = caCert
+	wcl.apiServerCaKey = caKey
+	wcl.apiServerServingCertificate = apiServerServingCertificate
+	wcl.adminCertificate = adminCertificate
+	wcl.adminKey = adminKey
+	if wcl.etcdMembers.Len() > 0 {
+		wcl.etcdCaCertificate = caCert
+		wcl.etcdCaKey = caKey
+	}
+	wcl.etcdServingCertificates = etcdServingCertificates
+	m.log.Info("CA reloaded", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+	return nil
+}
+
+// DeleteAPIServer removes an API server instance from the WorkloadClusterListener, e.g. when simulating
+// a control plane machine being deleted or rolled out.
+// When the last API server instance is removed, the listener is stopped and the serving certificate is cleared,
+// so it will be generated again when a new API server instance is added.
+// NOTE: Removing an API server instance that does not exist is a no-op.
+func (m *WorkloadClustersMux) DeleteAPIServer(wclName, podName string) error {
+	// NOTE: The event is fired after releasing the lock.
+	var event *ListenerEvent
+	defer func() {
+		if event != nil {
+			m.notifyListenerEvent(*event)
+		}
+	}()
+
+	// NOTE: The provisioning lock prevents certificates generated concurrently, e.g. by RotateAPIServerCertificate,
+	// from being set after the serving certificate is cleared.
+	unlock := m.lockListenerProvisioning(wclName)
+	defer unlock()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before removing an APIserver", wclName)
+	}
+	if !wcl.apiServers.Has(podName) {
+		return nil
+	}
+	wcl.apiServers.Delete(podName)
+	m.log.Info("APIServer instance removed from the workloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port(), "podName", podName)
+
+	if wcl.apiServers.Len() > 0 {
+		return nil
+	}
+
+	wcl.apiServerServingCertificate = nil
+	wcl.insecure = false
+	if wcl.listener != nil {
+		if err := wcl.server.Close(); err != nil {
+			return errors.Wrapf(err, "failed to stop WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
+		}
+		wcl.listener = nil
+		wcl.server = nil
+		wcl.idleSince = m.clock.Now()
+		m.updateMetricsLocked()
+		m.log.Info("WorkloadClusterListener stopped because there are no APIServer left", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+		event = &ListenerEvent{ListenerName: wclName, Address: wcl.Address(), Type: ListenerStopped}
+	}
+	return nil
+}
+
+// HasAPIServer returns true if the workload cluster already has an apiserver with podName.
+func (m *WorkloadClustersMux) HasAPIServer(wclName, podName string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return false
+	}
+	return wcl.apiServers.Has(podName)
+}
+
+// RegisterMutator registers a func called on objects of the given kind when they are created or updated through
+// the API servers of any WorkloadClusterListener, e.g. to simulate defaulting or mutating webhooks in tests.
+// See api.APIServerHandler.RegisterMutator for more details.
+func (m *WorkloadClustersMux) RegisterMutator(gvk schema.GroupVersionKind, fn func(obj runtime.Object)) {
+	m.apiHandler.RegisterMutator(gvk, fn)
+}
+
+// SetDesiredAPIServers sets the desired number of API server instances behind a WorkloadClusterListener, e.g. to
+// assert a control plane scaling operation converged; a negative number unsets it.
+// NOTE: The desired number of API server instances is only tracked, adding or removing API servers is not prevented.
+func (m *WorkloadClustersMux) SetDesiredAPIServers(wclName string, n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		m.log.Info("Failed to set desired API servers, WorkloadClusterListener does not exist", "listenerName", wclName)
+		return
+	}
+	if n < 0 {
+		wcl.desiredAPIServers = nil
+		m.log.Info("Desired API servers unset for WorkloadClusterListener", "listenerName", wclName)
+		return
+	}
+	wcl.desiredAPIServers = &n
+	m.log.Info("Desired API servers set for WorkloadClusterListener", "listenerName", wclName, "desiredAPIServers", n)
+}
+
+// APIServersConverged returns true if the number of API server instances behind a WorkloadClusterListener
+// is equal to the desired number; if the desired number is not set, false is returned.
+func (m *WorkloadClustersMux) APIServersConverged(wclName string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok || wcl.desiredAPIServers == nil {
+		return false
+	}
+	return wcl.apiServers.Len() == *wcl.desiredAPIServers
+}
+
+// APIServerSpec defines an API server instance to be added to a WorkloadClusterListener, see AddAPIServers.
+type APIServerSpec struct {
+	ListenerName string
+	PodName      string
+	CACert       *x509.Certificate
+	CAKey        *rsa.PrivateKey
+}
+
+// AddAPIServers is like AddAPIServer, but it adds a batch of API server instances acquiring the lock only once,
+// thus reducing lock contention when provisioning many workload clusters concurrently.
+// NOTE: Failures for a spec do not prevent processing the other specs; the returned aggregate error
+// identifies the specs that failed.
+func (m *WorkloadClustersMux) AddAPIServers(specs []APIServerSpec) error {
+	var errs []error
+	var startups []*apiServerStartup
+
+	// Generate certificates before acquiring the lock, so provisioning listeners does not block
+	// other listeners nor TLS handshakes.
+	wclNames := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		wclNames = append(wclNames, spec.ListenerName)
+	}
+	unlock := m.lockListenersProvisioning(wclNames...)
+	defer unlock()
+
+	// NOTE: Specs for the same listener and CA share certificates, because only the first API server added
+	// to a listener requires them.
+	certificates := make([]*apiServerCertificates, len(specs))
+	certificateErrs := make([]error, len(specs))
+	certificatesByListener := map[string]*apiServerCertificates{}
+	for i, spec := range specs {
+		if c := certificatesByListener[spec.ListenerName]; c != nil && spec.CACert != nil && c.caCert.Equal(spec.CACert) {
+			certificates[i] = c
+			continue
+		}
+		certificates[i], certificateErrs[i] = m.newAPIServerCertificates(spec.ListenerName, spec.CACert, spec.CAKey, nil)
+		certificatesByListener[spec.ListenerName] = certificates[i]
+	}
+
+	func() {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		for i, spec := range specs {
+			if certificateErrs[i] != nil {
+				errs = append(errs, errors.Wrapf(certificateErrs[i], "failed to add APIServer %s to WorkloadClusterListener %s (spec %d)", spec.PodName, spec.ListenerName, i))
+				continue
+			}
+			startup, err := m.addAPIServerLocked(context.Background(), spec.ListenerName, spec.PodName, spec.CACert, spec.CAKey, nil, certificates[i])
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "failed to add APIServer %s to WorkloadClusterListener %s (spec %d)", spec.PodName, spec.ListenerName, i))
+				continue
+			}
+			startups = append(startups, startup)
+		}
+	}()
+
+	// NOTE: Listeners are waited for after releasing the lock, because GetCertificate requires it.
+	for _, startup := range startups {
+		if err := m.waitForAPIServerStartup(context.Background(), startup); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to add APIServer %s to WorkloadClusterListener %s", startup.podName, startup.wclName))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// AddEtcdMember mimics adding an etcd Member behind the WorkloadClusterListener;
+// every etcd member gets a dedicated serving certificate, so it will be possible to serve port forward requests
+// to a specific etcd pod/member.
+// NOTE: The etcd CA is stored separately from the API server CA, so the two of them can be different as in real clusters.
+func (m *WorkloadClustersMux) AddEtcdMember(wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) error {
+	// Generate the serving certificate before acquiring the lock, so provisioning a listener does not block
+	// other listeners nor TLS handshakes.
+	unlock := m.lockListenerProvisioning(wclName)
+	defer unlock()
+
+	certificate, err := m.newEtcdServingCertificate(wclName, podName, caCert, caKey)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create serving certificate for etcd member %s", podName)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.addEtcdMemberLocked(wclName, podName, caCert, caKey, certificate)
+}
+
+// addEtcdMemberLocked adds an etcd Member behind the WorkloadClusterListener, using the given serving certificate
+// generated in advance, if any.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) addEtcdMemberLocked(wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey, certificate *tls.Certificate) error {
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before adding an etcd member", wclName)
+	}
+
+	// All the etcd members of a workload cluster must use the same CA, otherwise existing serving certificates
+	// would be silently kept when re-adding an etcd member with a new CA; ReloadCA must be used to change it.
+	// NOTE: Serving certificates are dropped when removing etcd members, so once all the etcd members are removed,
+	// etcd members can be added with a different CA.
+	if wcl.etcdCaCertificate != nil && !wcl.etcdCaCertificate.Equal(caCert) && wcl.etcdMembers.Len() > 0 {
+		return errors.Wrapf(ErrCertificateAuthorityMismatch, "failed to add etcd member %s to WorkloadClusterListener %s: the CA is different from the one used by the existing etcd members, use ReloadCA to change it", podName, wclName)
+	}
+
+	wcl.etcdMembers.Insert(podName)
+	wcl.etcdCaCertificate = caCert
+	wcl.etcdCaKey = caKey
+	m.log.Info("Etcd member added to WorkloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port(), "podName", podName)
+
+	// Generate Serving certificates for the etcdMember
+	if _, ok := wcl.etcdServingCertificates[podName]; !ok {
+		if certificate == nil {
+			config := etcdServerCertificateConfig(podName, wcl.host, m.etcdServiceName)
+			cert, key, err := m.newCertificate(caCert, caKey, config)
+			if err != nil {
+				return errors.Wrapf(err, "failed to create serving certificate for etcd member %s", podName)
+			}
+			certificate = newTLSCertificate(cert, key)
+		}
+		wcl.etcdServingCertificates[podName] = certificate
+	}
+
+	return nil
+}
+
+// newEtcdServingCertificate generates the serving certificate for an etcd member of the WorkloadClusterListener
+// with the given name, if the etcd member does not have one yet.
+// NOTE: The certificate is generated without holding m.lock; nil is returned if the listener does not exist.
+func (m *WorkloadClustersMux) newEtcdServingCertificate(wclName, podName string, caCert *x509.Certificate, caKey *rsa.PrivateKey) (*tls.Certificate, error) {
+	m.lock.RLock()
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		m.lock.RUnlock()
+		return nil, nil
+	}
+	_, hasCertificate := wcl.etcdServingCertificates[podName]
+	caMismatch := wcl.etcdCaCertificate != nil && !wcl.etcdCaCertificate.Equal(caCert) && wcl.etcdMembers.Len() > 0
+	host := wcl.host
+	m.lock.RUnlock()
+
+	// NOTE: The CA mismatch is reported when adding the etcd member.
+	if hasCertificate || caMismatch {
+		return nil, nil
+	}
+	cert, key, err := m.newCertificate(caCert, caKey, etcdServerCertificateConfig(podName, host, m.etcdServiceName))
+	if err != nil {
+		return nil, err
+	}
+	return newTLSCertificate(cert, key), nil
+}
+
+// EtcdMemberSpec defines an etcd member to be added to a WorkloadClusterListener, see AddEtcdMembers.
+type EtcdMemberSpec struct {
+	ListenerName string
+	PodName      string
+	CACert       *x509.Certificate
+	CAKey        *rsa.PrivateKey
+}
+
+// AddEtcdMembers is like AddEtcdMember, but it adds a batch of etcd members acquiring the lock only once,
+// thus reducing lock contention when provisioning many workload clusters concurrently.
+// NOTE: Failures for a spec do not prevent processing the other specs; the returned aggregate error
+// identifies the specs that failed.
+func (m *WorkloadClustersMux) AddEtcdMembers(specs []EtcdMemberSpec) error {
+	// Generate serving certificates before acquiring the lock, so provisioning listeners does not block
+	// other listeners nor TLS handshakes.
+	wclNames := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		wclNames = append(wclNames, spec.ListenerName)
+	}
+	unlock := m.lockListenersProvisioning(wclNames...)
+	defer unlock()
+
+	var errs []error
+	certificates := make([]*tls.Certificate, len(specs))
+	certificateErrs := make([]error, len(specs))
+	for i, spec := range specs {
+		certificates[i], certificateErrs[i] = m.newEtcdServingCertificate(spec.ListenerName, spec.PodName, spec.CACert, spec.CAKey)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, spec := range specs {
+		if certificateErrs[i] != nil {
+			errs = append(errs, errors.Wrapf(certificateErrs[i], "failed to add etcd member %s to WorkloadClusterListener %s (spec %d): failed to create serving certificate", spec.PodName, spec.ListenerName, i))
+			continue
+		}
+		if err := m.addEtcdMemberLocked(spec.ListenerName, spec.PodName, spec.CACert, spec.CAKey, certificates[i]); err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to add etcd member %s to WorkloadClusterListener %s (spec %d)", spec.PodName, spec.ListenerName, i))
+		}
+	}
+	return kerrors.NewAggregate(errs)
+}
+
+// HasEtcdMember returns true if the workload cluster already has an etcd member with podName.
+func (m *WorkloadClustersMux) HasEtcdMember(wclName, podName string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return false
+	}
+	return wcl.etcdMembers.Has(podName)
+}
+
+// DeleteEtcdMember removes an etcd Member from the WorkloadClusterListener.
+func (m *WorkloadClustersMux) DeleteEtcdMember(wclName, podName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before removing an etcd member", wclName)
+	}
+	wcl.etcdMembers.Delete(podName)
+	delete(wcl.etcdServingCertificates, podName)
+	m.log.Info("Etcd member removed from WorkloadClusterListener", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port(), "podName", podName)
+
+	return nil
+}
+
+// ListListeners returns the address of every WorkloadClusterListener, by name.
+func (m *WorkloadClustersMux) ListListeners() map[string]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.listListenersLocked()
+}
+
+// ListListenersWithContext implements api.DebugInfoProvider.
+func (m *WorkloadClustersMux) ListListenersWithContext(ctx context.Context) (map[string]string, error) {
+	if err := m.rLockWithContext(ctx); err != nil {
+		return nil, err
+	}
+	defer m.lock.RUnlock()
+
+	return m.listListenersLocked(), nil
+}
+
+// listListenersLocked returns the address of every WorkloadClusterListener, by name.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) listListenersLocked() map[string]string {
+	ret := map[string]string{}
+	for k, l := range m.workloadClusterListeners {
+		ret[k] = l.Address()
+	}
+	return ret
+}
+
+// AdminKubeconfig returns a kubeconfig for the WorkloadClusterListener with the given name, using the admin
+// certificate generated when adding the first API server.
+// NOTE: Kubeconfig files can't be used to connect to listeners using Unix domain sockets, use the WorkloadClusterListener's
+// RESTConfig instead.
+func (m *WorkloadClustersMux) AdminKubeconfig(wclName string) ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return nil, errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before getting the admin kubeconfig", wclName)
+	}
+	if wcl.socketPath != "" {
+		return nil, errors.Errorf("failed to get admin kubeconfig for workloadClusterListener %s: kubeconfig is not supported for listeners using Unix domain sockets", wclName)
+	}
+	if !wcl.insecure && (wcl.adminCertificate == nil || wcl.adminKey == nil || wcl.apiServerCaCertificate == nil) {
+		return nil, errors.Wrapf(ErrCertificateNotReady, "failed to get admin kubeconfig for workloadClusterListener %s: an API server must be added first", wclName)
+	}
+
+	kubeconfig, err := clientcmd.Write(wcl.kubeConfig(wcl.Address()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to write admin kubeconfig for workloadClusterListener %s", wclName)
+	}
+	return kubeconfig, nil
+}
+
+// APIServerCA returns the CA certificate of the API server of the WorkloadClusterListener with the given name,
+// e.g. to build a trust pool for clients; false is returned if the listener does not exist or no API server has been added yet.
+func (m *WorkloadClustersMux) APIServerCA(wclName string) (*x509.Certificate, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok || wcl.apiServerCaCertificate == nil {
+		return nil, false
+	}
+	return wcl.apiServerCaCertificate, true
+}
+
+// EtcdCA returns the CA certificate of etcd of the WorkloadClusterListener with the given name;
+// false is returned if the listener does not exist or no etcd member has been added yet.
+func (m *WorkloadClustersMux) EtcdCA(wclName string) (*x509.Certificate, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok || wcl.etcdCaCertificate == nil {
+		return nil, false
+	}
+	return wcl.etcdCaCertificate, true
+}
+
+// ListenerAddress returns the host and port of the WorkloadClusterListener with the given name, which is also the
+// name of the corresponding resource group; ok is false if the listener does not exist.
+// NOTE: For listeners using a Unix domain socket, the port is 0.
+func (m *WorkloadClustersMux) ListenerAddress(wclName string) (host string, port int, ok bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return "", 0, false
+	}
+	return wcl.Host(), wcl.Port(), true
+}
+
+// ListenerDetails returns details about every WorkloadClusterListener, by name.
+func (m *WorkloadClustersMux) ListenerDetails() map[string]api.ListenerDetails {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.listenerDetailsLocked()
+}
+
+// ListenerDetailsWithContext implements api.DebugInfoProvider.
+func (m *WorkloadClustersMux) ListenerDetailsWithContext(ctx context.Context) (map[string]api.ListenerDetails, error) {
+	if err := m.rLockWithContext(ctx); err != nil {
+		return nil, err
+	}
+	defer m.lock.RUnlock()
+
+	return m.listenerDetailsLocked(), nil
+}
+
+// listenerDetailsLocked returns details about every WorkloadClusterListener, by name.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) listenerDetailsLocked() map[string]api.ListenerDetails {
+	ret := map[string]api.ListenerDetails{}
+	for wclName, wcl := range m.workloadClusterListeners {
+		details := api.ListenerDetails{
+			Address: wcl.Address(),
+		}
+		if cert := leafCertificate(wcl.apiServerServingCertificate); cert != nil {
+			notAfter := metav1.NewTime(cert.NotAfter)
+			details.APIServerCertificateNotAfter = &notAfter
+		}
+		for serverName, certificate := range wcl.etcdServingCertificates {
+			if cert := leafCertificate(certificate); cert != nil {
+				if details.EtcdCertificatesNotAfter == nil {
+					details.EtcdCertificatesNotAfter = map[string]metav1.Time{}
+				}
+				details.EtcdCertificatesNotAfter[serverName] = metav1.NewTime(cert.NotAfter)
+			}
+		}
+		details.APIServers = wcl.apiServers.Len()
+		if wcl.desiredAPIServers != nil {
+			desired := *wcl.desiredAPIServers
+			details.DesiredAPIServers = &desired
+		}
+		details.Requests = wcl.requestStats.requests.Load()
+		if errorTime, message, ok := wcl.requestStats.lastError(); ok {
+			details.LastError = &api.ListenerLastError{Time: metav1.NewTime(errorTime), Message: message}
+		}
+		ret[wclName] = details
+	}
+	return ret
+}
+
+// getRequestStats returns the requestStats of the WorkloadClusterListener with the given name, if any.
+func (m *WorkloadClustersMux) getRequestStats(wclName string) *requestStats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return nil
+	}
+	return wcl.requestStats
+}
+
+// ListenerLimits implements api.DebugInfoProvider.
+func (m *WorkloadClustersMux) ListenerLimits() map[string]int {
+	return map[string]int{
+		"maxConnsPerListener": m.maxConnsPerListener,
+	}
+}
+
+// PortStats returns the utilization of the port range used by the workload clusters listeners, e.g. to
+// check in advance how many workload clusters can still be created.
+// NOTE: Ports skipped because in use by other processes are counted as used.
+func (m *WorkloadClustersMux) PortStats() (used, free, total int) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.portStatsLocked()
+}
+
+// portStatsLocked returns the utilization of the port range used by the workload clusters listeners.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) portStatsLocked() (used, free, total int) {
+	total = m.maxPort - m.minPort + 1
+	free = m.maxPort - m.portIndex + 1 + m.freePorts.Len()
+	return total - free, free, total
+}
+
+// CertVerificationError reports a certificate that doesn't chain to the CA stored for a WorkloadClusterListener or that is expired.
+type CertVerificationError struct {
+	// ListenerName is the name of the WorkloadClusterListener owning the certificate.
+	ListenerName string
+	// Certificate identifies the certificate being verified, e.g. "apiserver", "admin" or "etcd/<podName>".
+	Certificate string
+	// Err is the verification error.
+	Err error
+}
+
+// Error implements error.
+func (e CertVerificationError) Error() string {
+	return fmt.Sprintf("certificate %s for workloadClusterListener %s is not valid: %v", e.Certificate, e.ListenerName, e.Err)
+}
+
+// VerifyCertificates checks that, for each WorkloadClusterListener, the API server serving certificate and
+// the admin certificate chain to the stored API server CA, that etcd serving certificates chain to the stored etcd CA
+// and that none of the certificates is expired.
+func (m *WorkloadClustersMux) VerifyCertificates() []CertVerificationError {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.verifyCertificatesLocked()
+}
+
+// verifyCertificatesLocked verifies the certificates of every WorkloadClusterListener.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) verifyCertificatesLocked() []CertVerificationError {
+	now := m.clock.Now()
+	ret := []CertVerificationError{}
+	for wclName, wcl := range m.workloadClusterListeners {
+		if wcl.etcdCaCertificate != nil {
+			etcdRoots := x509.NewCertPool()
+			etcdRoots.AddCert(wcl.etcdCaCertificate)
+
+			for podName, c := range wcl.etcdServingCertificates {
+				if err := verifyTLSCertificate(c, etcdRoots, now, x509.ExtKeyUsageServerAuth); err != nil {
+					ret = append(ret, CertVerificationError{ListenerName: wclName, Certificate: fmt.Sprintf("etcd/%s", podName), Err: err})
+				}
+			}
+		}
+
+		if wcl.apiServerCaCertificate == nil {
+			continue
+		}
+
+		roots := x509.NewCertPool()
+		roots.AddCert(wcl.apiServerCaCertificate)
+
+		if wcl.apiServerServingCertificate != nil {
+			if err := verifyTLSCertificate(wcl.apiServerServingCertificate, roots, now, x509.ExtKeyUsageServerAuth); err != nil {
+				ret = append(ret, CertVerificationError{ListenerName: wclName, Certificate: "apiserver", Err: err})
+			}
+		}
+
+		if wcl.adminCertificate != nil {
+			if err := verifyCertificate(wcl.adminCertificate, roots, now, x509.ExtKeyUsageClientAuth); err != nil {
+				ret = append(ret, CertVerificationError{ListenerName: wclName, Certificate: "admin", Err: err})
+			}
+		}
+	}
+	return ret
+}
+
+// ListCertificateErrors returns the errors found verifying the certificates of every WorkloadClusterListener, by name.
+func (m *WorkloadClustersMux) ListCertificateErrors() map[string][]string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.listCertificateErrorsLocked()
+}
+
+// ListCertificateErrorsWithContext implements api.DebugInfoProvider.
+func (m *WorkloadClustersMux) ListCertificateErrorsWithContext(ctx context.Context) (map[string][]string, error) {
+	if err := m.rLockWithContext(ctx); err != nil {
+		return nil, err
+	}
+	defer m.lock.RUnlock()
+
+	return m.listCertificateErrorsLocked(), nil
+}
+
+// listCertificateErrorsLocked returns the errors found verifying the certificates of every WorkloadClusterListener, by name.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) listCertificateErrorsLocked() map[string][]string {
+	ret := map[string][]string{}
+	for _, e := range m.verifyCertificatesLocked() {
+		ret[e.ListenerName] = append(ret[e.ListenerName], e.Error())
+	}
+	return ret
+}
+
+// rLockWithContext locks m.lock for reading, unless the context is done before the lock is acquired.
+func (m *WorkloadClustersMux) rLockWithContext(ctx context.Context) error {
+	for !m.lock.TryRLock() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// DeleteWorkloadClusterListener deletes a WorkloadClusterListener, stopping it if it was started.
+// NOTE: It is safe to call this method for listeners that only have a port reserved (no API server added yet)
+// as well as for listeners that do not exist.
+func (m *WorkloadClustersMux) DeleteWorkloadClusterListener(wclName string) error {
+	event, err := func() (*ListenerEvent, error) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		wcl, ok := m.workloadClusterListeners[wclName]
+		if !ok {
+			return nil, nil
+		}
+		started := wcl.listener != nil
+
+		if err := m.deleteWorkloadClusterListenerLocked(wclName); err != nil {
+			return nil, err
+		}
+		if !started {
+			return nil, nil
+		}
+		return &ListenerEvent{ListenerName: wclName, Address: wcl.Address(), Type: ListenerStopped}, nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	if event != nil {
+		m.notifyListenerEvent(*event)
+	}
+	return nil
+}
+
+// deleteWorkloadClusterListenerLocked deletes a WorkloadClusterListener.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) deleteWorkloadClusterListenerLocked(wclName string) error {
+	wcl, ok := m.workloadClusterListeners[wclName]
+	if !ok {
+		return nil
+	}
+
+	if wcl.listener != nil {
+		if err := wcl.server.Close(); err != nil {
+			return errors.Wrapf(err, "failed to stop WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
+		}
+	}
+	if wcl.reservedListener != nil {
+		if err := wcl.reservedListener.Close(); err != nil {
+			return errors.Wrapf(err, "failed to release the port of WorkloadClusterListener %s, %s", wclName, wcl.HostPort())
+		}
+		wcl.reservedListener = nil
+	}
+
+	delete(m.workloadClusterListeners, wclName)
+	delete(m.workloadClusterNameByHost, wcl.HostPort())
+	m.releasePortLocked(wcl.port)
+	m.updateMetricsLocked()
+	m.deleteRequestMetricsLocked(wclName)
+
+	m.log.Info("Workload cluster listener deleted", "listenerName", wclName, "address", wcl.Address(), "port", wcl.Port())
+	return nil
+}
+
+// StopListener stops a WorkloadClusterListener without impacting other listeners; the listener stops accepting
+// new connections, and in-flight requests are drained until the context deadline.
+// NOTE: The port stays reserved for the workload cluster, and the listener will be started again
+// when an API server is added.
+func (m *WorkloadClustersMux) StopListener(ctx context.Context, wclName string) error {
+	var address string
+	server, err := func() (*http.Server, error) {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		wcl, ok := m.workloadClusterListeners[wclName]
+		if !ok {
+			return nil, errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before stopping it", wclName)
+		}
+
+		server := wcl.server
+		address = wcl.Address()
+		wcl.listener = nil
+		wcl.server = nil
+		wcl.idleSince = m.clock.Now()
+		m.updateMetricsLocked()
+		return server, nil
+	}()
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return nil
+	}
+
+	// NOTE: The lock must not be held while draining, because in-flight requests need it.
+	// NOTE: The listener is closed even if draining fails, so the event is fired in any case.
+	err = server.Shutdown(ctx)
+	m.notifyListenerEvent(ListenerEvent{ListenerName: wclName, Address: address, Type: ListenerStopped})
+	if err != nil {
+		return errors.Wrapf(err, "failed to stop WorkloadClusterListener %s", wclName)
+	}
+
+	m.log.Info("WorkloadClusterListener stopped", "listenerName", wclName, "address", address)
+	return nil
+}
+
+// RestartListener stops and starts again a WorkloadClusterListener, regenerating the API server serving certificate,
+// e.g. for interactive debugging; the address of the listener is returned.
+// NOTE: The listener keeps the same port; differently from HotRestart, RestartListener operates on a single listener
+// at runtime. This method implements api.DebugInfoProvider.
+func (m *WorkloadClustersMux) RestartListener(ctx context.Context, wclName string) (string, error) {
+	var podName string
+	var caCert *x509.Certificate
+	var caKey *rsa.PrivateKey
+	var insecure bool
+	err := func() error {
+		m.lock.RLock()
+		defer m.lock.RUnlock()
+
+		wcl, ok := m.workloadClusterListeners[wclName]
+		if !ok {
+			return errors.Wrapf(ErrListenerNotFound, "workloadClusterListener with name %s must be initialized before restarting it", wclName)
+		}
+		if wcl.apiServers.Len() == 0 || (!wcl.insecure && wcl.apiServerCaCertificate == nil) {
+			return errors.Errorf("failed to restart WorkloadClusterListener %s: an API server must be added first", wclName)
+		}
+		podName = sets.List(wcl.apiServers)[0]
+		caCert = wcl.apiServerCaCertificate
+		caKey = wcl.apiServerCaKey
+		insecure = wcl.insecure
+		return nil
+	}()
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.StopListener(ctx, wclName); err != nil {
+		return "", errors.Wrapf(err, "failed to restart WorkloadClusterListener %s", wclName)
+	}
+	// NOTE: Adding an existing API server starts the listener again.
+	if insecure {
+		if err := m.AddAPIServerInsecure(wclName, podName); err != nil {
+			return "", errors.Wrapf(err, "failed to restart WorkloadClusterListener %s", wclName)
+		}
+	} else {
+		if err := m.RotateAPIServerCertificate(wclName, caCert, caKey); err != nil {
+			return "", errors.Wrapf(err, "failed to restart WorkloadClusterListener %s", wclName)
+		}
+		if err := m.AddAPIServerWithContext(ctx, wclName, podName, caCert, caKey); err != nil {
+			return "", errors.Wrapf(err, "failed to restart WorkloadClusterListener %s", wclName)
+		}
+	}
+
+	address, ok := m.ListListeners()[wclName]
+	if !ok {
+		return "", errors.Wrapf(ErrListenerNotFound, "failed to get the address of WorkloadClusterListener %s after restart", wclName)
+	}
+	m.log.Info("WorkloadClusterListener restarted", "listenerName", wclName, "address", address)
+	return address, nil
+}
+
+// ListenerErrors returns a channel reporting workload cluster listeners whose serve loop exited unexpectedly,
+// e.g. to fail a test instead of hanging when a listener dies.
+// NOTE: The channel is buffered; errors are dropped if the buffer is full.
+func (m *WorkloadClustersMux) ListenerErrors() <-chan ListenerError {
+	return m.listenerErrors
+}
+
+// reportListenerError reports a ListenerError without blocking.
+func (m *WorkloadClustersMux) reportListenerError(err ListenerError) {
+	select {
+	case m.listenerErrors <- err:
+	default:
+		m.log.Info("Dropping WorkloadClusterListener error, the buffer is full", "listenerName", err.ListenerName, "err", err.Err.Error())
+	}
+}
+
+// notifyListenerEvent calls the listener event handler, if any.
+// NOTE: This method must be called without holding m.lock, so the handler can call the workload clusters mux.
+func (m *WorkloadClustersMux) notifyListenerEvent(event ListenerEvent) {
+	if m.listenerEventHandler == nil {
+		return
+	}
+	m.listenerEventHandler(event)
+}
+
+// Shutdown shuts down the workload cluster mux.
+// NOTE: The debug server and the servers of all the listeners are shut down concurrently, each one honoring the
+// context, so a hung server does not block the others; errors are aggregated, and they identify the server
+// that failed. The debug server or the listeners not started are ignored.
+// NOTE: Shutdown returns after the goroutines serving the listeners have exited, and listeners can't be started afterwards.
+func (m *WorkloadClustersMux) Shutdown(ctx context.Context) error {
+	servers := func() map[string]*http.Server {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		select {
+		case <-m.stopCh:
+		default:
+			close(m.stopCh)
+		}
+
+		// Drop the metrics of the workload clusters mux, so they are not reported after shutdown.
+		listenersActive.DeleteLabelValues(m.metricsID)
+		portsFree.DeleteLabelValues(m.metricsID)
+		for wclName := range m.workloadClusterListeners {
+			m.deleteRequestMetricsLocked(wclName)
+		}
+
+		servers := map[string]*http.Server{"debug server": &m.debugServer}
+		for wclName, wcl := range m.workloadClusterListeners {
+			// Release ports bound but not used yet by an API server.
+			if wcl.reservedListener != nil {
+				_ = wcl.reservedListener.Close()
+				wcl.reservedListener = nil
+			}
+			if wcl.server == nil {
+				continue
+			}
+			servers[fmt.Sprintf("mux server for WorkloadClusterListener %s", wclName)] = wcl.server
+		}
+		return servers
+	}()
+
+	// NOTE: m.lock is not held while shutting down servers, so in-flight requests can complete.
+	// NOTE: this closes all the listeners
+	errCh := make(chan error, len(servers))
+	wg := sync.WaitGroup{}
+	for name, server := range servers {
+		wg.Add(1)
+		go func(name string, server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				errCh <- errors.Wrapf(err, "failed to shutdown the %s", name)
+			}
+		}(name, server)
+	}
+	wg.Wait()
+	close(errCh)
+
+	// Wait for the goroutines serving the listeners to exit; shutting down the servers closes all the
+	// listeners, so Serve returns immediately.
+	m.goroutines.Wait()
+
+	errs := []error{}
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return kerrors.NewAggregate(errs)
+}
+
+// getFreePortLocked gets a free port; ports previously released are reused before picking a new one from the range.
+// Ports which are already bound by other processes are skipped, except in dry-run mode.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) getFreePortLocked(host string) (int, error) {
+	for _, port := range sets.List(m.freePorts) {
+		if m.dryRun || isPortAvailable(host, port) {
+			m.freePorts.Delete(port)
+			return port, nil
+		}
+	}
+	for _, port := range sets.List(m.busyPorts) {
+		if isPortAvailable(host, port) {
+			m.busyPorts.Delete(port)
+			return port, nil
+		}
+	}
+
+	for m.portIndex <= m.maxPort {
+		port := m.portIndex
+		m.portIndex++
+		if m.dryRun || isPortAvailable(host, port) {
+			return port, nil
+		}
+
+		// Keep track of the port, so it can be used if it becomes available later.
+		m.log.V(4).Info("Skipping port already in use", "port", port)
+		m.busyPorts.Insert(port)
+	}
+
+	return -1, errors.Errorf("port range exhausted, no more free ports in the %d-%d range; increase the range using WithPortRange", m.minPort, m.maxPort)
+}
+
+// isPortAvailable checks if it is possible to bind a port.
+func isPortAvailable(host string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
+
+// releasePortLocked makes a port available for reuse.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) releasePortLocked(port int) {
+	// Ports assigned by the OS are not part of the port range.
+	// NOTE: In dry-run mode ports are always taken from the port range, also when using ephemeral ports.
+	if m.ephemeralPorts && !m.dryRun {
+		return
+	}
+	if port < m.minPort || port >= m.portIndex {
+		return
+	}
+	m.freePorts.Insert(port)
+}
+
+// deleteRequestMetricsLocked drops the request metrics of a WorkloadClusterListener.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) deleteRequestMetricsLocked(wclName string) {
+	requestTotal.DeleteLabelValues(m.metricsID, wclName, "apiserver")
+	requestTotal.DeleteLabelValues(m.metricsID, wclName, "etcd")
+}
+
+// updateMetricsLocked updates the metrics about listeners and ports.
+// Note: m.lock must be locked before calling this method.
+func (m *WorkloadClustersMux) updateMetricsLocked() {
+	active := 0
+	for _, wcl := range m.workloadClusterListeners {
+		if wcl.listener != nil {
+			active++
+		}
+	}
+	listenersActive.WithLabelValues(m.metricsID).Set(float64(active))
+	_, free, _ := m.portStatsLocked()
+	portsFree.WithLabelValues(m.metricsID).Set(float64(free))
 }
@@ -18,31 +18,66 @@ package server
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-logr/logr/funcr"
 	. "github.com/onsi/gomega"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.uber.org/goleak"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+	clocktesting "k8s.io/utils/clock/testing"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
+	infrav1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/api/v1alpha1"
 	cloudv1 "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/api/v1alpha1"
 	cmanager "sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/cloud/runtime/manager"
+	"sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/server/api"
 	"sigs.k8s.io/cluster-api/test/infrastructure/inmemory/internal/server/proxy"
 	"sigs.k8s.io/cluster-api/util/certs"
 )
@@ -66,14 +101,13 @@ func TestMux(t *testing.T) {
 
 	manager := cmanager.New(scheme)
 
-	wcl := "workload-cluster"
+	wcl := "workload-cluster1"
 	host := "127.0.0.1" //nolint:goconst
-	wcmux, err := NewWorkloadClustersMux(manager, host, CustomPorts{
+	wcmux, err := NewWorkloadClustersMux(manager, host,
 		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-		MinPort:   DefaultMinPort,
-		MaxPort:   DefaultMinPort + 99,
-		DebugPort: DefaultDebugPort,
-	})
+		WithPortRange(DefaultMinPort, DefaultMinPort+99),
+		WithDebugPort(DefaultDebugPort),
+	)
 	g.Expect(err).ToNot(HaveOccurred())
 
 	listener, err := wcmux.InitWorkloadClusterListener(wcl)
@@ -126,12 +160,11 @@ func TestAPI_corev1_CRUD(t *testing.T) {
 	t.Parallel()
 	g := NewWithT(t)
 
-	wcmux, c := setupWorkloadClusterListener(g, CustomPorts{
+	wcmux, c := setupWorkloadClusterListener(g,
 		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-		MinPort:   DefaultMinPort + 100,
-		MaxPort:   DefaultMinPort + 199,
-		DebugPort: DefaultDebugPort + 1,
-	})
+		WithPortRange(DefaultMinPort+100, DefaultMinPort+199),
+		WithDebugPort(DefaultDebugPort+1),
+	)
 
 	// create
 
@@ -203,12 +236,11 @@ func TestAPI_rbacv1_CRUD(t *testing.T) {
 	t.Parallel()
 	g := NewWithT(t)
 
-	wcmux, c := setupWorkloadClusterListener(g, CustomPorts{
+	wcmux, c := setupWorkloadClusterListener(g,
 		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-		MinPort:   DefaultMinPort + 200,
-		MaxPort:   DefaultMinPort + 299,
-		DebugPort: DefaultDebugPort + 2,
-	})
+		WithPortRange(DefaultMinPort+200, DefaultMinPort+299),
+		WithDebugPort(DefaultDebugPort+2),
+	)
 
 	// create
 
@@ -255,12 +287,11 @@ func TestAPI_PortForward(t *testing.T) {
 
 	// TODO: deduplicate this setup code with the test above
 	host := "127.0.0.1"
-	wcmux, err := NewWorkloadClustersMux(manager, host, CustomPorts{
+	wcmux, err := NewWorkloadClustersMux(manager, host,
 		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-		MinPort:   DefaultMinPort + 300,
-		MaxPort:   DefaultMinPort + 399,
-		DebugPort: DefaultDebugPort + 3,
-	})
+		WithPortRange(DefaultMinPort+300, DefaultMinPort+399),
+		WithDebugPort(DefaultDebugPort+3),
+	)
 	g.Expect(err).ToNot(HaveOccurred())
 
 	// InfraCluster controller >> when "creating the load balancer"
@@ -337,7 +368,7 @@ func TestAPI_PortForward(t *testing.T) {
 	caPool.AddCert(etcdCert)
 
 	config := apiServerEtcdClientCertificateConfig()
-	cert, key, err := newCertAndKey(etcdCert, etcdKey, config)
+	cert, key, err := newCertAndKey(etcdCert, etcdKey, config, time.Now(), certs.DefaultCertDuration)
 	g.Expect(err).ToNot(HaveOccurred())
 
 	clientCert, err := tls.X509KeyPair(certs.EncodeCertPEM(cert), certs.EncodePrivateKeyPEM(key))
@@ -371,8 +402,38 @@ func TestAPI_PortForward(t *testing.T) {
 
 	ml, err := etcdClient1.MemberList(ctx)
 	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ml.Header.MemberId).To(Equal(uint64(2)))
 	g.Expect(ml.Members).To(HaveLen(1))
 	g.Expect(ml.Members[0].Name).To(Equal("1"))
+	g.Expect(ml.Members[0].ClientURLs).To(ConsistOf(fmt.Sprintf("https://%s", listener.HostPort())))
+	g.Expect(ml.Members[0].PeerURLs).To(ConsistOf(fmt.Sprintf("https://%s:2380", listener.Host())))
+
+	// Etcd pods are members only after they are added to the listener.
+	etcdPod2 := etcdPod.DeepCopy()
+	etcdPod2.ResourceVersion = ""
+	etcdPod2.Name = "etcd-2"
+	etcdPod2.Annotations[cloudv1.EtcdMemberIDAnnotationName] = "3"
+	delete(etcdPod2.Annotations, cloudv1.EtcdLeaderFromAnnotationName)
+	err = manager.GetResourceGroup(wcl1).GetClient().Create(ctx, etcdPod2)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ml, err = etcdClient1.MemberList(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ml.Members).To(HaveLen(1))
+
+	err = wcmux.AddEtcdMember(wcl1, "etcd-2", etcdCert, etcdKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ml, err = etcdClient1.MemberList(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ml.Members).To(HaveLen(2))
+
+	err = wcmux.DeleteEtcdMember(wcl1, "etcd-2")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ml, err = etcdClient1.MemberList(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ml.Members).To(HaveLen(1))
 
 	err = etcdClient1.Close()
 	g.Expect(err).ToNot(HaveOccurred())
@@ -384,12 +445,11 @@ func TestAPI_PortForward(t *testing.T) {
 func TestAPI_corev1_Watch(t *testing.T) {
 	g := NewWithT(t)
 
-	_, c := setupWorkloadClusterListener(g, CustomPorts{
+	_, c := setupWorkloadClusterListener(g,
 		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
-		MinPort:   DefaultMinPort + 400,
-		MaxPort:   DefaultMinPort + 499,
-		DebugPort: DefaultDebugPort + 4,
-	})
+		WithPortRange(DefaultMinPort+400, DefaultMinPort+499),
+		WithDebugPort(DefaultDebugPort+4),
+	)
 
 	ctx := context.Background()
 
@@ -479,11 +539,4473 @@ func TestAPI_corev1_Watch(t *testing.T) {
 	g.Expect(receivedEvents).To(Equal(expectedEvents))
 }
 
-func setupWorkloadClusterListener(g Gomega, ports CustomPorts) (*WorkloadClustersMux, client.WithWatch) {
+func TestAPI_corev1_WatchNamespace(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4900, DefaultMinPort+4999),
+		WithDebugPort(DefaultDebugPort+61),
+	)
+
+	// Watch pods in a single namespace.
+	podWatcher, err := c.Watch(ctx, &corev1.PodList{}, client.InNamespace("one"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, namespace := range []string{"two", "one"} {
+		pod := &corev1.Pod{}
+		pod.SetName(fmt.Sprintf("pod-%s", namespace))
+		pod.SetNamespace(namespace)
+		g.Expect(c.Create(ctx, pod)).To(Succeed())
+	}
+
+	// Only events for pods in the watched namespace are received.
+	var event watch.Event
+	g.Eventually(podWatcher.ResultChan(), 5*time.Second).Should(Receive(&event))
+	g.Expect(event.Type).To(Equal(watch.Added))
+	g.Expect(event.Object.(client.Object).GetName()).To(Equal("pod-one"))
+	g.Consistently(podWatcher.ResultChan(), 500*time.Millisecond).ShouldNot(Receive())
+	podWatcher.Stop()
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_corev1_ListSelectors(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5000, DefaultMinPort+5099),
+		WithDebugPort(DefaultDebugPort+62),
+	)
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		pod := &corev1.Pod{}
+		pod.SetName(name)
+		pod.SetNamespace("one")
+		pod.SetLabels(map[string]string{"name": name})
+		g.Expect(c.Create(ctx, pod)).To(Succeed())
+	}
+
+	podNames := func(opts ...client.ListOption) []string {
+		pl := &corev1.PodList{}
+		g.Expect(c.List(ctx, pl, opts...)).To(Succeed())
+		names := []string{}
+		for _, pod := range pl.Items {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+
+	g.Expect(podNames()).To(ConsistOf("foo", "bar", "baz"))
+	g.Expect(podNames(client.MatchingFields{"metadata.name": "foo"})).To(ConsistOf("foo"))
+	g.Expect(podNames(client.MatchingFields{"metadata.namespace": "two"})).To(BeEmpty())
+	g.Expect(podNames(client.MatchingLabels{"name": "bar"})).To(ConsistOf("bar"))
+
+	setSelector, err := labels.Parse("name in (bar,baz)")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(podNames(client.MatchingLabelsSelector{Selector: setSelector})).To(ConsistOf("bar", "baz"))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_corev1_ResourceVersion(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5100, DefaultMinPort+5199),
+		WithDebugPort(DefaultDebugPort+63),
+	)
+
+	node := &corev1.Node{}
+	node.SetName("foo")
+	g.Expect(c.Create(ctx, node)).To(Succeed())
+
+	nodeList := &corev1.NodeList{}
+	g.Expect(c.List(ctx, nodeList)).To(Succeed())
+	listResourceVersion := nodeList.ResourceVersion
+	g.Expect(listResourceVersion).To(Equal(node.ResourceVersion))
+
+	for _, name := range []string{"bar", "baz"} {
+		node := &corev1.Node{}
+		node.SetName(name)
+		g.Expect(c.Create(ctx, node)).To(Succeed())
+	}
+
+	// Watches starting from a resource version receive the events happened after it.
+	nodeWatcher, err := c.Watch(ctx, &corev1.NodeList{}, &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: listResourceVersion}})
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, name := range []string{"bar", "baz"} {
+		var event watch.Event
+		g.Eventually(nodeWatcher.ResultChan(), 5*time.Second).Should(Receive(&event))
+		g.Expect(event.Type).To(Equal(watch.Added))
+		g.Expect(event.Object.(client.Object).GetName()).To(Equal(name))
+	}
+	nodeWatcher.Stop()
+
+	// Generate enough events to make the resource version expire.
+	cloudClient := wcmux.manager.GetResourceGroup("workload-cluster1").GetClient()
+	for i := 0; i < 1000; i++ {
+		node := &corev1.Node{}
+		node.SetName(fmt.Sprintf("node-%d", i))
+		g.Expect(cloudClient.Create(ctx, node)).To(Succeed())
+	}
+
+	nodeWatcher, err = c.Watch(ctx, &corev1.NodeList{}, &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: listResourceVersion}})
+	g.Expect(err).ToNot(HaveOccurred())
+	var event watch.Event
+	g.Eventually(nodeWatcher.ResultChan(), 5*time.Second).Should(Receive(&event))
+	g.Expect(event.Type).To(Equal(watch.Error))
+	g.Expect(apierrors.IsResourceExpired(apierrors.FromObject(event.Object))).To(BeTrue())
+	nodeWatcher.Stop()
+
+	err = c.List(ctx, &corev1.NodeList{}, &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: listResourceVersion}})
+	g.Expect(apierrors.IsResourceExpired(err)).To(BeTrue())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_corev1_ServerSideApply(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5200, DefaultMinPort+5299),
+		WithDebugPort(DefaultDebugPort+64),
+	)
+
+	applyPod := func(fieldManager string, labels map[string]string, containers ...corev1.Container) (*corev1.Pod, error) {
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Pod",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "foo",
+				Namespace: "default",
+				Labels:    labels,
+			},
+			Spec: corev1.PodSpec{
+				Containers: containers,
+			},
+		}
+		return pod, c.Patch(ctx, pod, client.Apply, client.FieldOwner(fieldManager))
+	}
+
+	// Apply creates the object.
+	pod, err := applyPod("manager-1", map[string]string{"a": "a"}, corev1.Container{Name: "a", Image: "a:v1"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pod.ResourceVersion).ToNot(BeEmpty())
+	g.Expect(pod.ManagedFields).To(HaveLen(1))
+
+	// Another field manager adds a label and a container.
+	pod, err = applyPod("manager-2", map[string]string{"b": "b"}, corev1.Container{Name: "b", Image: "b:v1"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pod.Labels).To(Equal(map[string]string{"a": "a", "b": "b"}))
+	g.Expect(pod.Spec.Containers).To(HaveLen(2))
+
+	// The first field manager changes its container and drops its label.
+	pod, err = applyPod("manager-1", nil, corev1.Container{Name: "a", Image: "a:v2"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(pod.Labels).To(Equal(map[string]string{"b": "b"}))
+	g.Expect(pod.Spec.Containers).To(ConsistOf(
+		HaveField("Image", "a:v2"),
+		HaveField("Image", "b:v1"),
+	))
+
+	// Changing a field owned by another field manager conflicts.
+	_, err = applyPod("manager-2", map[string]string{"b": "b"}, corev1.Container{Name: "a", Image: "a:v3"}, corev1.Container{Name: "b", Image: "b:v1"})
+	g.Expect(apierrors.IsConflict(err)).To(BeTrue())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestVerifyCertificates(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, _ := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+500, DefaultMinPort+599),
+		WithDebugPort(DefaultDebugPort+5),
+	)
+
+	// A consistent listener doesn't report errors.
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+	g.Expect(wcmux.ListCertificateErrors()).To(BeEmpty())
+
+	// Override the API server serving certificate with one signed by a different CA.
+	wrongCACert, wrongCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cert, key, err := newCertAndKey(wrongCACert, wrongCAKey, apiServerCertificateConfig("127.0.0.1"), time.Now(), certs.DefaultCertDuration)
+	g.Expect(err).ToNot(HaveOccurred())
+	certificate, err := tls.X509KeyPair(certs.EncodeCertPEM(cert), certs.EncodePrivateKeyPEM(key))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.Lock()
+	wcmux.workloadClusterListeners["workload-cluster1"].apiServerServingCertificate = &certificate
+	wcmux.lock.Unlock()
+
+	verificationErrors := wcmux.VerifyCertificates()
+	g.Expect(verificationErrors).To(HaveLen(1))
+	g.Expect(verificationErrors[0].ListenerName).To(Equal("workload-cluster1"))
+	g.Expect(verificationErrors[0].Certificate).To(Equal("apiserver"))
+	g.Expect(wcmux.ListCertificateErrors()).To(HaveKey("workload-cluster1"))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestDeleteWorkloadClusterListener(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+600, DefaultMinPort+699),
+		WithDebugPort(DefaultDebugPort+6),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Delete a listener which only has a port reserved.
+	reserved := "reserved-cluster"
+	_, err = wcmux.InitWorkloadClusterListener(reserved)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.ListListeners()).To(HaveKey(reserved))
+
+	err = wcmux.DeleteWorkloadClusterListener(reserved)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.ListListeners()).ToNot(HaveKey(reserved))
+
+	// Delete a listener which is started.
+	started := "started-cluster"
+	listener, err := wcmux.InitWorkloadClusterListener(started)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(started, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.DeleteWorkloadClusterListener(started)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.ListListeners()).ToNot(HaveKey(started))
+
+	wcmux.lock.RLock()
+	g.Expect(wcmux.workloadClusterNameByHost).ToNot(HaveKey(listener.HostPort()))
+	wcmux.lock.RUnlock()
+
+	_, err = net.DialTimeout("tcp", listener.HostPort(), 100*time.Millisecond)
+	g.Expect(err).To(HaveOccurred())
+
+	// Delete a listener which doesn't exist.
+	err = wcmux.DeleteWorkloadClusterListener("does-not-exist")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestIdleListenerReaper(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+700, DefaultMinPort+799),
+		WithDebugPort(DefaultDebugPort+7),
+		WithIdleListenerTimeout(time.Minute),
+		WithClock(fakeClock),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "idle-cluster"
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Wait for the reaper to be waiting on the ticker.
+	g.Eventually(fakeClock.HasWaiters).Should(BeTrue())
+
+	// Before the timeout expires the listener is preserved.
+	fakeClock.Step(40 * time.Second)
+	g.Consistently(wcmux.ListListeners, 200*time.Millisecond).Should(HaveKey(wcl))
+
+	// After the timeout the listener is deleted and its port released.
+	fakeClock.Step(40 * time.Second)
+	g.Eventually(wcmux.ListListeners).ShouldNot(HaveKey(wcl))
+
+	wcmux.lock.RLock()
+	g.Expect(wcmux.workloadClusterNameByHost).ToNot(HaveKey(listener.HostPort()))
+	wcmux.lock.RUnlock()
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestPortReuse(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+800, DefaultMinPort+801),
+		WithDebugPort(DefaultDebugPort+8),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listener1, err := wcmux.InitWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+	listener2, err := wcmux.InitWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The port range is exhausted.
+	_, err = wcmux.InitWorkloadClusterListener("workload-cluster3")
+	g.Expect(err).To(HaveOccurred())
+
+	// Deleting a listener releases its port, which is then reused.
+	err = wcmux.DeleteWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listener3, err := wcmux.InitWorkloadClusterListener("workload-cluster3")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener3.Port()).To(Equal(listener1.Port()))
+	g.Expect(listener3.Port()).ToNot(Equal(listener2.Port()))
+
+	// Concurrent init and delete never hand out the same port to two listeners.
+	err = wcmux.DeleteWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.DeleteWorkloadClusterListener("workload-cluster3")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			wclName := fmt.Sprintf("concurrent-cluster%d", i%2)
+			for j := 0; j < 20; j++ {
+				_, _ = wcmux.InitWorkloadClusterListener(wclName)
+				_ = wcmux.DeleteWorkloadClusterListener(wclName)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	l1, err := wcmux.InitWorkloadClusterListener("workload-cluster4")
+	g.Expect(err).ToNot(HaveOccurred())
+	l2, err := wcmux.InitWorkloadClusterListener("workload-cluster5")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(l1.Port()).ToNot(Equal(l2.Port()))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestGetFreePortSkipsPortsInUse(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+900, DefaultMinPort+901),
+		WithDebugPort(DefaultDebugPort+9),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Simulate another process using the first port in the range.
+	l, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultMinPort+900)))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listener1, err := wcmux.InitWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener1.Port()).To(Equal(DefaultMinPort + 901))
+
+	// All the ports in the range are taken.
+	_, err = wcmux.InitWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).To(HaveOccurred())
+
+	// The skipped port is used once it becomes available.
+	g.Expect(l.Close()).To(Succeed())
+
+	listener2, err := wcmux.InitWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener2.Port()).To(Equal(DefaultMinPort + 900))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestNewWorkloadClustersMuxOptionsValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []WorkloadClustersMuxOption
+		expectErr bool
+	}{
+		{
+			name:      "should fail if min port is greater than max port",
+			opts:      []WorkloadClustersMuxOption{WithPortRange(DefaultMinPort+1, DefaultMinPort)},
+			expectErr: true,
+		},
+		{
+			name:      "should fail if the port range is out of the valid range",
+			opts:      []WorkloadClustersMuxOption{WithPortRange(0, 70000)},
+			expectErr: true,
+		},
+		{
+			name:      "should fail if the debug port is in the port range",
+			opts:      []WorkloadClustersMuxOption{WithPortRange(DefaultMinPort, DefaultMaxPort), WithDebugPort(DefaultMinPort + 1)},
+			expectErr: true,
+		},
+		{
+			name:      "should fail if the debug port is not valid",
+			opts:      []WorkloadClustersMuxOption{WithDebugPort(-1)},
+			expectErr: true,
+		},
+		{
+			name:      "should fail if the deprecated CustomPorts option sets the debug port in the port range",
+			opts:      []WorkloadClustersMuxOption{CustomPorts{MinPort: DefaultMinPort, MaxPort: DefaultMaxPort, DebugPort: DefaultMinPort + 1}},
+			expectErr: true,
+		},
+		{
+			name:      "should fail if the max connections per listener is not valid",
+			opts:      []WorkloadClustersMuxOption{WithMaxConnsPerListener(-1)},
+			expectErr: true,
+		},
+		{
+			name:      "should fail if the certificate validity is not valid",
+			opts:      []WorkloadClustersMuxOption{WithCertificateValidity(0)},
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			_, err := NewWorkloadClustersMux(cmanager.New(scheme), "127.0.0.1", tt.opts...)
+			if tt.expectErr {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestNewWorkloadClustersMuxWithPortRange(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, err := NewWorkloadClustersMux(cmanager.New(scheme), "127.0.0.1",
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2000, DefaultMinPort+2000),
+		WithDebugPort(DefaultDebugPort+30),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listener, err := wcmux.InitWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Port()).To(Equal(DefaultMinPort + 2000))
+
+	_, err = wcmux.InitWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).To(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(cmanager.New(scheme), host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2100, DefaultMinPort+2199),
+		WithDebugPort(DefaultDebugPort+31),
+		WithReadHeaderTimeout(200*time.Millisecond),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Simulate a slow client which never completes sending request headers.
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultDebugPort+31)))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /listeners HTTP/1.1\r\nHost: " + host + "\r\n"))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The server must drop the connection after the timeout.
+	g.Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestStopListener(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2200, DefaultMinPort+2299),
+		WithDebugPort(DefaultDebugPort+32),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	clients := map[string]client.Client{}
+	listeners := map[string]*WorkloadClusterListener{}
+	for _, wcl := range []string{"workload-cluster1", "workload-cluster2"} {
+		manager.AddResourceGroup(wcl)
+
+		listener, err := wcmux.InitWorkloadClusterListener(wcl)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		c, err := listener.GetClient()
+		g.Expect(err).ToNot(HaveOccurred())
+
+		clients[wcl] = c
+		listeners[wcl] = listener
+	}
+
+	// Stop one of the listeners.
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = wcmux.StopListener(stopCtx, "workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The stopped listener does not accept connections anymore.
+	_, err = net.DialTimeout("tcp", listeners["workload-cluster1"].HostPort(), 100*time.Millisecond)
+	g.Expect(err).To(HaveOccurred())
+
+	// The other listener keeps serving.
+	g.Expect(clients["workload-cluster2"].List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Stopping a listener which is already stopped is a no-op.
+	err = wcmux.StopListener(stopCtx, "workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The listener is started again when an API server is added.
+	err = wcmux.AddAPIServer("workload-cluster1", "kube-apiserver-2", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(clients["workload-cluster1"].List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2300, DefaultMinPort+2399),
+		WithDebugPort(DefaultDebugPort+33),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "metrics-cluster"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	before := testutil.ToFloat64(requestTotal.WithLabelValues(wcmux.metricsID, wcl, "apiserver"))
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	g.Expect(testutil.ToFloat64(requestTotal.WithLabelValues(wcmux.metricsID, wcl, "apiserver"))).To(BeNumerically(">", before))
+
+	// Requests which can't be mapped to a workload cluster are not counted.
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/nodes", http.NoBody)
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: DefaultMinPort + 2399}))
+	wcmux.muxHandler.ServeHTTP(httptest.NewRecorder(), req)
+	g.Expect(requestTotal.DeleteLabelValues(wcmux.metricsID, "", "apiserver")).To(BeFalse())
+
+	// Request metrics of a listener are dropped when the listener is deleted.
+	g.Expect(wcmux.DeleteWorkloadClusterListener(wcl)).To(Succeed())
+	g.Expect(requestTotal.DeleteLabelValues(wcmux.metricsID, wcl, "apiserver")).To(BeFalse())
+
+	// Request metrics are dropped on shutdown.
+	wcl2 := "metrics-cluster2"
+	manager.AddResourceGroup(wcl2)
+
+	listener, err = wcmux.InitWorkloadClusterListener(wcl2)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddAPIServer(wcl2, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err = listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requestTotal.DeleteLabelValues(wcmux.metricsID, wcl2, "apiserver")).To(BeFalse())
+}
+
+func TestMuxIPv6(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	wcmux, err := NewWorkloadClustersMux(manager, "0:0:0:0:0:0:0:1",
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2400, DefaultMinPort+2499),
+		WithDebugPort(DefaultDebugPort+34),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Host()).To(Equal("::1"))
+	g.Expect(listener.HostPort()).To(Equal(fmt.Sprintf("[::1]:%d", listener.Port())))
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// NOTE: AddAPIServer checks the listener is serving by doing a TLS handshake, which requires certificate resolution to work.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Check the server certificate is valid for the IPv6 address.
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+	conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: caPool, MinVersion: tls.VersionTLS12})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conn.Close()).To(Succeed())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestDeleteAPIServer(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2500, DefaultMinPort+2599),
+		WithDebugPort(DefaultDebugPort+35),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, podName := range []string{"kube-apiserver-1", "kube-apiserver-2"} {
+		err = wcmux.AddAPIServer(wcl, podName, caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Removing an API server which does not exist is a no-op.
+	err = wcmux.DeleteAPIServer(wcl, "kube-apiserver-does-not-exist")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeTrue())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-2")).To(BeTrue())
+
+	// Removing one of the API servers leaves the listener serving.
+	err = wcmux.DeleteAPIServer(wcl, "kube-apiserver-1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeFalse())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Removing the last API server stops the listener and clears the serving certificate.
+	err = wcmux.DeleteAPIServer(wcl, "kube-apiserver-2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-2")).To(BeFalse())
+
+	_, err = net.DialTimeout("tcp", listener.HostPort(), 100*time.Millisecond)
+	g.Expect(err).To(HaveOccurred())
+
+	wcmux.lock.RLock()
+	g.Expect(wcmux.workloadClusterListeners[wcl].apiServerServingCertificate).To(BeNil())
+	wcmux.lock.RUnlock()
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestRotateAPIServerCertificate(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2600, DefaultMinPort+2699),
+		WithDebugPort(DefaultDebugPort+36),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	servingCertificate := func(roots *x509.Certificate) (*x509.Certificate, error) {
+		pool := x509.NewCertPool()
+		pool.AddCert(roots)
+		conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0], nil
+	}
+
+	oldCert, err := servingCertificate(caCert)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Rotate the serving certificate using a new CA.
+	newCACert, newCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.RotateAPIServerCertificate(wcl, newCACert, newCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// New connections get the new certificate, signed by the new CA.
+	newCert, err := servingCertificate(newCACert)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(newCert.Equal(oldCert)).To(BeFalse())
+
+	_, err = servingCertificate(caCert)
+	g.Expect(err).To(HaveOccurred())
+
+	// Clients generated after the rotation work with the new CA.
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestRotateAPIServerCertificateConcurrentDelete(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+9300, DefaultMinPort+9309),
+		WithDebugPort(DefaultDebugPort+107),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for i := 0; i < 10; i++ {
+		g.Expect(wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)).To(Succeed())
+
+		// Rotate the serving certificate while the last API server is deleted.
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			g.Expect(wcmux.RotateAPIServerCertificate(wcl, caCert, caKey)).To(Succeed())
+		}()
+		go func() {
+			defer wg.Done()
+			g.Expect(wcmux.DeleteAPIServer(wcl, "kube-apiserver-1")).To(Succeed())
+		}()
+		wg.Wait()
+
+		// The serving certificate cleared by deleting the last API server is not resurrected by the rotation.
+		wcmux.lock.RLock()
+		g.Expect(wcmux.workloadClusterListeners[wcl].apiServers.Len()).To(Equal(0))
+		g.Expect(wcmux.workloadClusterListeners[wcl].apiServerServingCertificate).To(BeNil())
+		wcmux.lock.RUnlock()
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestCertificateValidity(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2700, DefaultMinPort+2799),
+		WithDebugPort(DefaultDebugPort+37),
+		WithCertificateValidity(5*time.Minute),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	listener := wcmux.workloadClusterListeners[wcl]
+	apiServerCert, err := x509.ParseCertificate(listener.apiServerServingCertificate.Certificate[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	etcdCert, err := x509.ParseCertificate(listener.etcdServingCertificates["etcd-1"].Certificate[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	adminCert := listener.adminCertificate
+	wcmux.lock.RUnlock()
+
+	for _, cert := range []*x509.Certificate{apiServerCert, etcdCert, adminCert} {
+		g.Expect(cert.NotAfter).To(BeTemporally("~", time.Now().Add(5*time.Minute), time.Minute))
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestWaitForListener(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2800, DefaultMinPort+2899),
+		WithDebugPort(DefaultDebugPort+38),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	// Waiting for a listener which does not exist fails.
+	err = wcmux.WaitForListener(ctx, wcl)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Waiting for a listener which is not started yet times out.
+	waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	err = wcmux.WaitForListener(waitCtx, wcl)
+	g.Expect(err).To(HaveOccurred())
+
+	// Waiting for a listener which is started succeeds.
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	waitCtx, cancel = context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = wcmux.WaitForListener(waitCtx, wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestInitWorkloadClusterListenerWithHost(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	wcmux, err := NewWorkloadClustersMux(manager, "127.0.0.1",
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+2900, DefaultMinPort+2999),
+		WithDebugPort(DefaultDebugPort+39),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for wcl, host := range map[string]string{"workload-cluster1": "127.0.0.2", "workload-cluster2": "127.0.0.3"} {
+		manager.AddResourceGroup(wcl)
+
+		listener, err := wcmux.InitWorkloadClusterListenerWithHost(wcl, host)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(listener.Host()).To(Equal(host))
+
+		// Initializing again the listener with another host fails.
+		_, err = wcmux.InitWorkloadClusterListenerWithHost(wcl, "127.0.0.1")
+		g.Expect(err).To(HaveOccurred())
+
+		// NOTE: AddAPIServer checks the listener is serving by doing a TLS handshake, which requires certificate resolution to work.
+		err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		c, err := listener.GetClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestClientCertVerification(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3000, DefaultMinPort+3099),
+		WithDebugPort(DefaultDebugPort+40),
+		WithClientCertVerification(),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Clients presenting the admin certificate can reach the API server.
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Clients not presenting a client certificate can't reach the API server.
+	restConfig, err := listener.RESTConfig()
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig.CertData = nil
+	restConfig.KeyData = nil
+	noCertClient, err := client.New(restConfig, client.Options{Scheme: scheme, Mapper: c.RESTMapper()})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(noCertClient.List(ctx, &corev1.NodeList{})).ToNot(Succeed())
+
+	// Clients presenting a certificate signed by another CA can't reach the API server.
+	otherCACert, otherCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, key, err := newCertAndKey(otherCACert, otherCAKey, adminClientCertificateConfig(), time.Now(), certs.DefaultCertDuration)
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig.CertData = certs.EncodeCertPEM(cert)
+	restConfig.KeyData = certs.EncodePrivateKeyPEM(key)
+	otherCAClient, err := client.New(restConfig, client.Options{Scheme: scheme, Mapper: c.RESTMapper()})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(otherCAClient.List(ctx, &corev1.NodeList{})).ToNot(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestTypedErrors(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3100, DefaultMinPort+3199),
+		WithDebugPort(DefaultDebugPort+41),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	// Operations on a listener which is not initialized yet return ErrListenerNotFound.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+
+	// Connections to an address not served by any listener return ErrListenerNotFound.
+	_, err = wcmux.getCertificate(&tls.ClientHelloInfo{ServerName: "foo", Conn: &fakeConn{localAddr: &net.TCPAddr{IP: net.ParseIP(host), Port: DefaultMinPort + 3199}}})
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring(net.JoinHostPort(host, fmt.Sprintf("%d", DefaultMinPort+3199))))
+
+	// Connections to a listener without API servers return ErrCertificateNotReady.
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = wcmux.getCertificate(&tls.ClientHelloInfo{ServerName: "foo", Conn: &fakeConn{localAddr: &net.TCPAddr{IP: net.ParseIP(host), Port: listener.Port()}}})
+	g.Expect(errors.Is(err, ErrCertificateNotReady)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("foo"))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+// fakeConn is a net.Conn with a fixed local address.
+type fakeConn struct {
+	net.Conn
+	localAddr net.Addr
+}
+
+func (c *fakeConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func TestPortStats(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3200, DefaultMinPort+3201),
+		WithDebugPort(DefaultDebugPort+42),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	used, free, total := wcmux.PortStats()
+	g.Expect(used).To(Equal(0))
+	g.Expect(free).To(Equal(2))
+	g.Expect(total).To(Equal(2))
+
+	for _, wcl := range []string{"workload-cluster1", "workload-cluster2"} {
+		_, err := wcmux.InitWorkloadClusterListener(wcl)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	used, free, total = wcmux.PortStats()
+	g.Expect(used).To(Equal(2))
+	g.Expect(free).To(Equal(0))
+	g.Expect(total).To(Equal(2))
+
+	err = wcmux.DeleteWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	used, free, total = wcmux.PortStats()
+	g.Expect(used).To(Equal(1))
+	g.Expect(free).To(Equal(1))
+	g.Expect(total).To(Equal(2))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestPortStatsWithPortsInUse(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+	minPort := DefaultMinPort + 8900
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		WithPortRange(minPort, minPort+2),
+		WithDebugPort(DefaultDebugPort+102),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Occupy the first port in the range, so it is skipped.
+	l, err := net.Listen("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", minPort)))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listener, err := wcmux.InitWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Port()).To(Equal(minPort + 1))
+
+	// The port in use is not free.
+	used, free, total := wcmux.PortStats()
+	g.Expect(used).To(Equal(2))
+	g.Expect(free).To(Equal(1))
+	g.Expect(total).To(Equal(3))
+
+	// The port in use can be used once it becomes available.
+	g.Expect(l.Close()).To(Succeed())
+	listener, err = wcmux.InitWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Port()).To(Equal(minPort))
+
+	used, free, total = wcmux.PortStats()
+	g.Expect(used).To(Equal(2))
+	g.Expect(free).To(Equal(1))
+	g.Expect(total).To(Equal(3))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestMetricsPerMux(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	host := "127.0.0.1"
+	// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+	wcmux1, err := NewWorkloadClustersMux(cmanager.New(scheme), host,
+		WithPortRange(DefaultMinPort+9000, DefaultMinPort+9002),
+		WithDebugPort(DefaultDebugPort+103),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+	wcmux2, err := NewWorkloadClustersMux(cmanager.New(scheme), host,
+		WithPortRange(DefaultMinPort+9003, DefaultMinPort+9007),
+		WithDebugPort(DefaultDebugPort+104),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = wcmux1.InitWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Each mux reports its own metrics.
+	g.Expect(testutil.ToFloat64(portsFree.WithLabelValues(wcmux1.metricsID))).To(Equal(2.0))
+	g.Expect(testutil.ToFloat64(portsFree.WithLabelValues(wcmux2.metricsID))).To(Equal(5.0))
+	g.Expect(testutil.ToFloat64(listenersActive.WithLabelValues(wcmux1.metricsID))).To(Equal(0.0))
+	g.Expect(testutil.ToFloat64(listenersActive.WithLabelValues(wcmux2.metricsID))).To(Equal(0.0))
+
+	// Metrics of a mux are dropped on shutdown.
+	g.Expect(wcmux1.Shutdown(ctx)).To(Succeed())
+	g.Expect(portsFree.DeleteLabelValues(wcmux1.metricsID)).To(BeFalse())
+	g.Expect(testutil.ToFloat64(portsFree.WithLabelValues(wcmux2.metricsID))).To(Equal(5.0))
+
+	g.Expect(wcmux2.Shutdown(ctx)).To(Succeed())
+}
+
+func TestAddAPIServerServeError(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+9100, DefaultMinPort+9199),
+		WithDebugPort(DefaultDebugPort+105),
+		// Without certificates, serving TLS fails immediately.
+		WithTLSConfig(func(config *tls.Config) {
+			config.GetCertificate = nil
+		}),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// AddAPIServer returns the serve error without waiting for the listener readiness timeout.
+	start := time.Now()
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue(), err.Error())
+	g.Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeFalse())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	var calls []string
+	var failRequests bool
+	var lock sync.Mutex
+	recordCall := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				calls = append(calls, name)
+				fail := failRequests
+				lock.Unlock()
+				if fail {
+					http.Error(w, "injected failure", http.StatusInternalServerError)
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3300, DefaultMinPort+3399),
+		WithDebugPort(DefaultDebugPort+43),
+		WithHandlerMiddleware(recordCall("outer")),
+		WithHandlerMiddleware(recordCall("inner")),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// NOTE: The first request triggers discovery calls, so it is done before checking middleware calls.
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Middlewares are called in the order they are added.
+	lock.Lock()
+	calls = nil
+	lock.Unlock()
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	lock.Lock()
+	g.Expect(calls).To(Equal([]string{"outer", "inner"}))
+	lock.Unlock()
+
+	// Middlewares can inject failures.
+	lock.Lock()
+	failRequests = true
+	lock.Unlock()
+	g.Expect(c.List(ctx, &corev1.NodeList{})).ToNot(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestFaultInjection(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3400, DefaultMinPort+3499),
+		WithDebugPort(DefaultDebugPort+44),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Invalid configs are rejected.
+	g.Expect(wcmux.SetFaultInjection(wcl, FaultConfig{ErrorRate: 2})).ToNot(Succeed())
+	g.Expect(wcmux.SetFaultInjection(wcl, FaultConfig{ErrorCode: http.StatusOK})).ToNot(Succeed())
+	g.Expect(wcmux.SetFaultInjection("does-not-exist", FaultConfig{})).ToNot(Succeed())
+
+	// Requests fail with the configured error code.
+	err = wcmux.SetFaultInjection(wcl, FaultConfig{ErrorRate: 1, ErrorCode: http.StatusServiceUnavailable})
+	g.Expect(err).ToNot(HaveOccurred())
+	err = c.List(ctx, &corev1.NodeList{})
+	g.Expect(apierrors.IsServiceUnavailable(err)).To(BeTrue())
+
+	// Requests are delayed by the configured latency.
+	err = wcmux.SetFaultInjection(wcl, FaultConfig{Latency: 200 * time.Millisecond})
+	g.Expect(err).ToNot(HaveOccurred())
+	start := time.Now()
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+
+	// Clearing the config restores normal behavior.
+	err = wcmux.SetFaultInjection(wcl, FaultConfig{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestIsGRPCRequest(t *testing.T) {
+	tests := []struct {
+		contentType string
+		protoMajor  int
+		wantGRPC    bool
+		wantGRPCWeb bool
+	}{
+		{contentType: "application/grpc", protoMajor: 2, wantGRPC: true},
+		{contentType: "application/grpc+proto", protoMajor: 2, wantGRPC: true},
+		{contentType: "application/grpc; charset=utf-8", protoMajor: 2, wantGRPC: true},
+		{contentType: "Application/GRPC", protoMajor: 2, wantGRPC: true},
+		{contentType: " application/grpc+proto ; foo=bar", protoMajor: 2, wantGRPC: true},
+		{contentType: "application/grpc", protoMajor: 1, wantGRPC: false},
+		{contentType: "application/grpc-web", protoMajor: 2, wantGRPCWeb: true},
+		{contentType: "application/grpc-web-text", protoMajor: 1, wantGRPCWeb: true},
+		{contentType: "application/grpc-web+proto", protoMajor: 2, wantGRPCWeb: true},
+		{contentType: "application/grpcfoo", protoMajor: 2},
+		{contentType: "application/json", protoMajor: 2},
+		{contentType: "", protoMajor: 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s (HTTP/%d)", tt.contentType, tt.protoMajor), func(t *testing.T) {
+			g := NewWithT(t)
+
+			r := &http.Request{ProtoMajor: tt.protoMajor, Header: http.Header{}}
+			r.Header.Set("Content-Type", tt.contentType)
+
+			g.Expect(isGRPCRequest(r)).To(Equal(tt.wantGRPC))
+			g.Expect(isGRPCWebRequest(r)).To(Equal(tt.wantGRPCWeb))
+		})
+	}
+}
+
+func TestMaxConnsPerListener(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3500, DefaultMinPort+3599),
+		WithDebugPort(DefaultDebugPort+45),
+		WithMaxConnsPerListener(1),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	dial := func() (*tls.Conn, error) {
+		d := &net.Dialer{Timeout: 200 * time.Millisecond}
+		return tls.DialWithDialer(d, "tcp", listener.HostPort(), &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // config is used to connect to our own port.
+		})
+	}
+
+	// The first connection is accepted.
+	conn, err := dial()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Excess connections are not accepted while the first connection is open.
+	_, err = dial()
+	g.Expect(err).To(HaveOccurred())
+
+	// Connections are accepted again when the first connection is closed.
+	g.Expect(conn.Close()).To(Succeed())
+	g.Eventually(func() error {
+		conn, err := dial()
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}, 5*time.Second).Should(Succeed())
+
+	// The limit is exposed by the debug handler.
+	resp, err := http.Get(fmt.Sprintf("http://%s/listeners/limits", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultDebugPort+45))))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(body).To(MatchJSON(`{"maxConnsPerListener": 1}`))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestHotRestart(t *testing.T) {
+	t.Parallel()
+
+	newInMemoryCluster := func(name string, port int) infrav1.InMemoryCluster {
+		return infrav1.InMemoryCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   metav1.NamespaceDefault,
+				Name:        name,
+				Annotations: map[string]string{infrav1.ResourceGroupAnnotationName: name},
+			},
+			Spec: infrav1.InMemoryClusterSpec{
+				ControlPlaneEndpoint: infrav1.APIEndpoint{Host: "127.0.0.1", Port: port},
+			},
+		}
+	}
+	// newClusters returns a list of clusters where the first and the third cluster use the same port.
+	newClusters := func(port int) *infrav1.InMemoryClusterList {
+		return &infrav1.InMemoryClusterList{
+			Items: []infrav1.InMemoryCluster{
+				newInMemoryCluster("cluster1", port),
+				newInMemoryCluster("cluster2", port+2),
+				newInMemoryCluster("cluster3", port),
+			},
+		}
+	}
+
+	// newManager returns a manager with the resource groups for the clusters above.
+	newManager := func() cmanager.Manager {
+		manager := cmanager.New(scheme)
+		for _, name := range []string{"cluster1", "cluster2", "cluster3"} {
+			manager.AddResourceGroup(name)
+		}
+		return manager
+	}
+
+	t.Run("HotRestart skips clusters whose resource group does not exist", func(t *testing.T) {
+		g := NewWithT(t)
+
+		manager := cmanager.New(scheme)
+		manager.AddResourceGroup("cluster2")
+		wcmux, err := NewWorkloadClustersMux(manager, "127.0.0.1",
+			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+			WithPortRange(DefaultMinPort+3700, DefaultMinPort+3749),
+			WithDebugPort(DefaultDebugPort+48),
+		)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		err = wcmux.HotRestart(&infrav1.InMemoryClusterList{Items: []infrav1.InMemoryCluster{
+			newInMemoryCluster("cluster1", DefaultMinPort+3700),
+			newInMemoryCluster("cluster2", DefaultMinPort+3701),
+		}})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(wcmux.ListListeners()).To(HaveLen(1))
+		g.Expect(wcmux.ListListeners()).To(HaveKey("cluster2"))
+
+		err = wcmux.Shutdown(ctx)
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("HotRestart fails when two clusters are using the same port", func(t *testing.T) {
+		g := NewWithT(t)
+
+		wcmux, err := NewWorkloadClustersMux(newManager(), "127.0.0.1",
+			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+			WithPortRange(DefaultMinPort+3600, DefaultMinPort+3649),
+			WithDebugPort(DefaultDebugPort+46),
+		)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		err = wcmux.HotRestart(newClusters(DefaultMinPort + 3600))
+		g.Expect(err).To(HaveOccurred())
+
+		err = wcmux.Shutdown(ctx)
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("HotRestartWithPortRepair assigns a new port to clusters using the same port", func(t *testing.T) {
+		g := NewWithT(t)
+
+		wcmux, err := NewWorkloadClustersMux(newManager(), "127.0.0.1",
+			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+			WithPortRange(DefaultMinPort+3650, DefaultMinPort+3699),
+			WithDebugPort(DefaultDebugPort+47),
+		)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		clusters := newClusters(DefaultMinPort + 3650)
+		err = wcmux.HotRestartWithPortRepair(clusters)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		// The first cluster keeps its port, the colliding one gets the first free port.
+		g.Expect(clusters.Items[0].Spec.ControlPlaneEndpoint.Port).To(Equal(DefaultMinPort + 3650))
+		g.Expect(clusters.Items[1].Spec.ControlPlaneEndpoint.Port).To(Equal(DefaultMinPort + 3652))
+		g.Expect(clusters.Items[2].Spec.ControlPlaneEndpoint.Port).To(Equal(DefaultMinPort + 3651))
+
+		listeners := wcmux.ListListeners()
+		g.Expect(listeners).To(HaveLen(3))
+		g.Expect(listeners["cluster3"]).To(Equal(fmt.Sprintf("https://127.0.0.1:%d", DefaultMinPort+3651)))
+
+		err = wcmux.Shutdown(ctx)
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+}
+
+func TestListenerEventHandler(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	var events []ListenerEvent
+	var lock sync.Mutex
+	recordEvent := func(event ListenerEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	recordedEvents := func() []ListenerEvent {
+		lock.Lock()
+		defer lock.Unlock()
+		ret := events
+		events = nil
+		return ret
+	}
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3800, DefaultMinPort+3899),
+		WithDebugPort(DefaultDebugPort+49),
+		WithListenerEventHandler(recordEvent),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(recordedEvents()).To(BeEmpty())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	started := ListenerEvent{ListenerName: wcl, Address: listener.Address(), Type: ListenerStarted}
+	stopped := ListenerEvent{ListenerName: wcl, Address: listener.Address(), Type: ListenerStopped}
+
+	// Adding the first API server starts the listener, adding other API servers doesn't.
+	g.Expect(wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)).To(Succeed())
+	g.Expect(wcmux.AddAPIServer(wcl, "kube-apiserver-2", caCert, caKey)).To(Succeed())
+	g.Expect(recordedEvents()).To(Equal([]ListenerEvent{started}))
+
+	// Deleting the last API server stops the listener.
+	g.Expect(wcmux.DeleteAPIServer(wcl, "kube-apiserver-1")).To(Succeed())
+	g.Expect(recordedEvents()).To(BeEmpty())
+	g.Expect(wcmux.DeleteAPIServer(wcl, "kube-apiserver-2")).To(Succeed())
+	g.Expect(recordedEvents()).To(Equal([]ListenerEvent{stopped}))
+
+	// StopListener stops the listener.
+	g.Expect(wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)).To(Succeed())
+	g.Expect(wcmux.StopListener(ctx, wcl)).To(Succeed())
+	g.Expect(recordedEvents()).To(Equal([]ListenerEvent{started, stopped}))
+
+	// Deleting the listener stops it.
+	g.Expect(wcmux.AddAPIServer(wcl, "kube-apiserver-2", caCert, caKey)).To(Succeed())
+	g.Expect(wcmux.DeleteWorkloadClusterListener(wcl)).To(Succeed())
+	g.Expect(recordedEvents()).To(Equal([]ListenerEvent{started, stopped}))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAddAPIServerWithContext(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+3900, DefaultMinPort+3999),
+		WithDebugPort(DefaultDebugPort+50),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Adding an API server with a cancelled context fails, without leaving a half started listener.
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = wcmux.AddAPIServerWithContext(cancelledCtx, wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeFalse())
+
+	_, err = net.DialTimeout("tcp", listener.HostPort(), 100*time.Millisecond)
+	g.Expect(err).To(HaveOccurred())
+
+	// Adding an API server with a valid context works.
+	err = wcmux.AddAPIServerWithContext(ctx, wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeTrue())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestListenerErrors(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4000, DefaultMinPort+4099),
+		WithDebugPort(DefaultDebugPort+51),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Stopping a listener is not reported as an error.
+	g.Expect(wcmux.StopListener(ctx, wcl)).To(Succeed())
+	g.Consistently(wcmux.ListenerErrors(), 200*time.Millisecond).ShouldNot(Receive())
+
+	// Simulate the listener dying unexpectedly.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-2", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	l := wcmux.workloadClusterListeners[wcl].listener
+	wcmux.lock.RUnlock()
+	g.Expect(l.Close()).To(Succeed())
+
+	var listenerErr ListenerError
+	g.Eventually(wcmux.ListenerErrors(), 5*time.Second).Should(Receive(&listenerErr))
+	g.Expect(listenerErr.ListenerName).To(Equal(wcl))
+	g.Expect(listenerErr.Err).To(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestUnixSockets(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	dir := t.TempDir()
+	wcmux, err := NewWorkloadClustersMux(manager, "127.0.0.1",
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithDebugPort(DefaultDebugPort+52),
+		WithUnixSockets(dir),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "default/workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	socketPath := filepath.Join(dir, "default_workload-cluster1.sock")
+	g.Expect(listener.HostPort()).To(Equal(socketPath))
+	g.Expect(listener.Address()).To(Equal("unix://" + socketPath))
+
+	// No ports are reserved when using Unix domain sockets.
+	used, _, _ := wcmux.PortStats()
+	g.Expect(used).To(Equal(0))
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// NOTE: AddAPIServer checks the listener is serving by doing a TLS handshake, which requires certificate resolution to work.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(socketPath).To(BeAnExistingFile())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Deleting the listener removes the socket.
+	err = wcmux.DeleteWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(socketPath).ToNot(BeAnExistingFile())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestListenerDetails(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4100, DefaultMinPort+4199),
+		WithDebugPort(DefaultDebugPort+53),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Listeners without certificates only report the address.
+	g.Expect(wcmux.ListenerDetails()).To(Equal(map[string]api.ListenerDetails{wcl: {Address: listener.Address()}}))
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	details := wcmux.ListenerDetails()[wcl]
+	g.Expect(details.Address).To(Equal(listener.Address()))
+	g.Expect(details.APIServerCertificateNotAfter).ToNot(BeNil())
+	g.Expect(details.APIServerCertificateNotAfter.Time).To(BeTemporally("~", time.Now().Add(certs.DefaultCertDuration), time.Minute))
+	g.Expect(details.EtcdCertificatesNotAfter).To(HaveKey("etcd-1"))
+
+	// The details are exposed by the debug handler.
+	resp, err := http.Get(fmt.Sprintf("http://%s/listeners/details", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultDebugPort+53))))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(body)).To(ContainSubstring("apiServerCertificateNotAfter"))
+	g.Expect(string(body)).To(ContainSubstring("etcd-1"))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4200, DefaultMinPort+4299),
+		WithDebugPort(DefaultDebugPort+54),
+		WithTLSConfig(func(config *tls.Config) {
+			config.MinVersion = tls.VersionTLS13
+		}),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	dial := func(maxVersion uint16) error {
+		conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // config is used to connect to our own port.
+			MaxVersion:         maxVersion,
+		})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// Clients not supporting TLS 1.3 can't connect.
+	g.Expect(dial(tls.VersionTLS12)).ToNot(Succeed())
+	g.Expect(dial(tls.VersionTLS13)).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestSeparateEtcdCA(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4300, DefaultMinPort+4399),
+		WithDebugPort(DefaultDebugPort+55),
+		WithClientCertVerification(),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	apiServerCACert, apiServerCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+	etcdCACert, etcdCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", apiServerCACert, apiServerCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", etcdCACert, etcdCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	// Clients of the API server verify the serving certificate and present a client certificate using the API server CA.
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Clients of etcd verify the serving certificate and present a client certificate using the etcd CA.
+	etcdClientCert, etcdClientKey, err := newCertAndKey(etcdCACert, etcdCAKey, &certs.Config{
+		CommonName: "etcd-client",
+		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, time.Now(), certs.DefaultCertDuration)
+	g.Expect(err).ToNot(HaveOccurred())
+	etcdClientCertificate, err := tls.X509KeyPair(certs.EncodeCertPEM(etcdClientCert), certs.EncodePrivateKeyPEM(etcdClientKey))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	etcdRoots := x509.NewCertPool()
+	etcdRoots.AddCert(etcdCACert)
+	conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{
+		ServerName:   "etcd-1",
+		RootCAs:      etcdRoots,
+		Certificates: []tls.Certificate{etcdClientCertificate},
+		MinVersion:   tls.VersionTLS12,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conn.Close()).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	spanRecorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder))
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4400, DefaultMinPort+4499),
+		WithDebugPort(DefaultDebugPort+56),
+		WithTracerProvider(tracerProvider),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Send a request with an incoming trace context.
+	traceID, err := oteltrace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig, err := listener.RESTConfig()
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("traceparent", fmt.Sprintf("00-%s-00f067aa0ba902b7-01", traceID))
+			return rt.RoundTrip(req)
+		})
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Spans are named by handler and workload cluster, and they are children of the incoming trace context.
+	spans := spanRecorder.Ended()
+	g.Expect(spans).ToNot(BeEmpty())
+	for _, span := range spans {
+		g.Expect(span.Name()).To(Equal("apiserver workload-cluster1"))
+		g.Expect(span.SpanKind()).To(Equal(oteltrace.SpanKindServer))
+		g.Expect(span.SpanContext().TraceID()).To(Equal(traceID))
+		g.Expect(span.Parent().IsRemote()).To(BeTrue())
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+// roundTripperFunc implements http.RoundTripper using a func.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestListenerAddress(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4500, DefaultMinPort+4599),
+		WithDebugPort(DefaultDebugPort+57),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, _, ok := wcmux.ListenerAddress("workload-cluster1")
+	g.Expect(ok).To(BeFalse())
+
+	listener, err := wcmux.InitWorkloadClusterListener("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listenerHost, listenerPort, ok := wcmux.ListenerAddress("workload-cluster1")
+	g.Expect(ok).To(BeTrue())
+	g.Expect(listenerHost).To(Equal(host))
+	g.Expect(listenerPort).To(Equal(listener.Port()))
+	g.Expect(wcmux.ListListeners()).To(HaveKeyWithValue("workload-cluster1", fmt.Sprintf("https://%s", net.JoinHostPort(listenerHost, fmt.Sprintf("%d", listenerPort)))))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAdminKubeconfig(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4600, DefaultMinPort+4699),
+		WithDebugPort(DefaultDebugPort+58),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.AdminKubeconfig(wcl)
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = wcmux.AdminKubeconfig(wcl)
+	g.Expect(errors.Is(err, ErrCertificateNotReady)).To(BeTrue())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	kubeconfig, err := wcmux.AdminKubeconfig(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAddAPIServerCAMismatch(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4700, DefaultMinPort+4799),
+		WithDebugPort(DefaultDebugPort+59),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	servingCertificate := wcmux.workloadClusterListeners[wcl].apiServerServingCertificate
+	wcmux.lock.RUnlock()
+
+	// Re-adding the same API server with the same CA is a no-op.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	g.Expect(wcmux.workloadClusterListeners[wcl].apiServerServingCertificate).To(BeIdenticalTo(servingCertificate))
+	wcmux.lock.RUnlock()
+
+	// Adding an API server with a different CA fails, and the listener keeps using the existing CA.
+	otherCACert, otherCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, podName := range []string{"kube-apiserver-1", "kube-apiserver-2"} {
+		err = wcmux.AddAPIServer(wcl, podName, otherCACert, otherCAKey)
+		g.Expect(errors.Is(err, ErrCertificateAuthorityMismatch)).To(BeTrue())
+	}
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-2")).To(BeFalse())
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Once all the API servers are removed, API servers can be added with a different CA.
+	err = wcmux.DeleteAPIServer(wcl, "kube-apiserver-1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-2", otherCACert, otherCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	c, err = listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAddEtcdMemberCAMismatch(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7700, DefaultMinPort+7799),
+		WithDebugPort(DefaultDebugPort+90),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	servingCertificate := wcmux.workloadClusterListeners[wcl].etcdServingCertificates["etcd-1"]
+	wcmux.lock.RUnlock()
+
+	// Re-adding the same etcd member with the same CA is a no-op.
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	g.Expect(wcmux.workloadClusterListeners[wcl].etcdServingCertificates["etcd-1"]).To(BeIdenticalTo(servingCertificate))
+	wcmux.lock.RUnlock()
+
+	// Adding an etcd member with a different CA fails, and the listener keeps using the existing CA.
+	otherCACert, otherCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, podName := range []string{"etcd-1", "etcd-2"} {
+		err = wcmux.AddEtcdMember(wcl, podName, otherCACert, otherCAKey)
+		g.Expect(errors.Is(err, ErrCertificateAuthorityMismatch)).To(BeTrue())
+	}
+	err = wcmux.AddEtcdMembers([]EtcdMemberSpec{{ListenerName: wcl, PodName: "etcd-2", CACert: otherCACert, CAKey: otherCAKey}})
+	g.Expect(errors.Is(err, ErrCertificateAuthorityMismatch)).To(BeTrue())
+
+	wcmux.lock.RLock()
+	g.Expect(sets.List(wcmux.workloadClusterListeners[wcl].etcdMembers)).To(ConsistOf("etcd-1"))
+	g.Expect(wcmux.workloadClusterListeners[wcl].etcdCaCertificate).To(BeIdenticalTo(caCert))
+	g.Expect(wcmux.workloadClusterListeners[wcl].etcdServingCertificates["etcd-1"]).To(BeIdenticalTo(servingCertificate))
+	wcmux.lock.RUnlock()
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	// Once all the etcd members are removed, etcd members can be added with a different CA.
+	err = wcmux.DeleteEtcdMember(wcl, "etcd-1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", otherCACert, otherCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	leaf := wcmux.workloadClusterListeners[wcl].etcdServingCertificates["etcd-1"].Leaf
+	wcmux.lock.RUnlock()
+	g.Expect(leaf.CheckSignatureFrom(otherCACert)).To(Succeed())
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_Discovery(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, _ := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+4800, DefaultMinPort+4899),
+		WithDebugPort(DefaultDebugPort+60),
+	)
+
+	kubeconfig, err := wcmux.AdminKubeconfig("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Only group versions registered in the scheme of the manager are served.
+	groups, resourceLists, err := discoveryClient.ServerGroupsAndResources()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	groupNames := []string{}
+	for _, group := range groups {
+		groupNames = append(groupNames, group.Name)
+	}
+	g.Expect(groupNames).To(ConsistOf("", "rbac.authorization.k8s.io"))
+
+	groupVersions := []string{}
+	for _, resourceList := range resourceLists {
+		groupVersions = append(groupVersions, resourceList.GroupVersion)
+	}
+	g.Expect(groupVersions).To(ConsistOf("v1", "rbac.authorization.k8s.io/v1"))
+
+	_, err = discoveryClient.ServerResourcesForGroupVersion("apps/v1")
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	openAPI, err := discoveryClient.RESTClient().Get().AbsPath("/openapi/v2").SetHeader("Accept", "application/json").DoRaw(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(openAPI)).To(ContainSubstring(`"swagger": "2.0"`))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestEtcdMemberHealth(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5300, DefaultMinPort+5399),
+		WithDebugPort(DefaultDebugPort+65),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, etcdMember := range []string{"etcd-1", "etcd-2"} {
+		err = wcmux.AddEtcdMember(wcl, etcdMember, caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	newEtcdClient := func(etcdMember string) *clientv3.Client {
+		etcdClient, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{listener.HostPort()},
+			DialTimeout: 2 * time.Second,
+			TLS: &tls.Config{
+				ServerName: etcdMember,
+				RootCAs:    roots,
+				MinVersion: tls.VersionTLS12,
+			},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		return etcdClient
+	}
+	put := func(etcdClient *clientv3.Client) error {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		_, err := etcdClient.Put(ctx, "foo", "bar")
+		return err
+	}
+
+	etcdClient1 := newEtcdClient("etcd-1")
+	etcdClient2 := newEtcdClient("etcd-2")
+	g.Expect(put(etcdClient1)).To(Succeed())
+	g.Expect(put(etcdClient2)).To(Succeed())
+
+	// Requests to an unhealthy etcd member fail, while other members are not affected.
+	wcmux.SetEtcdMemberHealth(wcl, "etcd-1", false)
+	err = put(etcdClient1)
+	g.Expect(status.Code(err)).To(Equal(codes.Unavailable))
+	g.Expect(put(etcdClient2)).To(Succeed())
+
+	// Requests succeed again when the etcd member becomes healthy.
+	wcmux.SetEtcdMemberHealth(wcl, "etcd-1", true)
+	g.Expect(put(etcdClient1)).To(Succeed())
+
+	g.Expect(etcdClient1.Close()).To(Succeed())
+	g.Expect(etcdClient2.Close()).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPIServerHealth(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5400, DefaultMinPort+5499),
+		WithDebugPort(DefaultDebugPort+66),
+	)
+	wcl := "workload-cluster1"
+
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Requests fail while the API server is unhealthy.
+	wcmux.SetAPIServerHealth(wcl, false)
+	err := c.List(ctx, &corev1.NodeList{})
+	g.Expect(apierrors.IsServiceUnavailable(err)).To(BeTrue())
+
+	// Requests succeed again when the API server becomes healthy.
+	wcmux.SetAPIServerHealth(wcl, true)
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5500, DefaultMinPort+5599),
+		WithDebugPort(DefaultDebugPort+67),
+		WithDryRun(),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The debug server is not started.
+	g.Expect(isPortAvailable(host, DefaultDebugPort+67)).To(BeTrue())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Port()).To(Equal(DefaultMinPort + 5500))
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeTrue())
+	g.Expect(wcmux.WaitForListener(ctx, wcl)).To(Succeed())
+
+	// State and certificates are recorded as usual.
+	g.Expect(wcmux.ListListeners()).To(HaveKeyWithValue(wcl, listener.Address()))
+	_, err = wcmux.AdminKubeconfig(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	// But the listener is not started.
+	g.Expect(isPortAvailable(host, listener.Port())).To(BeTrue())
+
+	// Adding the API server again is a no-op.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, _ := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5600, DefaultMinPort+5699),
+		WithDebugPort(DefaultDebugPort+68),
+	)
+
+	// Add an idle listener.
+	_, err := wcmux.InitWorkloadClusterListener("workload-cluster2")
+	g.Expect(err).ToNot(HaveOccurred())
+	wcmux.SetEtcdMemberHealth("workload-cluster1", "etcd-1", false)
+
+	snapshot := wcmux.Snapshot()
+	g.Expect(snapshot.Host).To(Equal("127.0.0.1"))
+	g.Expect(snapshot.MinPort).To(Equal(DefaultMinPort + 5600))
+	g.Expect(snapshot.MaxPort).To(Equal(DefaultMinPort + 5699))
+	g.Expect(snapshot.Listeners).To(HaveLen(2))
+
+	wcl1 := snapshot.Listeners[0]
+	g.Expect(wcl1.Name).To(Equal("workload-cluster1"))
+	g.Expect(wcl1.Address).To(Equal(fmt.Sprintf("https://127.0.0.1:%d", DefaultMinPort+5600)))
+	g.Expect(wcl1.Started).To(BeTrue())
+	g.Expect(wcl1.APIServers).To(Equal([]string{"kube-apiserver-1"}))
+	g.Expect(wcl1.APIServerCertificateNotAfter).ToNot(BeNil())
+	g.Expect(wcl1.EtcdMembers).To(Equal([]string{"etcd-1"}))
+	g.Expect(wcl1.UnhealthyEtcdMembers).To(Equal([]string{"etcd-1"}))
+	g.Expect(wcl1.EtcdCertificatesNotAfter).To(HaveKey("etcd-1"))
+	g.Expect(wcl1.IdleSince).To(BeNil())
+
+	wcl2 := snapshot.Listeners[1]
+	g.Expect(wcl2.Name).To(Equal("workload-cluster2"))
+	g.Expect(wcl2.Started).To(BeFalse())
+	g.Expect(wcl2.APIServers).To(BeEmpty())
+	g.Expect(wcl2.IdleSince).ToNot(BeNil())
+
+	// The snapshot can be serialized.
+	g.Expect(snapshot.String()).To(ContainSubstring(`"name": "workload-cluster1"`))
+	data, err := json.Marshal(snapshot)
+	g.Expect(err).ToNot(HaveOccurred())
+	decoded := MuxSnapshot{}
+	g.Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+	g.Expect(decoded.Listeners).To(HaveLen(2))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestPreallocateListeners(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5700, DefaultMinPort+5702),
+		WithDebugPort(DefaultDebugPort+69),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	names, err := wcmux.PreallocateListeners(2)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(names).To(HaveLen(2))
+	g.Expect(wcmux.ListListeners()).To(HaveLen(2))
+
+	// Preallocating more listeners than the available ports fails without initializing any listener.
+	_, err = wcmux.PreallocateListeners(2)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(wcmux.ListListeners()).To(HaveLen(2))
+
+	// Preallocated listeners can be used as usual.
+	wcl := names[0]
+	manager.AddResourceGroup(wcl)
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	kubeconfig, err := wcmux.AdminKubeconfig(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestResourceGroupResolver(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	// Requests to any listener target the same resource group.
+	resourceGroup := "tenant-a"
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+5800, DefaultMinPort+5899),
+		WithDebugPort(DefaultDebugPort+70),
+		WithResourceGroupResolver(func(string) (string, error) {
+			return resourceGroup, nil
+		}),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	manager.AddResourceGroup(resourceGroup)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	g.Expect(manager.GetResourceGroup(resourceGroup).GetClient().Create(ctx, node)).To(Succeed())
+
+	wcl := "endpoint-1"
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	nodes := &corev1.NodeList{}
+	g.Expect(c.List(ctx, nodes)).To(Succeed())
+	g.Expect(nodes.Items).To(HaveLen(1))
+	g.Expect(nodes.Items[0].Name).To(Equal("node-1"))
+
+	// Faults and health still refer to the listener name.
+	wcmux.SetAPIServerHealth(wcl, false)
+	err = c.List(ctx, &corev1.NodeList{})
+	g.Expect(apierrors.IsServiceUnavailable(err)).To(BeTrue())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("shutdown without listeners", func(t *testing.T) {
+		g := NewWithT(t)
+
+		manager := cmanager.New(scheme)
+		wcmux, err := NewWorkloadClustersMux(manager, "127.0.0.1",
+			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+			WithPortRange(DefaultMinPort+5900, DefaultMinPort+5949),
+			WithDebugPort(DefaultDebugPort+71),
+		)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(wcmux.Shutdown(ctx)).To(Succeed())
+		// Shutdown can be called more than once.
+		g.Expect(wcmux.Shutdown(ctx)).To(Succeed())
+	})
+
+	t.Run("shutdown without debug server", func(t *testing.T) {
+		g := NewWithT(t)
+
+		manager := cmanager.New(scheme)
+		wcmux, err := NewWorkloadClustersMux(manager, "127.0.0.1",
+			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+			WithPortRange(DefaultMinPort+5950, DefaultMinPort+5999),
+			WithDebugPort(DefaultDebugPort+72),
+			WithDryRun(),
+		)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(wcmux.Shutdown(ctx)).To(Succeed())
+	})
+
+	t.Run("shutdown stops all the servers even if some fail", func(t *testing.T) {
+		g := NewWithT(t)
+
+		wcmux, c := setupWorkloadClusterListener(g,
+			// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+			WithPortRange(DefaultMinPort+6000, DefaultMinPort+6099),
+			WithDebugPort(DefaultDebugPort+73),
+		)
+		host, port, ok := wcmux.ListenerAddress("workload-cluster1")
+		g.Expect(ok).To(BeTrue())
+
+		// Keep a connection active with a watch, so shutting down the listener can't complete.
+		watcher, err := c.Watch(ctx, &corev1.NodeList{})
+		g.Expect(err).ToNot(HaveOccurred())
+		defer watcher.Stop()
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err = wcmux.Shutdown(timeoutCtx)
+		g.Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("failed to shutdown the mux server for WorkloadClusterListener workload-cluster1"))
+		g.Expect(err.Error()).ToNot(ContainSubstring("debug server"))
+
+		// Both the debug server and the listener are not accepting connections anymore.
+		g.Expect(isPortAvailable(host, DefaultDebugPort+73)).To(BeTrue())
+		g.Expect(isPortAvailable(host, port)).To(BeTrue())
+	})
+}
+
+func TestReloadCA(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6100, DefaultMinPort+6199),
+		WithDebugPort(DefaultDebugPort+74),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	err = wcmux.ReloadCA(wcl, nil, nil)
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	dial := func(roots *x509.Certificate) error {
+		pool := x509.NewCertPool()
+		pool.AddCert(roots)
+		conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	g.Expect(dial(caCert)).To(Succeed())
+
+	newCACert, newCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.ReloadCA(wcl, newCACert, newCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Clients using the previous CA can't connect anymore.
+	g.Expect(dial(caCert)).ToNot(Succeed())
+
+	// Clients using the new CA work.
+	g.Expect(dial(newCACert)).To(Succeed())
+
+	kubeconfig, err := wcmux.AdminKubeconfig(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// All the serving certificates, including the ones for etcd members, are signed by the new CA.
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+	roots := x509.NewCertPool()
+	roots.AddCert(newCACert)
+	wcmux.lock.RLock()
+	etcdCertificate := wcmux.workloadClusterListeners[wcl].etcdServingCertificates["etcd-1"]
+	wcmux.lock.RUnlock()
+	g.Expect(verifyTLSCertificate(etcdCertificate, roots, time.Now(), x509.ExtKeyUsageServerAuth)).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestWithLogger(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	var lock sync.Mutex
+	var lines []string
+	logger := funcr.New(func(prefix, args string) {
+		lock.Lock()
+		defer lock.Unlock()
+		lines = append(lines, args)
+	}, funcr.Options{})
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6200, DefaultMinPort+6299),
+		WithDebugPort(DefaultDebugPort+75),
+		WithLogger(logger),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	lock.Lock()
+	defer lock.Unlock()
+	g.Expect(lines).To(ContainElement(And(
+		ContainSubstring(`"msg"="Workload cluster listener created"`),
+		ContainSubstring(`"listenerName"="workload-cluster1"`),
+		ContainSubstring(fmt.Sprintf(`"port"=%d`, DefaultMinPort+6200)),
+	)))
+	g.Expect(lines).To(ContainElement(And(
+		ContainSubstring(`"msg"="APIServer instance added to workloadClusterListener"`),
+		ContainSubstring(`"listenerName"="workload-cluster1"`),
+		ContainSubstring(fmt.Sprintf(`"port"=%d`, DefaultMinPort+6200)),
+		ContainSubstring(`"podName"="kube-apiserver-1"`),
+	)))
+}
+
+func TestGetCertificateLogging(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	var lock sync.Mutex
+	var lines []string
+	logger := funcr.New(func(prefix, args string) {
+		lock.Lock()
+		defer lock.Unlock()
+		lines = append(lines, args)
+	}, funcr.Options{Verbosity: 4})
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6300, DefaultMinPort+6399),
+		WithDebugPort(DefaultDebugPort+76),
+		WithLogger(logger),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	for _, serverName := range []string{"", "etcd-1"} {
+		conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, ServerName: serverName, MinVersion: tls.VersionTLS12})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(conn.Close()).To(Succeed())
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	lock.Lock()
+	defer lock.Unlock()
+	g.Expect(lines).To(ContainElement(And(
+		ContainSubstring(`"msg"="Using API server serving certificate"`),
+		ContainSubstring(`"listenerName"="workload-cluster1"`),
+		ContainSubstring(`"serverName"=""`),
+		ContainSubstring(fmt.Sprintf(`"hostPort"=%q`, listener.HostPort())),
+	)))
+	g.Expect(lines).To(ContainElement(And(
+		ContainSubstring(`"msg"="Using etcd serving certificate"`),
+		ContainSubstring(`"listenerName"="workload-cluster1"`),
+		ContainSubstring(`"serverName"="etcd-1"`),
+		ContainSubstring(fmt.Sprintf(`"hostPort"=%q`, listener.HostPort())),
+	)))
+}
+
+func TestCAAccessors(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6400, DefaultMinPort+6499),
+		WithDebugPort(DefaultDebugPort+77),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, ok := wcmux.APIServerCA(wcl)
+	g.Expect(ok).To(BeFalse())
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, ok = wcmux.APIServerCA(wcl)
+	g.Expect(ok).To(BeFalse())
+	_, ok = wcmux.EtcdCA(wcl)
+	g.Expect(ok).To(BeFalse())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+	etcdCACert, etcdCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", etcdCACert, etcdCAKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	apiServerCA, ok := wcmux.APIServerCA(wcl)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(apiServerCA.Equal(caCert)).To(BeTrue())
+
+	etcdCA, ok := wcmux.EtcdCA(wcl)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(etcdCA.Equal(etcdCACert)).To(BeTrue())
+
+	// The API server CA can be used to build a trust pool for clients.
+	pool := x509.NewCertPool()
+	pool.AddCert(apiServerCA)
+	conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conn.Close()).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAddAPIServerWithSANs(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6500, DefaultMinPort+6599),
+		WithDebugPort(DefaultDebugPort+78),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	servingCertificate := func(serverName string) (*x509.Certificate, error) {
+		conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, ServerName: serverName, MinVersion: tls.VersionTLS12})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0], nil
+	}
+
+	// By default only the host, localhost and loopback addresses are included.
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = servingCertificate("kube-apiserver.default.svc")
+	g.Expect(err).To(HaveOccurred())
+
+	// Adding extra SANs regenerates the serving certificate.
+	err = wcmux.AddAPIServerWithSANs(wcl, "kube-apiserver-2", caCert, caKey, []string{"kube-apiserver.default.svc", "10.0.0.1"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cert, err := servingCertificate("kube-apiserver.default.svc")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cert.DNSNames).To(ConsistOf("localhost", "kube-apiserver.default.svc"))
+	g.Expect(cert.IPAddresses).To(ContainElement(BeEquivalentTo(net.ParseIP("10.0.0.1").To4())))
+
+	// Extra SANs are preserved when rotating the serving certificate.
+	err = wcmux.RotateAPIServerCertificate(wcl, caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	cert, err = servingCertificate("kube-apiserver.default.svc")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cert.DNSNames).To(ContainElement("kube-apiserver.default.svc"))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestEtcdServingCertificateSANs(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6600, DefaultMinPort+6699),
+		WithDebugPort(DefaultDebugPort+79),
+		WithEtcdServiceName("etcd.kube-system.svc"),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	cert := leafCertificate(wcmux.workloadClusterListeners[wcl].etcdServingCertificates["etcd-1"])
+	wcmux.lock.RUnlock()
+	g.Expect(cert).ToNot(BeNil())
+
+	g.Expect(cert.DNSNames).To(ConsistOf("localhost", "etcd-1", "etcd.kube-system.svc"))
+	ips := []string{}
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	g.Expect(ips).To(ConsistOf("127.0.0.1", "::1", host))
+
+	// Clients connecting via the service name validate the certificate.
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "etcd.kube-system.svc", Roots: pool})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestCertificateFactory(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	// Use a precomputed ECDSA key for all the certificates.
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var lock sync.Mutex
+	commonNames := []string{}
+	factory := func(cfg CertConfig) (*x509.Certificate, crypto.Signer, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		commonNames = append(commonNames, cfg.Config.CommonName)
+
+		serial, err := cryptorand.Int(cryptorand.Reader, big.NewInt(math.MaxInt64))
+		if err != nil {
+			return nil, nil, err
+		}
+		tmpl := &x509.Certificate{
+			Subject:      pkix.Name{CommonName: cfg.Config.CommonName, Organization: cfg.Config.Organization},
+			DNSNames:     cfg.Config.AltNames.DNSNames,
+			IPAddresses:  cfg.Config.AltNames.IPs,
+			SerialNumber: serial,
+			NotBefore:    cfg.CACert.NotBefore,
+			NotAfter:     time.Now().Add(cfg.Validity),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  cfg.Config.Usages,
+		}
+		b, err := x509.CreateCertificate(cryptorand.Reader, tmpl, cfg.CACert, ecdsaKey.Public(), cfg.CAKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cert, ecdsaKey, nil
+	}
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6700, DefaultMinPort+6799),
+		WithDebugPort(DefaultDebugPort+80),
+		WithCertificateFactory(factory),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	lock.Lock()
+	g.Expect(commonNames).To(ConsistOf("kube-apiserver", "kubernetes-admin", "etcd-1"))
+	lock.Unlock()
+
+	// The serving certificate is generated by the factory.
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conn.ConnectionState().PeerCertificates[0].PublicKey).To(BeAssignableToTypeOf(&ecdsa.PublicKey{}))
+	g.Expect(conn.Close()).To(Succeed())
+
+	// The admin certificate generated by the factory can be used to access the API server.
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestCertificatesShareKey(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6800, DefaultMinPort+6899),
+		WithDebugPort(DefaultDebugPort+81),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, wcl := range []string{"workload-cluster1", "workload-cluster2"} {
+		manager.AddResourceGroup(wcl)
+		_, err = wcmux.InitWorkloadClusterListener(wcl)
+		g.Expect(err).ToNot(HaveOccurred())
+		err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+		err = wcmux.AddEtcdMember(wcl, "etcd-1", caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	// All the serving certificates are distinct, but they share the same private key.
+	wcmux.lock.RLock()
+	certificates := []*tls.Certificate{}
+	for _, wcl := range wcmux.workloadClusterListeners {
+		certificates = append(certificates, wcl.apiServerServingCertificate, wcl.etcdServingCertificates["etcd-1"])
+	}
+	wcmux.lock.RUnlock()
+
+	g.Expect(certificates).To(HaveLen(4))
+	serials := sets.New[string]()
+	for _, certificate := range certificates {
+		g.Expect(certificate.PrivateKey).To(Equal(certificates[0].PrivateKey))
+		serials.Insert(leafCertificate(certificate).SerialNumber.String())
+	}
+	g.Expect(serials.Len()).To(Equal(4))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAddAPIServersAndEtcdMembers(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+6900, DefaultMinPort+6999),
+		WithDebugPort(DefaultDebugPort+82),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcls := []string{"workload-cluster1", "workload-cluster2"}
+	listeners := map[string]*WorkloadClusterListener{}
+	apiServerSpecs := []APIServerSpec{}
+	etcdMemberSpecs := []EtcdMemberSpec{}
+	for _, wcl := range wcls {
+		manager.AddResourceGroup(wcl)
+		listener, err := wcmux.InitWorkloadClusterListener(wcl)
+		g.Expect(err).ToNot(HaveOccurred())
+		listeners[wcl] = listener
+
+		apiServerSpecs = append(apiServerSpecs, APIServerSpec{ListenerName: wcl, PodName: "kube-apiserver-1", CACert: caCert, CAKey: caKey})
+		etcdMemberSpecs = append(etcdMemberSpecs, EtcdMemberSpec{ListenerName: wcl, PodName: "etcd-1", CACert: caCert, CAKey: caKey})
+	}
+
+	// Specs for listeners not initialized fail, without preventing the other specs to be processed.
+	apiServerSpecs = append(apiServerSpecs, APIServerSpec{ListenerName: "not-existing", PodName: "kube-apiserver-1", CACert: caCert, CAKey: caKey})
+	etcdMemberSpecs = append(etcdMemberSpecs, EtcdMemberSpec{ListenerName: "not-existing", PodName: "etcd-1", CACert: caCert, CAKey: caKey})
+
+	err = wcmux.AddAPIServers(apiServerSpecs)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("failed to add APIServer kube-apiserver-1 to WorkloadClusterListener not-existing (spec 2)"))
+
+	err = wcmux.AddEtcdMembers(etcdMemberSpecs)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(errors.Is(err, ErrListenerNotFound)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("failed to add etcd member etcd-1 to WorkloadClusterListener not-existing (spec 2)"))
+
+	for _, wcl := range wcls {
+		g.Expect(wcmux.HasAPIServer(wcl, "kube-apiserver-1")).To(BeTrue())
+
+		c, err := listeners[wcl].GetClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	}
+	for _, listener := range wcmux.Snapshot().Listeners {
+		g.Expect(listener.EtcdMembers).To(ConsistOf("etcd-1"))
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestCertificatesGeneratedWithoutLock(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	// The factory fails if the lock of the mux is held while generating certificates, because generating
+	// certificates under the lock blocks all the other listeners and TLS handshakes.
+	var wcmux *WorkloadClustersMux
+	factory := func(cfg CertConfig) (*x509.Certificate, crypto.Signer, error) {
+		if !wcmux.lock.TryLock() {
+			return nil, nil, errors.Errorf("certificate %s generated while holding the lock", cfg.Config.CommonName)
+		}
+		wcmux.lock.Unlock()
+		return defaultCertificateFactory(cfg)
+	}
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	var err error
+	wcmux, err = NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8800, DefaultMinPort+8899),
+		WithDebugPort(DefaultDebugPort+101),
+		WithCertificateFactory(factory),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcls := []string{"workload-cluster1", "workload-cluster2"}
+	apiServerSpecs := []APIServerSpec{}
+	for _, wcl := range wcls {
+		manager.AddResourceGroup(wcl)
+		_, err := wcmux.InitWorkloadClusterListener(wcl)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		apiServerSpecs = append(apiServerSpecs,
+			APIServerSpec{ListenerName: wcl, PodName: "kube-apiserver-1", CACert: caCert, CAKey: caKey},
+			APIServerSpec{ListenerName: wcl, PodName: "kube-apiserver-2", CACert: caCert, CAKey: caKey},
+		)
+	}
+
+	g.Expect(wcmux.AddAPIServers(apiServerSpecs)).To(Succeed())
+	g.Expect(wcmux.AddEtcdMembers([]EtcdMemberSpec{
+		{ListenerName: wcls[0], PodName: "etcd-1", CACert: caCert, CAKey: caKey},
+		{ListenerName: wcls[0], PodName: "etcd-2", CACert: caCert, CAKey: caKey},
+	})).To(Succeed())
+
+	otherCACert, otherCAKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(wcmux.RotateAPIServerCertificate(wcls[0], otherCACert, otherCAKey)).To(Succeed())
+	g.Expect(wcmux.ReloadCA(wcls[0], caCert, caKey)).To(Succeed())
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestConcurrentProvisioning(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7000, DefaultMinPort+7099),
+		WithDebugPort(DefaultDebugPort+83),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	listeners := map[string]*WorkloadClusterListener{}
+	for i := 0; i < 4; i++ {
+		wcl := fmt.Sprintf("workload-cluster%d", i)
+		manager.AddResourceGroup(wcl)
+		listener, err := wcmux.InitWorkloadClusterListener(wcl)
+		g.Expect(err).ToNot(HaveOccurred())
+		listeners[wcl] = listener
+	}
+
+	// Provision all the listeners concurrently, adding API servers and etcd members to the same listener
+	// from different goroutines, while TLS handshakes are served for the listeners already started.
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	stopHandshakes := make(chan struct{})
+	handshakesDone := make(chan struct{})
+	go func() {
+		defer close(handshakesDone)
+		for {
+			select {
+			case <-stopHandshakes:
+				return
+			default:
+			}
+			for _, listener := range listeners {
+				conn, err := tls.Dial("tcp", listener.HostPort(), &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+				if err == nil {
+					_ = conn.Close()
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(listeners)*4)
+	for wcl := range listeners {
+		for i := 1; i <= 2; i++ {
+			wg.Add(2)
+			go func(wcl string, i int) {
+				defer wg.Done()
+				errCh <- wcmux.AddAPIServer(wcl, fmt.Sprintf("kube-apiserver-%d", i), caCert, caKey)
+			}(wcl, i)
+			go func(wcl string, i int) {
+				defer wg.Done()
+				errCh <- wcmux.AddEtcdMember(wcl, fmt.Sprintf("etcd-%d", i), caCert, caKey)
+			}(wcl, i)
+		}
+	}
+	wg.Wait()
+	close(errCh)
+	close(stopHandshakes)
+	<-handshakesDone
+
+	for err := range errCh {
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	// All the listeners are fully provisioned, with a single serving certificate for the API servers.
+	for _, listener := range wcmux.Snapshot().Listeners {
+		g.Expect(listener.Started).To(BeTrue())
+		g.Expect(listener.APIServers).To(ConsistOf("kube-apiserver-1", "kube-apiserver-2"))
+		g.Expect(listener.EtcdMembers).To(ConsistOf("etcd-1", "etcd-2"))
+	}
+	for _, listener := range listeners {
+		c, err := listener.GetClient()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+	}
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestDebugTimeout(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7100, DefaultMinPort+7199),
+		WithDebugPort(DefaultDebugPort+84),
+		WithDebugTimeout(100*time.Millisecond),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	url := fmt.Sprintf("http://%s/listeners", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultDebugPort+84)))
+
+	resp, err := http.Get(url)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.Body.Close()).To(Succeed())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	// Simulate the mux being busy by holding the lock; the debug server responds anyway.
+	wcmux.lock.Lock()
+	resp, err = http.Get(url)
+	g.Expect(err).ToNot(HaveOccurred())
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.Body.Close()).To(Succeed())
+	wcmux.lock.Unlock()
+
+	g.Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+	g.Expect(string(body)).To(ContainSubstring("failed to collect debug info within 100ms"))
+
+	// Collecting debug info returns when the context is done, so nothing is left waiting for the lock.
+	wcmux.lock.Lock()
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = wcmux.ListListenersWithContext(timeoutCtx)
+	cancel()
+	wcmux.lock.Unlock()
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+
+	// Debug info are collected again once the mux is not busy anymore.
+	resp, err = http.Get(url)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.Body.Close()).To(Succeed())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestListenerRequestStats(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7200, DefaultMinPort+7299),
+		WithDebugPort(DefaultDebugPort+85),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Requests are counted, and no error is reported if all the requests succeeded.
+	details := wcmux.ListenerDetails()[wcl]
+	g.Expect(details.Requests).To(BeNumerically(">", 0))
+	g.Expect(details.LastError).To(BeNil())
+	requests := details.Requests
+
+	// Errors are reported.
+	wcmux.SetAPIServerHealth(wcl, false)
+	g.Expect(c.List(ctx, &corev1.NodeList{})).ToNot(Succeed())
+
+	details = wcmux.ListenerDetails()[wcl]
+	g.Expect(details.Requests).To(BeNumerically(">", requests))
+	g.Expect(details.LastError).ToNot(BeNil())
+	g.Expect(details.LastError.Message).To(Equal("503 Service Unavailable: GET /api/v1/nodes"))
+	g.Expect(details.LastError.Time.Time).To(BeTemporally("~", time.Now(), time.Minute))
+
+	// Request stats are exposed by the debug handler.
+	resp, err := http.Get(fmt.Sprintf("http://%s/listeners/details", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultDebugPort+85))))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(body)).To(ContainSubstring(`"requests"`))
+	g.Expect(string(body)).To(ContainSubstring(`"message": "503 Service Unavailable: GET /api/v1/nodes"`))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestRestartListener(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7300, DefaultMinPort+7399),
+		WithDebugPort(DefaultDebugPort+86),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Listeners without API servers can't be restarted.
+	_, err = wcmux.RestartListener(ctx, wcl)
+	g.Expect(err).To(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	oldCertificate := leafCertificate(wcmux.workloadClusterListeners[wcl].apiServerServingCertificate)
+	wcmux.lock.RUnlock()
+
+	restart := func(name string) (int, string) {
+		url := fmt.Sprintf("http://%s/listeners/%s/restart", net.JoinHostPort(host, fmt.Sprintf("%d", DefaultDebugPort+86)), name)
+		resp, err := http.Post(url, "application/json", http.NoBody)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode, string(body)
+	}
+
+	// The listener is restarted via the debug server, and the new address is returned.
+	status, body := restart(wcl)
+	g.Expect(status).To(Equal(http.StatusOK))
+	g.Expect(body).To(ContainSubstring(listener.Address()))
+
+	// The serving certificate has been regenerated and the listener serves again.
+	wcmux.lock.RLock()
+	newCertificate := leafCertificate(wcmux.workloadClusterListeners[wcl].apiServerServingCertificate)
+	wcmux.lock.RUnlock()
+	g.Expect(newCertificate.Equal(oldCertificate)).To(BeFalse())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Restarting a listener that does not exist fails with not found.
+	status, body = restart("not-existing")
+	g.Expect(status).To(Equal(http.StatusNotFound))
+	g.Expect(body).To(ContainSubstring(ErrListenerNotFound.Error()))
+
+	// Other failures are reported as internal errors.
+	wcl2 := "workload-cluster2"
+	manager.AddResourceGroup(wcl2)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl2)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	status, body = restart(wcl2)
+	g.Expect(status).To(Equal(http.StatusInternalServerError))
+	g.Expect(body).To(ContainSubstring("an API server must be added first"))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestDesiredAPIServers(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7400, DefaultMinPort+7499),
+		WithDebugPort(DefaultDebugPort+87),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	_, err = wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Without a desired number of API servers, the listener is never converged.
+	g.Expect(wcmux.APIServersConverged(wcl)).To(BeFalse())
+	g.Expect(wcmux.ListenerDetails()[wcl].DesiredAPIServers).To(BeNil())
+
+	wcmux.SetDesiredAPIServers(wcl, 3)
+	g.Expect(wcmux.APIServersConverged(wcl)).To(BeFalse())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for i := 1; i <= 3; i++ {
+		g.Expect(wcmux.APIServersConverged(wcl)).To(BeFalse())
+		err = wcmux.AddAPIServer(wcl, fmt.Sprintf("kube-apiserver-%d", i), caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(wcmux.APIServersConverged(wcl)).To(BeTrue())
+
+	details := wcmux.ListenerDetails()[wcl]
+	g.Expect(details.APIServers).To(Equal(3))
+	g.Expect(details.DesiredAPIServers).To(HaveValue(Equal(3)))
+
+	// Scaling down.
+	wcmux.SetDesiredAPIServers(wcl, 1)
+	g.Expect(wcmux.APIServersConverged(wcl)).To(BeFalse())
+	g.Expect(wcmux.DeleteAPIServer(wcl, "kube-apiserver-2")).To(Succeed())
+	g.Expect(wcmux.DeleteAPIServer(wcl, "kube-apiserver-3")).To(Succeed())
+	g.Expect(wcmux.APIServersConverged(wcl)).To(BeTrue())
+
+	// Unsetting the desired number of API servers.
+	wcmux.SetDesiredAPIServers(wcl, -1)
+	g.Expect(wcmux.APIServersConverged(wcl)).To(BeFalse())
+	g.Expect(wcmux.ListenerDetails()[wcl].DesiredAPIServers).To(BeNil())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestEphemeralPorts(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7500, DefaultMinPort+7500),
+		WithDebugPort(DefaultDebugPort+88),
+		WithEphemeralPorts(),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Initialize more listeners than the port range allows.
+	listeners := map[string]*WorkloadClusterListener{}
+	ports := sets.Set[int]{}
+	for _, wclName := range []string{"workload-cluster1", "workload-cluster2"} {
+		manager.AddResourceGroup(wclName)
+
+		listener, err := wcmux.InitWorkloadClusterListener(wclName)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(listener.Port()).To(BeNumerically(">", 0))
+		listeners[wclName] = listener
+		ports.Insert(listener.Port())
+
+		// The port assigned by the OS stays bound until the listener is started.
+		g.Expect(isPortAvailable(host, listener.Port())).To(BeFalse())
+
+		wcmux.lock.RLock()
+		g.Expect(wcmux.workloadClusterNameByHost).To(HaveKeyWithValue(listener.HostPort(), wclName))
+		wcmux.lock.RUnlock()
+	}
+	g.Expect(ports.Len()).To(Equal(2))
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Starting the listener uses the port assigned by the OS.
+	wcl1 := "workload-cluster1"
+	err = wcmux.AddAPIServer(wcl1, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listeners[wcl1].GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Deleting a listener which is not started releases the port.
+	wcl2 := "workload-cluster2"
+	g.Expect(wcmux.DeleteWorkloadClusterListener(wcl2)).To(Succeed())
+	g.Expect(isPortAvailable(host, listeners[wcl2].Port())).To(BeTrue())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestEphemeralPortsDryRun(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+9310, DefaultMinPort+9310),
+		WithDebugPort(DefaultDebugPort+108),
+		WithEphemeralPorts(),
+		WithDryRun(),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// In dry-run mode ports are taken from the port range.
+	wcl1 := "workload-cluster1"
+	manager.AddResourceGroup(wcl1)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl1)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Port()).To(Equal(DefaultMinPort + 9310))
+
+	// Deleting the listener releases the port, so it can be used by another listener.
+	g.Expect(wcmux.DeleteWorkloadClusterListener(wcl1)).To(Succeed())
+
+	wcl2 := "workload-cluster2"
+	manager.AddResourceGroup(wcl2)
+
+	listener, err = wcmux.InitWorkloadClusterListener(wcl2)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Port()).To(Equal(DefaultMinPort + 9310))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestShutdownWaitsForListeners(t *testing.T) {
+	// NOTE: This test doesn't run in parallel with other tests, so their goroutines are not reported as leaks;
+	// goroutines already running when the test starts are ignored.
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	g := NewWithT(t)
+
+	muxGoroutines := countWorkloadClustersMuxGoroutines()
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7600, DefaultMinPort+7699),
+		WithDebugPort(DefaultDebugPort+89),
+		WithIdleListenerTimeout(time.Minute),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	httpClient := &http.Client{Transport: transport}
+
+	for _, wclName := range []string{"workload-cluster1", "workload-cluster2"} {
+		manager.AddResourceGroup(wclName)
+
+		listener, err := wcmux.InitWorkloadClusterListener(wclName)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		err = wcmux.AddAPIServer(wclName, "kube-apiserver-1", caCert, caKey)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		resp, err := httpClient.Get(listener.Address() + "/api/v1/nodes")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Body.Close()).To(Succeed())
+	}
+	transport.CloseIdleConnections()
+	g.Expect(countWorkloadClustersMuxGoroutines()).To(BeNumerically(">", muxGoroutines))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Goroutines serving the listeners already exited when Shutdown returns.
+	g.Expect(countWorkloadClustersMuxGoroutines()).To(BeNumerically("<=", muxGoroutines))
+
+	// Listeners can't be started after Shutdown.
+	manager.AddResourceGroup("workload-cluster3")
+	_, err = wcmux.InitWorkloadClusterListener("workload-cluster3")
+	g.Expect(err).ToNot(HaveOccurred())
+	err = wcmux.AddAPIServer("workload-cluster3", "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("shut down"))
+}
+
+// countWorkloadClustersMuxGoroutines returns the number of goroutines started by workload clusters muxes.
+func countWorkloadClustersMuxGoroutines() int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := goruntime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	count := 0
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, "inmemory/internal/server.(*WorkloadClustersMux).") || strings.Contains(stack, "inmemory/internal/server.NewWorkloadClustersMux.") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestWorkloadClusterListenerAddr(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7800, DefaultMinPort+7899),
+		WithDebugPort(DefaultDebugPort+91),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The address is available before the listener is started.
+	addr, ok := listener.Addr().(*net.TCPAddr)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(addr.IP.String()).To(Equal(host))
+	g.Expect(addr.Port).To(Equal(listener.Port()))
+	g.Expect(addr.String()).To(Equal(listener.HostPort()))
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// The address can be used to connect to the listener once started.
+	conn, err := net.DialTimeout(listener.Addr().Network(), listener.Addr().String(), time.Second)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conn.RemoteAddr().String()).To(Equal(listener.Addr().String()))
+	g.Expect(conn.Close()).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// Listeners using a Unix domain socket have a Unix address.
+	socketsDir := t.TempDir()
+	unixMux, err := NewWorkloadClustersMux(manager, host, WithUnixSockets(socketsDir), WithDryRun())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	unixListener, err := unixMux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(unixListener.Addr()).To(Equal(&net.UnixAddr{Name: filepath.Join(socketsDir, "workload-cluster1.sock"), Net: "unix"}))
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+7900, DefaultMinPort+7999),
+		WithDebugPort(DefaultDebugPort+92),
+		WithTCPKeepAlive(30*time.Second),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcmux.lock.RLock()
+	adminCertificate := newTLSCertificate(listener.adminCertificate, listener.adminKey)
+	wcmux.lock.RUnlock()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:            pool,
+			Certificates:       []tls.Certificate{*adminCertificate},
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+			MinVersion:         tls.VersionTLS12,
+		},
+		// Use a new connection for each request.
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport}
+
+	// The first connection does a full handshake, the following ones resume the TLS session.
+	for i, resume := range []bool{false, true, true} {
+		resp, err := httpClient.Get(listener.Address() + "/api/v1/nodes")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = io.Copy(io.Discard, resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Body.Close()).To(Succeed())
+		g.Expect(resp.StatusCode).To(Equal(http.StatusOK), "request %d", i)
+		g.Expect(resp.TLS.DidResume).To(Equal(resume), "request %d", i)
+	}
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAddAPIServerInsecure(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8000, DefaultMinPort+8099),
+		WithDebugPort(DefaultDebugPort+93),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServerInsecure(wcl, "kube-apiserver-1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Address()).To(Equal(fmt.Sprintf("http://%s", listener.HostPort())))
+
+	// A trivial HTTP client can be used.
+	resp, err := http.Get(listener.Address() + "/api/v1/nodes")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(resp.Body.Close()).To(Succeed())
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	// Clients using the admin kubeconfig work as well.
+	kubeconfig, err := wcmux.AdminKubeconfig(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(restConfig.Host).To(Equal(listener.Address()))
+	g.Expect(restConfig.TLSClientConfig.CAData).To(BeEmpty())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// Restarting the listener keeps serving plain HTTP.
+	_, err = wcmux.RestartListener(ctx, wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	// API servers serving HTTPS can't be added while there are API servers serving plain HTTP.
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-2", caCert, caKey)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("plain HTTP"))
+
+	// Once all the API servers are removed, API servers serving HTTPS can be added.
+	err = wcmux.DeleteAPIServer(wcl, "kube-apiserver-1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-2", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(listener.Address()).To(Equal(fmt.Sprintf("https://%s", listener.HostPort())))
+
+	err = wcmux.AddAPIServerInsecure(wcl, "kube-apiserver-3")
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("serve HTTPS"))
+
+	c, err = listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestWatchBookmarks(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8100, DefaultMinPort+8199),
+		WithDebugPort(DefaultDebugPort+94),
+		WithWatchBookmarkInterval(200*time.Millisecond),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	node := &corev1.Node{}
+	node.SetName("foo")
+	g.Expect(c.Create(ctx, node)).To(Succeed())
+
+	nodes := &corev1.NodeList{}
+	g.Expect(c.List(ctx, nodes)).To(Succeed())
+
+	// A long-lived watch with no data changes receives periodic bookmarks with the latest resource version.
+	watcher, err := c.Watch(ctx, &corev1.NodeList{}, &client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: nodes.ResourceVersion, AllowWatchBookmarks: true}})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-watcher.ResultChan():
+			g.Expect(event.Type).To(Equal(watch.Bookmark))
+			o, ok := event.Object.(*corev1.Node)
+			g.Expect(ok).To(BeTrue())
+			g.Expect(o.GetResourceVersion()).To(Equal(nodes.ResourceVersion))
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a bookmark")
+		}
+	}
+
+	// Bookmarks carry the resource version of changes happening after the watch started.
+	node.SetLabels(map[string]string{"foo": "bar"})
+	g.Expect(c.Update(ctx, node)).To(Succeed())
+
+	g.Eventually(func() string {
+		event := <-watcher.ResultChan()
+		if event.Type != watch.Bookmark {
+			return ""
+		}
+		return event.Object.(*corev1.Node).GetResourceVersion()
+	}, 5*time.Second).Should(Equal(node.GetResourceVersion()))
+
+	// Watches not requesting bookmarks don't receive them.
+	noBookmarksWatcher, err := c.Watch(ctx, &corev1.NodeList{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Consistently(noBookmarksWatcher.ResultChan(), time.Second).ShouldNot(Receive())
+
+	// NOTE: Watches must be stopped before shutting down, otherwise shutdown waits for the watches to time out.
+	watcher.Stop()
+	noBookmarksWatcher.Stop()
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_Table(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8200, DefaultMinPort+8299),
+		WithDebugPort(DefaultDebugPort+95),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for _, name := range []string{"foo", "bar"} {
+		node := &corev1.Node{}
+		node.SetName(name)
+		g.Expect(c.Create(ctx, node)).To(Succeed())
+	}
+
+	restConfig, err := listener.RESTConfig()
+	g.Expect(err).ToNot(HaveOccurred())
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// getTable gets a path using the Accept header used by kubectl get.
+	getTable := func(path string) *metav1.Table {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, listener.Address()+path, http.NoBody)
+		g.Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json;as=Table;v=v1beta1;g=meta.k8s.io,application/json")
+
+		resp, err := httpClient.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		table := &metav1.Table{}
+		g.Expect(json.NewDecoder(resp.Body).Decode(table)).To(Succeed())
+		g.Expect(table.Kind).To(Equal("Table"))
+		g.Expect(table.APIVersion).To(Equal("meta.k8s.io/v1"))
+		g.Expect(table.ColumnDefinitions).To(HaveLen(2))
+		g.Expect(table.ColumnDefinitions[0].Name).To(Equal("Name"))
+		g.Expect(table.ColumnDefinitions[1].Name).To(Equal("Age"))
+		return table
+	}
+
+	table := getTable("/api/v1/nodes")
+	g.Expect(table.ResourceVersion).ToNot(BeEmpty())
+	g.Expect(table.Rows).To(HaveLen(2))
+	g.Expect(table.Rows[0].Cells).To(HaveLen(2))
+	g.Expect([]interface{}{table.Rows[0].Cells[0], table.Rows[1].Cells[0]}).To(ConsistOf("foo", "bar"))
+
+	// Rows include the object metadata, so kubectl can print namespaces and labels.
+	m := &metav1.PartialObjectMetadata{}
+	g.Expect(json.Unmarshal(table.Rows[0].Object.Raw, m)).To(Succeed())
+	g.Expect(m.Kind).To(Equal("PartialObjectMetadata"))
+	g.Expect(m.Name).To(Equal(table.Rows[0].Cells[0]))
+
+	table = getTable("/api/v1/nodes/foo?includeObject=None")
+	g.Expect(table.Rows).To(HaveLen(1))
+	g.Expect(table.Rows[0].Cells[0]).To(Equal("foo"))
+	g.Expect(table.Rows[0].Object.Raw).To(BeEmpty())
+
+	// Clients not asking for a Table still get the objects.
+	g.Expect(c.List(ctx, &corev1.NodeList{})).To(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_StatusErrors(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8300, DefaultMinPort+8399),
+		WithDebugPort(DefaultDebugPort+96),
+	)
+
+	node := &corev1.Node{}
+	err := c.Get(ctx, client.ObjectKey{Name: "foo"}, node)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "expected NotFound, got %v", err)
+
+	node.SetName("foo")
+	g.Expect(c.Create(ctx, node)).To(Succeed())
+	err = c.Create(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "foo"}})
+	g.Expect(apierrors.IsAlreadyExists(err)).To(BeTrue(), "expected AlreadyExists, got %v", err)
+
+	// Updates using a stale resource version fail with a Conflict.
+	stale := node.DeepCopy()
+	node.SetLabels(map[string]string{"foo": "bar"})
+	g.Expect(c.Update(ctx, node)).To(Succeed())
+	stale.SetLabels(map[string]string{"foo": "baz"})
+	err = c.Update(ctx, stale)
+	g.Expect(apierrors.IsConflict(err)).To(BeTrue(), "expected Conflict, got %v", err)
+
+	g.Expect(c.Delete(ctx, node)).To(Succeed())
+	err = c.Delete(ctx, node)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue(), "expected NotFound, got %v", err)
+
+	// Requests for unknown resources get a NotFound Status with the matching HTTP status code.
+	kubeconfig, err := wcmux.AdminKubeconfig("workload-cluster1")
+	g.Expect(err).ToNot(HaveOccurred())
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	httpClient, err := rest.HTTPClientFor(restConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	resp, err := httpClient.Get(restConfig.Host + "/api/v1/foos")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+	status := &metav1.Status{}
+	g.Expect(json.NewDecoder(resp.Body).Decode(status)).To(Succeed())
+	g.Expect(status.Kind).To(Equal("Status"))
+	g.Expect(status.Reason).To(Equal(metav1.StatusReasonNotFound))
+	g.Expect(status.Code).To(Equal(int32(http.StatusNotFound)))
+	g.Expect(status.Message).To(ContainSubstring("foos"))
+
+	// Requests with invalid selectors get a BadRequest Status.
+	resp, err = httpClient.Get(restConfig.Host + "/api/v1/nodes?labelSelector=" + url.QueryEscape("foo in ("))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	status = &metav1.Status{}
+	g.Expect(json.NewDecoder(resp.Body).Decode(status)).To(Succeed())
+	g.Expect(status.Reason).To(Equal(metav1.StatusReasonBadRequest))
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_OptimisticConcurrency(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8400, DefaultMinPort+8499),
+		WithDebugPort(DefaultDebugPort+97),
+	)
+
+	node := &corev1.Node{}
+	node.SetName("foo")
+	g.Expect(c.Create(ctx, node)).To(Succeed())
+	stale := node.DeepCopy()
+
+	// Successful updates bump the resourceVersion.
+	node.SetLabels(map[string]string{"update": "1"})
+	g.Expect(c.Update(ctx, node)).To(Succeed())
+	g.Expect(node.GetResourceVersion()).ToNot(Equal(stale.GetResourceVersion()))
+
+	// Updates with a stale resourceVersion fail with a Conflict.
+	stale.SetLabels(map[string]string{"update": "2"})
+	err := c.Update(ctx, stale.DeepCopy())
+	g.Expect(apierrors.IsConflict(err)).To(BeTrue(), "expected Conflict, got %v", err)
+
+	// Updates without a resourceVersion are unconditional.
+	unconditional := stale.DeepCopy()
+	unconditional.SetResourceVersion("")
+	g.Expect(c.Update(ctx, unconditional)).To(Succeed())
+	g.Expect(unconditional.GetLabels()).To(HaveKeyWithValue("update", "2"))
+
+	// Patches with a stale resourceVersion fail with a Conflict.
+	patched := stale.DeepCopy()
+	patched.SetLabels(map[string]string{"patch": "1"})
+	err = c.Patch(ctx, patched, client.MergeFromWithOptions(stale, client.MergeFromWithOptimisticLock{}))
+	g.Expect(apierrors.IsConflict(err)).To(BeTrue(), "expected Conflict, got %v", err)
+
+	// Patches without a resourceVersion are applied to the latest version.
+	patched = stale.DeepCopy()
+	patched.SetLabels(map[string]string{"patch": "1"})
+	g.Expect(c.Patch(ctx, patched, client.MergeFrom(stale))).To(Succeed())
+	g.Expect(patched.GetLabels()).To(HaveKeyWithValue("patch", "1"))
+	g.Expect(patched.GetResourceVersion()).ToNot(Equal(unconditional.GetResourceVersion()))
+
+	// Server-side apply patches with a stale resourceVersion fail with a Conflict.
+	applied := &corev1.Node{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Node"},
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", ResourceVersion: stale.GetResourceVersion(), Labels: map[string]string{"apply": "1"}},
+	}
+	err = c.Patch(ctx, applied, client.Apply, client.FieldOwner("test"), client.ForceOwnership)
+	g.Expect(apierrors.IsConflict(err)).To(BeTrue(), "expected Conflict, got %v", err)
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestRegisterMutator(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	wcmux, c := setupWorkloadClusterListener(g,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8500, DefaultMinPort+8599),
+		WithDebugPort(DefaultDebugPort+98),
+	)
+
+	// Simulate a defaulting webhook for Nodes.
+	wcmux.RegisterMutator(corev1.SchemeGroupVersion.WithKind("Node"), func(obj runtime.Object) {
+		node := obj.(*corev1.Node)
+		if node.Spec.PodCIDR == "" {
+			node.Spec.PodCIDR = "10.0.0.0/24"
+		}
+	})
+	// Mutators are called in the order they are registered.
+	wcmux.RegisterMutator(corev1.SchemeGroupVersion.WithKind("Node"), func(obj runtime.Object) {
+		node := obj.(*corev1.Node)
+		node.SetLabels(map[string]string{"podCIDR": strings.ReplaceAll(node.Spec.PodCIDR, "/", "_")})
+	})
+
+	node := &corev1.Node{}
+	node.SetName("foo")
+	g.Expect(c.Create(ctx, node)).To(Succeed())
+	g.Expect(node.Spec.PodCIDR).To(Equal("10.0.0.0/24"))
+	g.Expect(node.GetLabels()).To(HaveKeyWithValue("podCIDR", "10.0.0.0_24"))
+
+	// Mutators are called on updates, and the mutated object is stored.
+	node.Spec.PodCIDR = ""
+	g.Expect(c.Update(ctx, node)).To(Succeed())
+	g.Expect(node.Spec.PodCIDR).To(Equal("10.0.0.0/24"))
+
+	stored := &corev1.Node{}
+	g.Expect(c.Get(ctx, client.ObjectKey{Name: "foo"}, stored)).To(Succeed())
+	g.Expect(stored.Spec.PodCIDR).To(Equal("10.0.0.0/24"))
+
+	// Mutators are called on patches, and the mutated object is stored.
+	g.Expect(c.Patch(ctx, node, client.RawPatch(types.MergePatchType, []byte(`{"spec":{"podCIDR":"10.0.1.0/24"}}`)))).To(Succeed())
+	g.Expect(node.GetLabels()).To(HaveKeyWithValue("podCIDR", "10.0.1.0_24"))
+
+	stored = &corev1.Node{}
+	g.Expect(c.Get(ctx, client.ObjectKey{Name: "foo"}, stored)).To(Succeed())
+	g.Expect(stored.GetLabels()).To(HaveKeyWithValue("podCIDR", "10.0.1.0_24"))
+
+	// Mutators are called on objects created by server-side apply.
+	appliedNode := &unstructured.Unstructured{}
+	appliedNode.SetAPIVersion("v1")
+	appliedNode.SetKind("Node")
+	appliedNode.SetName("baz")
+	g.Expect(c.Patch(ctx, appliedNode, client.Apply, client.FieldOwner("test"))).To(Succeed())
+
+	stored = &corev1.Node{}
+	g.Expect(c.Get(ctx, client.ObjectKey{Name: "baz"}, stored)).To(Succeed())
+	g.Expect(stored.Spec.PodCIDR).To(Equal("10.0.0.0/24"))
+	g.Expect(stored.GetLabels()).To(HaveKeyWithValue("podCIDR", "10.0.0.0_24"))
+
+	// Mutators are called only for the kind they are registered for.
+	pod := &corev1.Pod{}
+	pod.SetName("bar")
+	pod.SetNamespace("default")
+	g.Expect(c.Create(ctx, pod)).To(Succeed())
+	g.Expect(pod.GetLabels()).To(BeEmpty())
+
+	err := wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestAPI_Pagination(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8600, DefaultMinPort+8699),
+		WithDebugPort(DefaultDebugPort+99),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c, err := listener.GetClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// NOTE: Objects are created directly in the resource group, so the test is not slowed down by client-side throttling.
+	for i := 0; i < 250; i++ {
+		node := &corev1.Node{}
+		node.SetName(fmt.Sprintf("node-%03d", i))
+		g.Expect(manager.GetResourceGroup(wcl).GetClient().Create(ctx, node)).To(Succeed())
+	}
+
+	names := []string{}
+	pages := []int{}
+	var resourceVersion, continueToken string
+	for {
+		nodes := &corev1.NodeList{}
+		g.Expect(c.List(ctx, nodes, client.Limit(100), client.Continue(continueToken))).To(Succeed())
+		for _, node := range nodes.Items {
+			names = append(names, node.Name)
+		}
+		pages = append(pages, len(nodes.Items))
+
+		// All the pages have the resource version of the first page.
+		if resourceVersion == "" {
+			resourceVersion = nodes.ResourceVersion
+		}
+		g.Expect(nodes.ResourceVersion).To(Equal(resourceVersion))
+
+		continueToken = nodes.Continue
+		if continueToken == "" {
+			g.Expect(nodes.RemainingItemCount).To(BeNil())
+			break
+		}
+		g.Expect(*nodes.RemainingItemCount).To(Equal(int64(250 - len(names))))
+	}
+	g.Expect(pages).To(Equal([]int{100, 100, 50}))
+	g.Expect(names).To(HaveLen(250))
+	g.Expect(sets.New(names...).Len()).To(Equal(250))
+	for i, name := range names {
+		g.Expect(name).To(Equal(fmt.Sprintf("node-%03d", i)))
+	}
+
+	// Lists without a limit return all the objects.
+	nodes := &corev1.NodeList{}
+	g.Expect(c.List(ctx, nodes)).To(Succeed())
+	g.Expect(nodes.Items).To(HaveLen(250))
+	g.Expect(nodes.Continue).To(BeEmpty())
+
+	// Invalid continue tokens are rejected.
+	err = c.List(ctx, &corev1.NodeList{}, client.Limit(100), client.Continue("not-a-token"))
+	g.Expect(apierrors.IsBadRequest(err)).To(BeTrue(), "expected BadRequest, got %v", err)
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func TestClockCertificateExpiry(t *testing.T) {
+	t.Parallel()
+	g := NewWithT(t)
+
+	manager := cmanager.New(scheme)
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	host := "127.0.0.1"
+	wcmux, err := NewWorkloadClustersMux(manager, host,
+		// NOTE: make sure to use ports different than other tests, so we can run tests in parallel
+		WithPortRange(DefaultMinPort+8700, DefaultMinPort+8799),
+		WithDebugPort(DefaultDebugPort+100),
+		WithClientCertVerification(),
+		WithCertificateValidity(time.Hour),
+		WithClock(fakeClock),
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	wcl := "workload-cluster1"
+	manager.AddResourceGroup(wcl)
+
+	listener, err := wcmux.InitWorkloadClusterListener(wcl)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	caCert, caKey, err := newCertificateAuthority()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	err = wcmux.AddAPIServer(wcl, "kube-apiserver-1", caCert, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	restConfig, err := listener.RESTConfig()
+	g.Expect(err).ToNot(HaveOccurred())
+	tlsConfig, err := rest.TLSConfigFor(restConfig)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// get uses a new connection for each request, so each request does a full TLS handshake.
+	get := func() error {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+		resp, err := httpClient.Get(restConfig.Host + "/api/v1/nodes")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	// Certificates are valid when the clock is not moved.
+	g.Expect(wcmux.VerifyCertificates()).To(BeEmpty())
+	g.Expect(get()).To(Succeed())
+
+	// Moving the clock past the certificate validity expires certificates, without waiting for it.
+	fakeClock.Step(2 * time.Hour)
+
+	g.Expect(wcmux.VerifyCertificates()).ToNot(BeEmpty())
+	g.Expect(get()).ToNot(Succeed())
+
+	err = wcmux.Shutdown(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+}
+
+func setupWorkloadClusterListener(g Gomega, opts ...WorkloadClustersMuxOption) (*WorkloadClustersMux, client.WithWatch) {
 	manager := cmanager.New(scheme)
 
 	host := "127.0.0.1"
-	wcmux, err := NewWorkloadClustersMux(manager, host, ports)
+	wcmux, err := NewWorkloadClustersMux(manager, host, opts...)
 	g.Expect(err).ToNot(HaveOccurred())
 
 	// InfraCluster controller >> when "creating the load balancer"
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// MuxSnapshot is a snapshot of the state of a WorkloadClustersMux, e.g. to be logged when a test fails.
+type MuxSnapshot struct {
+	// Host is the host address of the workload clusters mux.
+	Host string `json:"host"`
+
+	// MinPort and MaxPort define the port range of the workload clusters mux.
+	MinPort int `json:"minPort"`
+	MaxPort int `json:"maxPort"`
+
+	// FreePorts are the ports released by deleted listeners, which can be reused.
+	FreePorts []int `json:"freePorts,omitempty"`
+
+	// Listeners are the workload cluster listeners, sorted by name.
+	Listeners []ListenerSnapshot `json:"listeners"`
+}
+
+// ListenerSnapshot is a snapshot of the state of a WorkloadClusterListener.
+type ListenerSnapshot struct {
+	// Name is the name of the listener, which is also the name of the resource group.
+	Name string `json:"name"`
+
+	// Address is the address of the listener.
+	Address string `json:"address"`
+
+	// Started is true if the listener is started.
+	Started bool `json:"started"`
+
+	// APIServers are the API server pods added to the listener, sorted.
+	APIServers []string `json:"apiServers,omitempty"`
+
+	// APIServerUnhealthy is true if the API server is simulated as unhealthy.
+	APIServerUnhealthy bool `json:"apiServerUnhealthy,omitempty"`
+
+	// APIServerCertificateNotAfter is the expiry of the API server serving certificate, if any.
+	APIServerCertificateNotAfter *time.Time `json:"apiServerCertificateNotAfter,omitempty"`
+
+	// EtcdMembers are the etcd members added to the listener, sorted.
+	EtcdMembers []string `json:"etcdMembers,omitempty"`
+
+	// UnhealthyEtcdMembers are the etcd members simulated as unhealthy, sorted.
+	UnhealthyEtcdMembers []string `json:"unhealthyEtcdMembers,omitempty"`
+
+	// EtcdCertificatesNotAfter is the expiry of the etcd serving certificates, by etcd member.
+	EtcdCertificatesNotAfter map[string]time.Time `json:"etcdCertificatesNotAfter,omitempty"`
+
+	// IdleSince is the time since the listener has a port reserved but no API server, if any.
+	IdleSince *time.Time `json:"idleSince,omitempty"`
+}
+
+// String returns the snapshot as indented JSON.
+func (s MuxSnapshot) String() string {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("failed to marshal the workload clusters mux snapshot: %v", err)
+	}
+	return string(data)
+}
+
+// Snapshot returns a snapshot of the state of the workload clusters mux.
+func (m *WorkloadClustersMux) Snapshot() MuxSnapshot {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	snapshot := MuxSnapshot{
+		Host:      m.host,
+		MinPort:   m.minPort,
+		MaxPort:   m.maxPort,
+		FreePorts: sets.List(m.freePorts),
+		Listeners: []ListenerSnapshot{},
+	}
+	for wclName, wcl := range m.workloadClusterListeners {
+		listener := ListenerSnapshot{
+			Name:                 wclName,
+			Address:              wcl.Address(),
+			Started:              wcl.listener != nil,
+			APIServers:           sets.List(wcl.apiServers),
+			APIServerUnhealthy:   wcl.apiServerUnhealthy,
+			EtcdMembers:          sets.List(wcl.etcdMembers),
+			UnhealthyEtcdMembers: sets.List(wcl.unhealthyEtcdMembers),
+		}
+		if cert := leafCertificate(wcl.apiServerServingCertificate); cert != nil {
+			notAfter := cert.NotAfter
+			listener.APIServerCertificateNotAfter = &notAfter
+		}
+		for etcdMember, certificate := range wcl.etcdServingCertificates {
+			if cert := leafCertificate(certificate); cert != nil {
+				if listener.EtcdCertificatesNotAfter == nil {
+					listener.EtcdCertificatesNotAfter = map[string]time.Time{}
+				}
+				listener.EtcdCertificatesNotAfter[etcdMember] = cert.NotAfter
+			}
+		}
+		if wcl.apiServers.Len() == 0 {
+			idleSince := wcl.idleSince
+			listener.IdleSince = &idleSince
+		}
+		snapshot.Listeners = append(snapshot.Listeners, listener)
+	}
+	sort.Slice(snapshot.Listeners, func(i, j int) bool { return snapshot.Listeners[i].Name < snapshot.Listeners[j].Name })
+	return snapshot
+}
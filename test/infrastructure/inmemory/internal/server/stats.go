@@ -0,0 +1,132 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+)
+
+// requestStats tracks the requests served by a WorkloadClusterListener.
+type requestStats struct {
+	// requests is the number of requests served.
+	requests atomic.Int64
+
+	lock sync.Mutex
+	// lastErrorTime and lastErrorMessage define the last error served, if any.
+	lastErrorTime    time.Time
+	lastErrorMessage string
+}
+
+// recordRequest records a request being served.
+func (s *requestStats) recordRequest() {
+	s.requests.Add(1)
+}
+
+// recordError records an error being served.
+func (s *requestStats) recordError(now time.Time, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastErrorTime = now
+	s.lastErrorMessage = message
+}
+
+// lastError returns the last error served, if any.
+func (s *requestStats) lastError() (time.Time, string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastErrorTime, s.lastErrorMessage, s.lastErrorMessage != ""
+}
+
+// statusRecorder is an http.ResponseWriter recording the status code of the response, so it is possible to
+// identify the errors served.
+// NOTE: statusRecorder implements http.Flusher and http.Hijacker, which are required for watches and port forward.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so it can be used by http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// errorMessage returns a message describing the error served, if any.
+// NOTE: For gRPC requests errors are reported in the Grpc-Status header or trailer, because the status code is always 200.
+func (r *statusRecorder) errorMessage(req *http.Request) (string, bool) {
+	if r.status >= http.StatusInternalServerError {
+		return fmt.Sprintf("%d %s: %s %s", r.status, http.StatusText(r.status), req.Method, req.URL.Path), true
+	}
+
+	header := r.Header()
+	for _, prefix := range []string{"", http2.TrailerPrefix} {
+		grpcStatus := header.Get(prefix + "Grpc-Status")
+		if grpcStatus == "" || grpcStatus == "0" {
+			continue
+		}
+		code := grpcStatus
+		if c, err := strconv.Atoi(grpcStatus); err == nil {
+			code = codes.Code(c).String()
+		}
+		return fmt.Sprintf("gRPC %s: %s %s", code, req.URL.Path, header.Get(prefix+"Grpc-Message")), true
+	}
+	return "", false
+}
@@ -273,7 +273,7 @@ func setupReconcilers(ctx context.Context, mgr ctrl.Manager) {
 
 	// Start an http server
 	podIP := os.Getenv("POD_IP")
-	apiServerMux, err := server.NewWorkloadClustersMux(cloudMgr, podIP)
+	apiServerMux, err := server.NewWorkloadClustersMux(cloudMgr, podIP, server.WithLogger(ctrl.Log.WithName("workload-clusters-mux")))
 	if err != nil {
 		setupLog.Error(err, "unable to create workload clusters mux")
 		os.Exit(1)